@@ -1,31 +1,94 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Account struct {
-	Name      string `json:"name"`
-	Hash      []byte `json:"hash"`
-	CreatedTS int64  `json:"created_ts"`
+	Name        string `json:"name"`
+	Hash        []byte `json:"hash"`
+	CreatedTS   int64  `json:"created_ts"`
+	LastLoginTS int64  `json:"last_login_ts,omitempty"`
+	MaxSessions int    `json:"max_sessions,omitempty"` // 0 = network default
+
+	// Settings holds user preferences, validated by accountSettings.
+	Settings map[string]string `json:"settings,omitempty"`
+
+	// Nicks grouped to the account besides its own name (see nicks.go).
+	Nicks []string `json:"nicks,omitempty"`
+
+	Email        string `json:"email,omitempty"`
+	PendingEmail string `json:"pending_email,omitempty"` // awaiting confirm
+	ConfirmHash  string `json:"confirm_hash,omitempty"`  // SHA-256 of the confirm code
+	Pending      bool   `json:"pending,omitempty"`       // registered but not confirmed yet
+
+	// Failed logins since the last successful one, reported on next login.
+	FailCount int    `json:"fail_count,omitempty"`
+	FailFrom  string `json:"fail_from,omitempty"` // nick!user@host of the latest failure
+
+	// Two-factor: secrets are sealed with the TOTP key, recovery codes hashed.
+	TOTPSecret  []byte   `json:"totp_secret,omitempty"`
+	TOTPPending []byte   `json:"totp_pending,omitempty"` // awaiting totp confirm
+	TOTPLast    int64    `json:"totp_last,omitempty"`    // last accepted time step
+	Recovery    []string `json:"recovery,omitempty"`
+
+	// History holds the most recent login attempts, newest last.
+	History []AuthEvent `json:"history,omitempty"`
+
+	// ChallengeKey backs CHALLENGEAUTH (see challenge.go). It is only kept
+	// while ChallengeAuth is on, and is filled in on the next password login
+	// or password change after turning it on.
+	ChallengeAuth bool   `json:"challenge_auth,omitempty"`
+	ChallengeKey  string `json:"challenge_key,omitempty"`
+}
+
+// resetToken is a one-time password reset token; only its SHA-256 is stored.
+type resetToken struct {
+	Hash    string `json:"hash"`
+	Expires int64  `json:"expires"` // unix seconds
+}
+
+// AuthEvent is one login attempt and, for successful ones, how it ended.
+type AuthEvent struct {
+	TS       int64  `json:"ts"`
+	Nick     string `json:"nick"`
+	UserHost string `json:"userhost"`
+	IP       string `json:"ip,omitempty"`
+	Method   string `json:"method"` // password, totp, challenge, ...
+	OK       bool   `json:"ok"`
+	UID      string `json:"uid,omitempty"`
+	EndedTS  int64  `json:"ended_ts,omitempty"`
+	End      string `json:"end,omitempty"` // logout, quit, kill, ...
+}
+
+type session struct {
+	Account string
+	Since   int64 // unix seconds
 }
 
 type accountState struct {
-	Accounts map[string]Account `json:"accounts"` // key: lowercased name
+	Accounts map[string]Account    `json:"accounts"`         // key: lowercased name
+	Resets   map[string]resetToken `json:"resets,omitempty"` // key: lowercased name
 }
 
 type AccountDB struct {
 	file string
 	mu   sync.RWMutex
 	s    accountState
-	// live sessions: UID -> account name (original case)
-	sessions map[string]string
+	// live sessions: UID -> account name (original case) and login time
+	sessions map[string]session
+	policy   HashPolicy
 }
 
 var accDB = NewAccountDB("accounts.json")
@@ -33,11 +96,20 @@ var accDB = NewAccountDB("accounts.json")
 func NewAccountDB(path string) *AccountDB {
 	return &AccountDB{
 		file:     path,
-		s:        accountState{Accounts: make(map[string]Account)},
-		sessions: make(map[string]string),
+		s:        accountState{Accounts: make(map[string]Account), Resets: make(map[string]resetToken)},
+		sessions: make(map[string]session),
+		policy:   defaultHashPolicy(),
 	}
 }
 
+// SetHashPolicy sets how new passwords are hashed. Existing hashes are
+// upgraded on their next successful Verify.
+func (db *AccountDB) SetHashPolicy(p HashPolicy) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.policy = p
+}
+
 func (db *AccountDB) Load() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
@@ -55,13 +127,25 @@ func (db *AccountDB) Load() error {
 	if st.Accounts == nil {
 		st.Accounts = make(map[string]Account)
 	}
+	if st.Resets == nil {
+		st.Resets = make(map[string]resetToken)
+	}
+	for k, a := range st.Accounts {
+		if a.ChallengeKey != "" && !a.ChallengeAuth {
+			a.ChallengeKey = ""
+			st.Accounts[k] = a
+		}
+	}
 	db.s = st
 	return nil
 }
 
+// Save writes the database. It holds the write lock so that a save from a
+// password check running off the bus (see verifyAsync) can't interleave its
+// write with another.
 func (db *AccountDB) Save() error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	b, err := json.MarshalIndent(db.s, "", "  ")
 	if err != nil {
 		return err
@@ -79,7 +163,10 @@ func (db *AccountDB) Create(name, password string) error {
 	if _, exists := db.s.Accounts[lname]; exists {
 		return errors.New("account already exists")
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if db.groupedBy(lname) != "" {
+		return errors.New("name is a nick grouped to another account")
+	}
+	hash, err := db.policy.Hash(password)
 	if err != nil {
 		return err
 	}
@@ -91,21 +178,42 @@ func (db *AccountDB) Create(name, password string) error {
 	return nil
 }
 
+// Verify checks the password. On success, a hash made under an older policy
+// is transparently replaced with one made under the current policy.
 func (db *AccountDB) Verify(name, password string) bool {
 	lname := toLower(name)
 	db.mu.RLock()
 	acct, ok := db.s.Accounts[lname]
+	policy := db.policy
 	db.mu.RUnlock()
 	if !ok {
 		return false
 	}
-	return bcrypt.CompareHashAndPassword(acct.Hash, []byte(password)) == nil
+	if !policy.Verify(acct.Hash, password) {
+		return false
+	}
+	if policy.NeedsRehash(acct.Hash) {
+		if hash, err := policy.Hash(password); err == nil {
+			db.mu.Lock()
+			if cur, ok := db.s.Accounts[lname]; ok && string(cur.Hash) == string(acct.Hash) {
+				cur.Hash = hash
+				db.s.Accounts[lname] = cur
+			}
+			db.mu.Unlock()
+			_ = db.Save()
+		}
+	}
+	if acct.ChallengeAuth && acct.ChallengeKey == "" {
+		_ = db.update(lname, func(a *Account) { a.ChallengeKey = challengeKeyFor(password) })
+		_ = db.Save()
+	}
+	return true
 }
 
 func (db *AccountDB) Bind(uid, account string) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	db.sessions[uid] = account
+	db.sessions[uid] = session{Account: account, Since: time.Now().Unix()}
 }
 
 func (db *AccountDB) Unbind(uid string) {
@@ -124,5 +232,533 @@ func (db *AccountDB) IsAuthed(uid string) bool {
 func (db *AccountDB) SessionAccount(uid string) string {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	return db.sessions[uid]
+	return db.sessions[uid].Account
+}
+
+// SessionSince returns when uid logged in, or 0.
+func (db *AccountDB) SessionSince(uid string) int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.sessions[uid].Since
+}
+
+func (db *AccountDB) Exists(name string) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	_, ok := db.s.Accounts[toLower(name)]
+	return ok
+}
+
+// SetPassword replaces the password hash of an existing account and drops any
+// outstanding reset token.
+func (db *AccountDB) SetPassword(name, password string) error {
+	if password == "" {
+		return errors.New("empty password")
+	}
+	lname := toLower(name)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	acct, ok := db.s.Accounts[lname]
+	if !ok {
+		return errors.New("no such account")
+	}
+	hash, err := db.policy.Hash(password)
+	if err != nil {
+		return err
+	}
+	acct.Hash = hash
+	acct.ChallengeKey = ""
+	if acct.ChallengeAuth {
+		acct.ChallengeKey = challengeKeyFor(password)
+	}
+	db.s.Accounts[lname] = acct
+	delete(db.s.Resets, lname)
+	return nil
+}
+
+// IssueResetToken creates a one-time reset token for the account, replacing
+// any previous one. The plain token is returned and never stored.
+func (db *AccountDB) IssueResetToken(name string, ttl time.Duration) (string, error) {
+	lname := toLower(name)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.s.Accounts[lname]; !ok {
+		return "", errors.New("no such account")
+	}
+	tok, err := randomToken(12)
+	if err != nil {
+		return "", err
+	}
+	db.s.Resets[lname] = resetToken{
+		Hash:    hashToken(tok),
+		Expires: time.Now().Add(ttl).Unix(),
+	}
+	return tok, nil
+}
+
+// ConsumeResetToken reports whether token is the valid, unexpired reset token
+// for the account. A matching or expired token is removed.
+func (db *AccountDB) ConsumeResetToken(name, token string) bool {
+	lname := toLower(name)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	rt, ok := db.s.Resets[lname]
+	if !ok {
+		return false
+	}
+	if rt.Expires <= time.Now().Unix() {
+		delete(db.s.Resets, lname)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(rt.Hash), []byte(hashToken(token))) != 1 {
+		return false
+	}
+	delete(db.s.Resets, lname)
+	return true
+}
+
+// UnbindAccount ends every live session of the account and returns the UIDs
+// that were logged in.
+func (db *AccountDB) UnbindAccount(account string) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var uids []string
+	for uid, se := range db.sessions {
+		if strings.EqualFold(se.Account, account) {
+			uids = append(uids, uid)
+			delete(db.sessions, uid)
+		}
+	}
+	return uids
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+func (db *AccountDB) Get(name string) (Account, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	acct, ok := db.s.Accounts[toLower(name)]
+	return acct, ok
+}
+
+// IsPending reports whether the account still awaits email confirmation.
+func (db *AccountDB) IsPending(name string) bool {
+	acct, ok := db.Get(name)
+	return ok && acct.Pending
+}
+
+// Delete removes the account and any outstanding reset token. It returns the
+// account's display name.
+func (db *AccountDB) Delete(name string) (string, error) {
+	lname := toLower(name)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	acct, ok := db.s.Accounts[lname]
+	if !ok {
+		return "", errors.New("no such account")
+	}
+	delete(db.s.Accounts, lname)
+	delete(db.s.Resets, lname)
+	return acct.Name, nil
+}
+
+// RequestEmail stores email as the account's pending address and returns the
+// confirmation code to mail to it. With lock set, the account cannot log in
+// until the code is confirmed.
+func (db *AccountDB) RequestEmail(name, email string, lock bool) (string, error) {
+	lname := toLower(name)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	acct, ok := db.s.Accounts[lname]
+	if !ok {
+		return "", errors.New("no such account")
+	}
+	code, err := randomToken(5)
+	if err != nil {
+		return "", err
+	}
+	acct.PendingEmail = email
+	acct.ConfirmHash = hashToken(code)
+	if lock {
+		acct.Pending = true
+	}
+	db.s.Accounts[lname] = acct
+	return code, nil
+}
+
+// SetEmail sets the address directly, without verification.
+func (db *AccountDB) SetEmail(name, email string) error {
+	lname := toLower(name)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	acct, ok := db.s.Accounts[lname]
+	if !ok {
+		return errors.New("no such account")
+	}
+	acct.Email = email
+	db.s.Accounts[lname] = acct
+	return nil
+}
+
+// Confirm activates the pending email (and account) matching code and returns
+// the account name.
+func (db *AccountDB) Confirm(code string) (string, bool) {
+	h := hashToken(toLower(code))
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for k, acct := range db.s.Accounts {
+		if acct.ConfirmHash == "" || subtle.ConstantTimeCompare([]byte(acct.ConfirmHash), []byte(h)) != 1 {
+			continue
+		}
+		acct.Email = acct.PendingEmail
+		acct.PendingEmail = ""
+		acct.ConfirmHash = ""
+		acct.Pending = false
+		db.s.Accounts[k] = acct
+		return acct.Name, true
+	}
+	return "", false
+}
+
+// ExpirePending deletes the accounts still awaiting confirmation that were
+// registered before cutoff, and returns their names.
+func (db *AccountDB) ExpirePending(cutoff time.Time) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var gone []string
+	for k, acct := range db.s.Accounts {
+		if acct.Pending && acct.CreatedTS < cutoff.Unix() {
+			delete(db.s.Accounts, k)
+			delete(db.s.Resets, k)
+			gone = append(gone, acct.Name)
+		}
+	}
+	sort.Strings(gone)
+	return gone
+}
+
+// purgePending drops registrations left unconfirmed for longer than maxAge.
+// A maxAge of zero keeps them forever.
+func purgePending(log *Logger, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	gone := accDB.ExpirePending(time.Now().Add(-maxAge))
+	if len(gone) == 0 {
+		return
+	}
+	_ = accDB.Save()
+	log.Infof("dropped %d unconfirmed account(s): %s", len(gone), strings.Join(gone, ", "))
+}
+
+// runPendingPurge runs purgePending for the life of the process.
+func runPendingPurge(ctx context.Context, log *Logger, maxAge time.Duration) {
+	tk := time.NewTicker(10 * time.Minute)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			purgePending(log, maxAge)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// NoteFailure counts a failed login against the account.
+func (db *AccountDB) NoteFailure(name, from string) {
+	lname := toLower(name)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	acct, ok := db.s.Accounts[lname]
+	if !ok {
+		return
+	}
+	acct.FailCount++
+	acct.FailFrom = from
+	db.s.Accounts[lname] = acct
+}
+
+// TakeFailures returns and resets the failed-login counter.
+func (db *AccountDB) TakeFailures(name string) (int, string) {
+	lname := toLower(name)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	acct, ok := db.s.Accounts[lname]
+	if !ok || acct.FailCount == 0 {
+		return 0, ""
+	}
+	n, from := acct.FailCount, acct.FailFrom
+	acct.FailCount, acct.FailFrom = 0, ""
+	db.s.Accounts[lname] = acct
+	return n, from
+}
+
+// HasTOTP reports whether two-factor is active on the account.
+func (db *AccountDB) HasTOTP(name string) bool {
+	acct, ok := db.Get(name)
+	return ok && len(acct.TOTPSecret) > 0
+}
+
+// SetTOTPPending stores a sealed secret that becomes active on ConfirmTOTP.
+func (db *AccountDB) SetTOTPPending(name string, sealed []byte) error {
+	return db.update(name, func(a *Account) {
+		a.TOTPPending = sealed
+	})
+}
+
+// ConfirmTOTP activates the pending secret with the given recovery hashes.
+func (db *AccountDB) ConfirmTOTP(name string, recovery []string) error {
+	return db.update(name, func(a *Account) {
+		a.TOTPSecret = a.TOTPPending
+		a.TOTPPending = nil
+		a.TOTPLast = 0
+		a.Recovery = recovery
+	})
+}
+
+// DisableTOTP removes two-factor from the account.
+func (db *AccountDB) DisableTOTP(name string) error {
+	return db.update(name, func(a *Account) {
+		a.TOTPSecret = nil
+		a.TOTPPending = nil
+		a.TOTPLast = 0
+		a.Recovery = nil
+	})
+}
+
+// UseTOTPStep records step as used; it fails if step (or a later one) was
+// already accepted, so a code cannot be replayed.
+func (db *AccountDB) UseTOTPStep(name string, step int64) bool {
+	ok := false
+	_ = db.update(name, func(a *Account) {
+		if step > a.TOTPLast {
+			a.TOTPLast = step
+			ok = true
+		}
+	})
+	return ok
+}
+
+// UseRecoveryCode consumes a matching recovery code.
+func (db *AccountDB) UseRecoveryCode(name, code string) bool {
+	h := hashToken(toLower(code))
+	ok := false
+	_ = db.update(name, func(a *Account) {
+		for i, rc := range a.Recovery {
+			if subtle.ConstantTimeCompare([]byte(rc), []byte(h)) == 1 {
+				a.Recovery = append(a.Recovery[:i:i], a.Recovery[i+1:]...)
+				ok = true
+				return
+			}
+		}
+	})
+	return ok
+}
+
+// update applies fn to the stored account under the write lock.
+func (db *AccountDB) update(name string, fn func(*Account)) error {
+	lname := toLower(name)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	acct, ok := db.s.Accounts[lname]
+	if !ok {
+		return errors.New("no such account")
+	}
+	fn(&acct)
+	db.s.Accounts[lname] = acct
+	return nil
+}
+
+// SetChallengeAuth turns CHALLENGEAUTH on or off for the account. Turning it
+// off drops the stored key.
+func (db *AccountDB) SetChallengeAuth(name string, on bool) error {
+	return db.update(name, func(a *Account) {
+		a.ChallengeAuth = on
+		if !on {
+			a.ChallengeKey = ""
+		}
+	})
+}
+
+// Rename moves an account to a new name and rebinds its live sessions. It
+// returns the UIDs that were logged in.
+func (db *AccountDB) Rename(oldName, newName string) ([]string, error) {
+	lold, lnew := toLower(oldName), toLower(newName)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	acct, ok := db.s.Accounts[lold]
+	if !ok {
+		return nil, errors.New("no such account")
+	}
+	if _, exists := db.s.Accounts[lnew]; exists && lnew != lold {
+		return nil, errors.New("account already exists")
+	}
+	if owner := db.groupedBy(lnew); owner != "" && toLower(owner) != lold {
+		return nil, errors.New("name is a nick grouped to another account")
+	}
+	// The challenge key is a SHA-256 of the password alone, with no name in
+	// it, so it survives the rename. A pending reset token does not.
+	delete(db.s.Accounts, lold)
+	delete(db.s.Resets, lold)
+	acct.Name = newName
+	db.s.Accounts[lnew] = acct
+
+	var uids []string
+	for uid, se := range db.sessions {
+		if strings.EqualFold(se.Account, oldName) {
+			se.Account = newName
+			db.sessions[uid] = se
+			uids = append(uids, uid)
+		}
+	}
+	return uids, nil
+}
+
+// TouchLogin records a successful login time.
+func (db *AccountDB) TouchLogin(name string) {
+	_ = db.update(name, func(a *Account) { a.LastLoginTS = time.Now().Unix() })
+}
+
+// Sessions returns the UIDs currently logged in to the account.
+func (db *AccountDB) Sessions(account string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var uids []string
+	for uid, se := range db.sessions {
+		if strings.EqualFold(se.Account, account) {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool {
+		return db.sessions[uids[i]].Since < db.sessions[uids[j]].Since
+	})
+	return uids
+}
+
+// SetMaxSessions sets the account's own session limit (0 = network default).
+func (db *AccountDB) SetMaxSessions(name string, n int) error {
+	return db.update(name, func(a *Account) { a.MaxSessions = n })
+}
+
+// AddAuthEvent appends to the account's history, keeping the last max entries.
+func (db *AccountDB) AddAuthEvent(name string, ev AuthEvent, max int) {
+	_ = db.update(name, func(a *Account) {
+		a.History = append(a.History, ev)
+		if max > 0 && len(a.History) > max {
+			a.History = append([]AuthEvent(nil), a.History[len(a.History)-max:]...)
+		}
+	})
+}
+
+// EndAuthEvent marks uid's open login on the account as ended.
+func (db *AccountDB) EndAuthEvent(name, uid, how string) {
+	_ = db.update(name, func(a *Account) {
+		for i := len(a.History) - 1; i >= 0; i-- {
+			ev := &a.History[i]
+			if ev.OK && ev.UID == uid && ev.EndedTS == 0 {
+				ev.EndedTS = time.Now().Unix()
+				ev.End = how
+				return
+			}
+		}
+	})
+}
+
+// SetSetting stores a (validated) preference; an empty value resets it.
+func (db *AccountDB) SetSetting(name, key, value string) error {
+	return db.update(name, func(a *Account) {
+		if value == "" {
+			delete(a.Settings, key)
+			return
+		}
+		if a.Settings == nil {
+			a.Settings = make(map[string]string)
+		}
+		a.Settings[key] = value
+	})
+}
+
+// NickOwner returns the account that owns nick: the account of that name,
+// or the one that grouped it. It is empty for unowned nicks.
+func (db *AccountDB) NickOwner(nick string) string {
+	lnick := toLower(nick)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if acct, ok := db.s.Accounts[lnick]; ok {
+		return acct.Name
+	}
+	return db.groupedBy(lnick)
+}
+
+// groupedBy returns the account that grouped lnick; the caller holds db.mu.
+func (db *AccountDB) groupedBy(lnick string) string {
+	for _, acct := range db.s.Accounts {
+		for _, n := range acct.Nicks {
+			if toLower(n) == lnick {
+				return acct.Name
+			}
+		}
+	}
+	return ""
+}
+
+// GroupNick adds nick to the account's group, allowing at most max grouped
+// nicks (0 = unlimited).
+func (db *AccountDB) GroupNick(name, nick string, max int) error {
+	lname, lnick := toLower(name), toLower(nick)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	acct, ok := db.s.Accounts[lname]
+	if !ok {
+		return errors.New("no such account")
+	}
+	if lnick == lname {
+		return errors.New("that is your account name")
+	}
+	if other, exists := db.s.Accounts[lnick]; exists {
+		return errors.New("nick is owned by account " + other.Name)
+	}
+	if owner := db.groupedBy(lnick); owner != "" {
+		if owner == acct.Name {
+			return errors.New("nick is already grouped to your account")
+		}
+		return errors.New("nick is owned by account " + owner)
+	}
+	if max > 0 && len(acct.Nicks) >= max {
+		return errors.New("too many grouped nicks; ungroup one first")
+	}
+	acct.Nicks = append(acct.Nicks, nick)
+	db.s.Accounts[lname] = acct
+	return nil
+}
+
+// UngroupNick removes nick from the account's group.
+func (db *AccountDB) UngroupNick(name, nick string) error {
+	lnick := toLower(nick)
+	found := false
+	err := db.update(name, func(a *Account) {
+		for i, n := range a.Nicks {
+			if toLower(n) == lnick {
+				a.Nicks = append(a.Nicks[:i:i], a.Nicks[i+1:]...)
+				found = true
+				return
+			}
+		}
+	})
+	if err == nil && !found {
+		err = errors.New("nick is not grouped to your account")
+	}
+	return err
 }
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestResetToken(t *testing.T) {
+	db := NewAccountDB(filepath.Join(t.TempDir(), "accounts.json"))
+	if err := db.Create("Alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.IssueResetToken("nobody", time.Hour); err == nil {
+		t.Error("token issued for a missing account")
+	}
+
+	tok, err := db.IssueResetToken("alice", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt := db.s.Resets["alice"]; rt.Hash != hashToken(tok) {
+		t.Fatalf("stored token = %+v, want only its hash", rt)
+	}
+	steps := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"wrong token", tok + "x", false},
+		{"empty token", "", false},
+		{"right token", tok, true},
+		{"right token again", tok, false},
+	}
+	for _, s := range steps {
+		if got := db.ConsumeResetToken("ALICE", s.token); got != s.want {
+			t.Errorf("%s: ConsumeResetToken = %v, want %v", s.name, got, s.want)
+		}
+	}
+}
+
+func TestResetTokenReplacedAndExpired(t *testing.T) {
+	db := NewAccountDB(filepath.Join(t.TempDir(), "accounts.json"))
+	if err := db.Create("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := db.IssueResetToken("alice", time.Hour)
+	cur, _ := db.IssueResetToken("alice", time.Hour)
+	if db.ConsumeResetToken("alice", old) {
+		t.Error("replaced token still works")
+	}
+	if !db.ConsumeResetToken("alice", cur) {
+		t.Error("current token rejected")
+	}
+
+	expired, _ := db.IssueResetToken("alice", -time.Second)
+	if db.ConsumeResetToken("alice", expired) {
+		t.Error("expired token accepted")
+	}
+	if _, ok := db.s.Resets["alice"]; ok {
+		t.Error("expired token was kept")
+	}
+}
+
+func TestSetPasswordDropsResetToken(t *testing.T) {
+	db := NewAccountDB(filepath.Join(t.TempDir(), "accounts.json"))
+	if err := db.Create("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	tok, _ := db.IssueResetToken("alice", time.Hour)
+	if err := db.SetPassword("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if db.ConsumeResetToken("alice", tok) {
+		t.Error("token survived a password change")
+	}
+	if !db.Verify("alice", "secret") || db.Verify("alice", "hunter2") {
+		t.Error("SetPassword did not replace the password")
+	}
+}
+
+func TestExpirePending(t *testing.T) {
+	db := NewAccountDB(filepath.Join(t.TempDir(), "accounts.json"))
+	for _, n := range []string{"Alice", "bob", "carol"} {
+		if err := db.Create(n, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	codeA, _ := db.RequestEmail("alice", "alice@example.net", true)
+	_, _ = db.RequestEmail("bob", "bob@example.net", true)
+	_, _ = db.RequestEmail("carol", "carol@example.net", false) // setemail, not a registration
+	a := db.s.Accounts["alice"]
+	a.CreatedTS -= 3 * 3600
+	db.s.Accounts["alice"] = a
+	c := db.s.Accounts["carol"]
+	c.CreatedTS -= 3 * 3600
+	db.s.Accounts["carol"] = c
+
+	gone := db.ExpirePending(time.Now().Add(-2 * time.Hour))
+	if !slices.Equal(gone, []string{"Alice"}) {
+		t.Errorf("ExpirePending = %v, want [Alice]", gone)
+	}
+	if db.Exists("alice") || !db.Exists("bob") || !db.Exists("carol") {
+		t.Error("wrong accounts dropped")
+	}
+	if _, ok := db.Confirm(codeA); ok {
+		t.Error("code of a dropped registration still confirms")
+	}
+}
+
+// withAccountStores points the account, channel and suspension stores at a
+// temporary directory for the test.
+func withAccountStores(t *testing.T) {
+	oldDB, oldACL, oldQ, oldSuspend := accDB, acl, qStore, suspend
+	t.Cleanup(func() { accDB, acl, qStore, suspend = oldDB, oldACL, oldQ, oldSuspend })
+	dir := t.TempDir()
+	accDB = NewAccountDB(filepath.Join(dir, "accounts.json"))
+	acl = NewChanACLStore(filepath.Join(dir, "chan_access.json"))
+	qStore = NewStore(filepath.Join(dir, "state.json"))
+	suspend = NewSuspendStore(filepath.Join(dir, "suspended.json"))
+}
+
+func TestDropAccountCascade(t *testing.T) {
+	withAccountStores(t)
+	for _, n := range []string{"Alice", "bob", "carol"} {
+		if err := accDB.Create(n, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for ch, owner := range map[string]string{"#handed": "alice", "#orphan": "alice", "#bare": "alice", "#other": "bob"} {
+		qStore.PutChan(ch, owner)
+		if ch != "#bare" { // registered without an access list
+			acl.SetOwner(ch, owner)
+		}
+	}
+	_, _ = acl.SetFlags("#handed", "bob", "mo")
+	_, _ = acl.SetFlags("#orphan", "carol", "otv")
+	_, _ = acl.SetFlags("#other", "alice", "o")
+	suspend.SuspendAcc("alice", time.Now().Add(time.Hour).Unix(), "test")
+	accDB.Bind("042AAAAAA", "Alice")
+
+	l := &Link{Logger: NewLogger("error")}
+	name, handed, orphaned, err := doDropAccount(l, "ALICE")
+	if err != nil || name != "Alice" {
+		t.Fatalf("doDropAccount = %q, %v", name, err)
+	}
+	if len(handed) != 1 || handed[0] != "#handed -> bob" {
+		t.Errorf("handed = %v", handed)
+	}
+	if len(orphaned) != 2 || len(qStore.Orphans()) != 2 {
+		t.Errorf("orphaned = %v, queue = %v", orphaned, qStore.Orphans())
+	}
+
+	checks := []struct {
+		name string
+		ok   bool
+	}{
+		{"account deleted", !accDB.Exists("alice")},
+		{"session ended", accDB.SessionAccount("042AAAAAA") == ""},
+		{"suspension purged", !suspend.IsAccSuspended("alice")},
+		{"access removed", acl.Flags("#other", "alice") == ""},
+		{"successor owns the registration", len(qStore.OwnedBy("bob")) == 2},
+		{"orphan registrations unowned", len(qStore.OwnedBy("alice")) == 0},
+	}
+	for _, c := range checks {
+		if !c.ok {
+			t.Errorf("after drop: %s failed", c.name)
+		}
+	}
+	if _, _, _, err := doDropAccount(l, "alice"); err == nil {
+		t.Error("dropped a missing account")
+	}
+}
+
+func TestRenameAccountCascade(t *testing.T) {
+	withAccountStores(t)
+	for _, n := range []string{"alice", "bob"} {
+		if err := accDB.Create(n, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	qStore.PutChan("#chan", "alice")
+	acl.SetOwner("#chan", "alice")
+	acl.SetOwner("#bobs", "bob")
+	_, _ = acl.SetFlags("#bobs", "alice", "av")
+	suspend.SuspendAcc("alice", time.Now().Add(time.Hour).Unix(), "test")
+	accDB.Bind("042AAAAAA", "alice")
+
+	l := &Link{Logger: NewLogger("error")}
+	for _, bad := range [][2]string{{"nobody", "x"}, {"alice", "Bob"}} {
+		if err := doRenameAccount(l, bad[0], bad[1]); err == nil {
+			t.Errorf("rename %s -> %s accepted", bad[0], bad[1])
+		}
+	}
+	if err := doRenameAccount(l, "alice", "Alicia"); err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := acl.Owner("#chan")
+	checks := []struct {
+		name string
+		ok   bool
+	}{
+		{"old name gone", !accDB.Exists("alice")},
+		{"new name exists", accDB.Exists("alicia")},
+		{"session follows", accDB.SessionAccount("042AAAAAA") == "Alicia"},
+		{"channel owner", owner == "alicia"},
+		{"registration owner", len(qStore.OwnedBy("alicia")) == 1},
+		{"flags moved", acl.Flags("#bobs", "alicia") == "av" && acl.Flags("#bobs", "alice") == ""},
+		{"suspension moved", suspend.IsAccSuspended("alicia") && !suspend.IsAccSuspended("alice")},
+	}
+	for _, c := range checks {
+		if !c.ok {
+			t.Errorf("after rename: %s failed", c.name)
+		}
+	}
+}
+
+func TestInfoFormatting(t *testing.T) {
+	times := []struct {
+		ts   int64
+		want string
+	}{
+		{0, "1970-01-01 00:00 UTC"},
+		{1700000000, "2023-11-14 22:13 UTC"},
+	}
+	for _, tt := range times {
+		if got := fmtTime(tt.ts); got != tt.want {
+			t.Errorf("fmtTime(%d) = %q, want %q", tt.ts, got, tt.want)
+		}
+	}
+	access := []struct {
+		ca   ChanAccess
+		want string
+	}{
+		{ChanAccess{Channel: "#chan", Flags: "mnotv", Owner: true}, "#chan (+mnotv, owner)"},
+		{ChanAccess{Channel: "#chan", Flags: "ov"}, "#chan (+ov)"},
+	}
+	for _, tt := range access {
+		if got := fmtChanAccess(tt.ca); got != tt.want {
+			t.Errorf("fmtChanAccess(%+v) = %q, want %q", tt.ca, got, tt.want)
+		}
+	}
+}
+
+func TestLogoutTargets(t *testing.T) {
+	withAccountStores(t)
+	for uid, nick := range map[string]string{"042AAAAAA": "alice", "042AAAAAB": "alice_", "042AAAAAC": "alice__", "042AAAAAD": "bob"} {
+		setNick(uid, nick)
+		defer delNick(uid)
+	}
+	accDB.Bind("042AAAAAA", "alice")
+	accDB.Bind("042AAAAAB", "alice")
+	accDB.Bind("042AAAAAC", "alice")
+	accDB.Bind("042AAAAAD", "bob")
+
+	tests := []struct {
+		which string
+		want  []string
+		ok    bool
+	}{
+		{"all", []string{"042AAAAAB", "042AAAAAC"}, true},
+		{"ALICE_", []string{"042AAAAAB"}, true},
+		{"alice", []string{"042AAAAAA"}, true},
+		{"bob", nil, false},
+		{"nobody", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := logoutTargets("042AAAAAA", "alice", tt.which)
+		slices.Sort(got)
+		if ok != tt.ok || !slices.Equal(got, tt.want) {
+			t.Errorf("logoutTargets(%q) = %v, %v; want %v, %v", tt.which, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	withAccountStores(t)
+	for _, n := range []string{"alice", "bob"} {
+		if err := accDB.Create(n, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = accDB.SetMaxSessions("bob", 1)
+	accDB.Bind("042AAAAAA", "alice")
+	accDB.Bind("042AAAAAB", "alice")
+	accDB.Bind("042AAAAAC", "bob")
+
+	tests := []struct {
+		name     string
+		network  int
+		acc, uid string
+		wantN    int
+		full     bool
+	}{
+		{"no limit", 0, "alice", "042AAAAAZ", 0, false},
+		{"under the network limit", 3, "alice", "042AAAAAZ", 2, false},
+		{"at the network limit", 2, "alice", "042AAAAAZ", 2, true},
+		{"own session not counted", 2, "alice", "042AAAAAB", 1, false},
+		{"account limit", 0, "bob", "042AAAAAZ", 1, true},
+		{"lower of the two", 5, "bob", "042AAAAAZ", 1, true},
+	}
+	for _, tt := range tests {
+		l := &Link{Cfg: Config{MaxSessions: tt.network}}
+		a, _ := accDB.Get(tt.acc)
+		if n, full := sessionsFull(l, a, tt.uid); n != tt.wantN || full != tt.full {
+			t.Errorf("%s: sessionsFull = %d, %v; want %d, %v", tt.name, n, full, tt.wantN, tt.full)
+		}
+	}
+}
+
+func TestForgetUser(t *testing.T) {
+	withAccountStores(t)
+	if err := accDB.Create("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	const uid = "042AAAAAA"
+	setNick(uid, "alice")
+	defer delNick(uid)
+	accDB.Bind(uid, "alice")
+	accDB.AddAuthEvent("alice", AuthEvent{TS: 1, Method: "password", OK: true, UID: uid}, 10)
+	cs := newChanState(1)
+	cs.join(uid, "o")
+	chans["#forget"] = cs
+	defer delete(chans, "#forget")
+	opers[uid] = true
+
+	forgetUser(uid, "quit")
+	if accDB.SessionAccount(uid) != "" || getNick(uid) != "" || opers[uid] {
+		t.Error("session, nick or oper status survived")
+	}
+	if cs.Seen[uid] || cs.Ops[uid] {
+		t.Error("still a channel member")
+	}
+	a, _ := accDB.Get("alice")
+	if len(a.History) != 1 || a.History[0].End != "quit" || a.History[0].EndedTS == 0 {
+		t.Errorf("history = %+v", a.History)
+	}
+	forgetUser("", "quit") // no-op
+}
+
+func TestAuthHistory(t *testing.T) {
+	db := NewAccountDB(filepath.Join(t.TempDir(), "accounts.json"))
+	if err := db.Create("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		ev      AuthEvent
+		max     int
+		wantLen int
+		wantTS  int64 // oldest kept
+	}{
+		{"first", AuthEvent{TS: 1, OK: true, UID: "042AAAAAA"}, 3, 1, 1},
+		{"second", AuthEvent{TS: 2, UID: "042AAAAAB"}, 3, 2, 1},
+		{"at the bound", AuthEvent{TS: 3, OK: true, UID: "042AAAAAC"}, 3, 3, 1},
+		{"oldest dropped", AuthEvent{TS: 4, OK: true, UID: "042AAAAAA"}, 3, 3, 2},
+		{"bound lowered", AuthEvent{TS: 5, UID: "042AAAAAD"}, 2, 2, 4},
+		{"unbounded", AuthEvent{TS: 6, UID: "042AAAAAD"}, 0, 3, 4},
+	}
+	for _, tt := range tests {
+		db.AddAuthEvent("alice", tt.ev, tt.max)
+		a, _ := db.Get("alice")
+		if len(a.History) != tt.wantLen || a.History[0].TS != tt.wantTS {
+			t.Errorf("%s: history = %+v, want %d entries from ts %d", tt.name, a.History, tt.wantLen, tt.wantTS)
+		}
+	}
+
+	// only the latest open successful login of the uid is closed
+	db.EndAuthEvent("alice", "042AAAAAA", "logout")
+	db.EndAuthEvent("alice", "042AAAAAD", "quit") // failed logins have no session
+	a, _ := db.Get("alice")
+	for _, ev := range a.History {
+		ended := ev.End != ""
+		if want := ev.TS == 4; ended != want {
+			t.Errorf("event at ts %d ended = %v (%q), want %v", ev.TS, ended, ev.End, want)
+		}
+	}
+	db.AddAuthEvent("nobody", AuthEvent{TS: 1}, 3) // ignored
+}
@@ -0,0 +1,78 @@
+// autolimit.go
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Autolimit keeps +l limitoffset slots above a channel's user count. Joins
+// and parts only schedule an update; at most one runs per limitinterval, so
+// a busy channel sees one MODE now and then rather than one per join.
+
+var autolimits = struct {
+	sync.Mutex
+	last    map[string]time.Time // lower(#chan) -> last update
+	pending map[string]bool      // lower(#chan) -> update scheduled
+}{last: map[string]time.Time{}, pending: map[string]bool{}}
+
+// autolimitDelay is how long to wait before the next update, given the last
+// one. A short delay even when due lets bursts of joins settle first.
+func autolimitDelay(last, now time.Time, interval time.Duration) time.Duration {
+	const settle = 2 * time.Second
+	wait := last.Add(interval).Sub(now)
+	if wait < settle {
+		wait = settle
+	}
+	return wait
+}
+
+// autolimitTarget returns the +l autolimit wants, or 0 for no change.
+func autolimitTarget(users, offset, current int) int {
+	if want := users + offset; want != current {
+		return want
+	}
+	return 0
+}
+
+// scheduleAutolimit arranges an update of channel's limit.
+func scheduleAutolimit(l *Link, channel string) {
+	if !chanFlag(channel, "autolimit") {
+		return
+	}
+	interval, _ := strconv.Atoi(chanSetting(channel, "limitinterval"))
+	key := toLower(channel)
+	autolimits.Lock()
+	defer autolimits.Unlock()
+	if autolimits.pending[key] {
+		return
+	}
+	autolimits.pending[key] = true
+	wait := autolimitDelay(autolimits.last[key], time.Now(), time.Duration(interval)*time.Second)
+	time.AfterFunc(wait, func() {
+		autolimits.Lock()
+		delete(autolimits.pending, key)
+		autolimits.last[key] = time.Now()
+		autolimits.Unlock()
+		l.Post(func() { updateAutolimit(l, channel) })
+	})
+}
+
+// updateAutolimit sets channel's +l from its current user count. A mode
+// lock on l wins. It runs on the bus goroutine.
+func updateAutolimit(l *Link, channel string) {
+	cs := chans[toLower(channel)]
+	if cs == nil || !chanFlag(channel, "autolimit") {
+		return
+	}
+	for _, mc := range channelMLock(channel) {
+		if mc.Mode == 'l' {
+			return
+		}
+	}
+	offset, _ := strconv.Atoi(chanSetting(channel, "limitoffset"))
+	if want := autolimitTarget(len(cs.Seen), offset, cs.Limit); want > 0 {
+		l.FMode(cs.TS, channel, "+l", strconv.Itoa(want))
+	}
+}
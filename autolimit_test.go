@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutolimitDelay(t *testing.T) {
+	now := time.Now()
+	if got := autolimitDelay(time.Time{}, now, 30*time.Second); got != 2*time.Second {
+		t.Errorf("first update waits %s, want 2s", got)
+	}
+	if got := autolimitDelay(now.Add(-10*time.Second), now, 30*time.Second); got != 20*time.Second {
+		t.Errorf("update 10s after the last waits %s, want 20s", got)
+	}
+	if got := autolimitDelay(now.Add(-time.Hour), now, 30*time.Second); got != 2*time.Second {
+		t.Errorf("overdue update waits %s, want 2s", got)
+	}
+}
+
+func TestAutolimitTarget(t *testing.T) {
+	if got := autolimitTarget(25, 10, 0); got != 35 {
+		t.Errorf("target = %d, want 35", got)
+	}
+	if got := autolimitTarget(25, 10, 35); got != 0 {
+		t.Errorf("unchanged limit reset to %d", got)
+	}
+}
+
+func TestApplyChanModesTracksLimit(t *testing.T) {
+	chans["#limit"] = newChanState(1)
+	defer delete(chans, "#limit")
+	applyChanModes("#limit", "+l", []string{"42"})
+	if got := chans["#limit"].Limit; got != 42 {
+		t.Errorf("Limit = %d, want 42", got)
+	}
+	applyChanModes("#limit", "-l", nil)
+	if got := chans["#limit"].Limit; got != 0 {
+		t.Errorf("Limit after -l = %d, want 0", got)
+	}
+}
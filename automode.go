@@ -0,0 +1,54 @@
+// automode.go
+package main
+
+import "strings"
+
+// Auto-op and auto-voice. When a logged-in user joins a registered channel,
+// or logs in while already on it, Q gives them op or voice from their
+// chanlev flags: +a and +g always apply; plain op or voice access applies
+// while the account's autoop preference is on. +d and +q veto.
+
+// autoModeFor returns the prefix mode ('o', 'v' or 0) that flags earn.
+func autoModeFor(flags string, autoop bool) byte {
+	if strings.Contains(flags, "b") {
+		return 0
+	}
+	if !strings.Contains(flags, "d") && (strings.Contains(flags, "a") || (autoop && hasPriv(flags, 'o'))) {
+		return 'o'
+	}
+	if !strings.Contains(flags, "q") && (strings.Contains(flags, "g") || (autoop && hasPriv(flags, 'v'))) {
+		return 'v'
+	}
+	return 0
+}
+
+// autoMode returns the prefix mode uid should get on channel.
+func autoMode(channel, uid string) byte {
+	acc := accDB.SessionAccount(uid)
+	if acc == "" || suspend.IsAccSuspended(acc) || suspend.IsChanSuspended(channel) {
+		return 0
+	}
+	return autoModeFor(acl.Flags(channel, acc), uidSetting(uid, "autoop") == "on")
+}
+
+// giveAutoModes ops or voices those of uids who are entitled to it on
+// channel, at most maxModes changes per FMODE.
+func giveAutoModes(l *Link, channel string, uids []string) {
+	cs := chans[toLower(channel)]
+	if cs == nil {
+		return
+	}
+	if _, ok := acl.Owner(channel); !ok {
+		return
+	}
+	var changes []modeChange
+	for _, uid := range uids {
+		if uid == l.Cfg.ServiceUID || cs.Ops[uid] {
+			continue
+		}
+		if m := autoMode(channel, uid); m != 0 && !(m == 'v' && cs.Voices[uid]) {
+			changes = append(changes, modeChange{Adding: true, Mode: m, Param: uid})
+		}
+	}
+	sendModes(l, channel, cs.TS, changes)
+}
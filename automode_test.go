@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestAutoModeFor(t *testing.T) {
+	cases := []struct {
+		flags  string
+		autoop bool
+		want   byte
+	}{
+		{"mnotv", true, 'o'},
+		{"mnotv", false, 0},
+		{"aov", false, 'o'},
+		{"ov", true, 'o'},
+		{"dov", true, 'v'},
+		{"dgov", false, 'v'},
+		{"v", true, 'v'},
+		{"qv", true, 0},
+		{"g", false, 'v'},
+		{"k", true, 0},
+		{"abo", true, 0},
+	}
+	for _, c := range cases {
+		if got := autoModeFor(c.flags, c.autoop); got != c.want {
+			t.Errorf("autoModeFor(%q, %v) = %q, want %q", c.flags, c.autoop, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+// bans.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Channel bans kept by Q. A mask is a nick!user@host glob or, written as
+// account:<name>, an account (InspIRCd's account extban). Bans are enforced
+// when a matching user joins: Q sets the +b and kicks them. Tempbans carry
+// an expiry and are lifted from the channel and the store when it passes.
+// A ban may not cover the owner, nor anyone kick would refuse to remove.
+
+type ChanBan struct {
+	Mask   string `json:"mask"`
+	SetBy  string `json:"set_by"`
+	Reason string `json:"reason,omitempty"`
+	SetTS  int64  `json:"set_ts"`
+	Until  int64  `json:"until,omitempty"` // unix seconds; 0 = permanent
+}
+
+func (b *ChanBan) active(now int64) bool {
+	return b.Until == 0 || b.Until > now
+}
+
+// matches reports whether a user with the given masks (nick!user@host for
+// each host we know) and account is covered by the ban.
+func (b *ChanBan) matches(masks []string, account string) bool {
+	if name, ok := strings.CutPrefix(toLower(b.Mask), "account:"); ok {
+		return account != "" && globMatch(name, toLower(account))
+	}
+	for _, m := range masks {
+		if globMatch(toLower(b.Mask), toLower(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+type BanStore struct {
+	mu    sync.RWMutex
+	path  string
+	Chans map[string][]*ChanBan `json:"chans"` // lower(#chan) -> bans
+}
+
+var bans = NewBanStore("bans.json")
+
+func NewBanStore(path string) *BanStore {
+	return &BanStore{path: path, Chans: make(map[string][]*ChanBan)}
+}
+
+func (s *BanStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return err
+	}
+	if s.Chans == nil {
+		s.Chans = make(map[string][]*ChanBan)
+	}
+	return nil
+}
+
+func (s *BanStore) Save() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return writeJSONAtomic(s.path, s)
+}
+
+// Add stores a ban on channel, replacing one with the same mask.
+func (s *BanStore) Add(channel string, b ChanBan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := toLower(channel)
+	for i, old := range s.Chans[key] {
+		if strings.EqualFold(old.Mask, b.Mask) {
+			s.Chans[key][i] = &b
+			return
+		}
+	}
+	s.Chans[key] = append(s.Chans[key], &b)
+}
+
+// Del removes the ban with the given mask and reports whether it was there.
+func (s *BanStore) Del(channel, mask string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := toLower(channel)
+	list := s.Chans[key]
+	for i, b := range list {
+		if strings.EqualFold(b.Mask, mask) {
+			s.Chans[key] = append(list[:i:i], list[i+1:]...)
+			if len(s.Chans[key]) == 0 {
+				delete(s.Chans, key)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// List returns channel's active bans, oldest first.
+func (s *BanStore) List(channel string) []ChanBan {
+	now := time.Now().Unix()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []ChanBan
+	for _, b := range s.Chans[toLower(channel)] {
+		if b.active(now) {
+			out = append(out, *b)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].SetTS < out[j].SetTS })
+	return out
+}
+
+// Clear removes every ban on channel and returns them.
+func (s *BanStore) Clear(channel string) []ChanBan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := toLower(channel)
+	var out []ChanBan
+	for _, b := range s.Chans[key] {
+		out = append(out, *b)
+	}
+	delete(s.Chans, key)
+	return out
+}
+
+// Match returns the active ban on channel that covers the user.
+func (s *BanStore) Match(channel string, masks []string, account string) (ChanBan, bool) {
+	now := time.Now().Unix()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, b := range s.Chans[toLower(channel)] {
+		if b.active(now) && b.matches(masks, account) {
+			return *b, true
+		}
+	}
+	return ChanBan{}, false
+}
+
+// Expire drops bans whose time is up and returns them per channel.
+func (s *BanStore) Expire(now time.Time) map[string][]ChanBan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string][]ChanBan)
+	for key, list := range s.Chans {
+		keep := list[:0]
+		for _, b := range list {
+			if b.active(now.Unix()) {
+				keep = append(keep, b)
+			} else {
+				out[key] = append(out[key], *b)
+			}
+		}
+		if len(keep) == 0 {
+			delete(s.Chans, key)
+		} else {
+			s.Chans[key] = keep
+		}
+	}
+	return out
+}
+
+// runBanExpiry lifts expired tempbans for the life of the process. The
+// work is posted to the bus goroutine, which owns the channel state.
+func runBanExpiry(ctx context.Context, l *Link) {
+	tk := time.NewTicker(30 * time.Second)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			l.Post(func() { expireBans(l, time.Now()) })
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// expireBans drops tempbans that ran out and unsets them on the channels.
+func expireBans(l *Link, now time.Time) {
+	expired := bans.Expire(now)
+	if len(expired) == 0 {
+		return
+	}
+	_ = bans.Save()
+	for channel, list := range expired {
+		for _, b := range list {
+			l.QChanMode(channel, "-b", b.Mask)
+		}
+	}
+}
+
+// userBanMasks returns nick!user@host for every host we know of uid.
+func userBanMasks(uid string) []string {
+	nick := getNick(uid)
+	u, ok := getUser(uid)
+	if !ok {
+		return []string{nick + "!*@*"}
+	}
+	var out []string
+	for _, h := range []string{u.Host, u.VHost, u.IP} {
+		if h != "" {
+			out = append(out, nick+"!"+u.User+"@"+h)
+		}
+	}
+	return out
+}
+
+// banMaskFor turns a command argument into a ban mask: masks and
+// account:<name> are kept, the nick of an online user becomes *!*@host
+// (or account:<name> if they're logged in), any other word nick!*@*.
+func banMaskFor(tok string) string {
+	if strings.ContainsAny(tok, "!@") || strings.HasPrefix(toLower(tok), "account:") {
+		return tok
+	}
+	if uid := getUIDByNick(tok); uid != "" {
+		if acc := accDB.SessionAccount(uid); acc != "" {
+			return "account:" + acc
+		}
+		if u, ok := getUser(uid); ok {
+			host := u.VHost
+			if host == "" {
+				host = u.Host
+			}
+			return "*!*@" + host
+		}
+	}
+	return tok + "!*@*"
+}
+
+// banCovers reports whether b hits account, by name or through one of its
+// logged-in clients.
+func banCovers(b *ChanBan, account string) bool {
+	if b.matches(nil, account) {
+		return true
+	}
+	for _, uid := range accDB.Sessions(account) {
+		if b.matches(userBanMasks(uid), account) {
+			return true
+		}
+	}
+	return false
+}
+
+// banRefusals checks b against everyone on channel's chanlev it covers,
+// with the rules kick uses (see kickAllowed), and returns who it may not
+// hit and why.
+func banRefusals(actor, channel string, b *ChanBan) []string {
+	var out []string
+	for _, e := range acl.List(channel) {
+		if !banCovers(b, e.Account) {
+			continue
+		}
+		if err := kickAllowed(actor, e.Flags); err != nil {
+			out = append(out, e.Account+" "+err.Error())
+		}
+	}
+	return out
+}
+
+// enforceBans kicks and bans those of uids a stored ban covers and returns
+// the rest.
+func enforceBans(l *Link, channel string, uids []string) []string {
+	var keep []string
+	for _, uid := range uids {
+		if uid == l.Cfg.ServiceUID {
+			keep = append(keep, uid)
+			continue
+		}
+		b, ok := bans.Match(channel, userBanMasks(uid), accDB.SessionAccount(uid))
+		if !ok {
+			keep = append(keep, uid)
+			continue
+		}
+		l.QChanMode(channel, "+b", b.Mask)
+		l.Kick(channel, uid, banKickReason(b))
+	}
+	return keep
+}
+
+func banKickReason(b ChanBan) string {
+	if b.Reason == "" {
+		return "Banned."
+	}
+	return "Banned: " + b.Reason
+}
+
+// doBan runs ban, tempban, unban, banlist or banclear on channel for fromUID
+// and returns the lines to show. args are the words after the channel name.
+func doBan(l *Link, fromUID, cmd, channel string, args []string) []string {
+	if _, ok := acl.Owner(channel); !ok {
+		return []string{channel + " is not registered."}
+	}
+	need := byte('o')
+	if cmd == "banclear" {
+		need = 'm'
+	}
+	if !canControlChannel(fromUID, channel, need) {
+		return []string{"You need +" + string(need) + " on " + channel + " to use " + cmd + "."}
+	}
+
+	switch cmd {
+	case "ban", "tempban":
+		usage := "Usage: ban <mask|nick|account:name> [reason] | tempban <mask|nick|account:name> <duration> [reason]"
+		if len(args) < 1 || (cmd == "tempban" && len(args) < 2) {
+			return []string{usage}
+		}
+		b := ChanBan{
+			Mask:  banMaskFor(args[0]),
+			SetBy: userMask(fromUID),
+			SetTS: time.Now().Unix(),
+		}
+		rest := args[1:]
+		if cmd == "tempban" {
+			exp := rest[0]
+			if !strings.HasPrefix(exp, "+") {
+				exp = "+" + exp
+			}
+			d, err := parseExpiry(exp)
+			if err != nil {
+				return []string{"Duration looks like 30m, 12h, 7d or 2w."}
+			}
+			b.Until = time.Now().Add(d).Unix()
+			rest = rest[1:]
+		}
+		b.Reason = strings.Join(rest, " ")
+		if owner, _ := acl.Owner(channel); owner != "" && banCovers(&b, owner) {
+			return []string{"Not banning " + b.Mask + ": it covers " + owner + ", the owner of " + channel + "."}
+		}
+		actor := acl.Flags(channel, accDB.SessionAccount(fromUID))
+		if refused := banRefusals(actor, channel, &b); len(refused) > 0 {
+			return []string{"Not banning: " + strings.Join(refused, ", ") + "."}
+		}
+		bans.Add(channel, b)
+		_ = bans.Save()
+		l.QChanMode(channel, "+b", b.Mask)
+		if cs := chans[toLower(channel)]; cs != nil {
+			for uid := range cs.Seen {
+				if uid != l.Cfg.ServiceUID && b.matches(userBanMasks(uid), accDB.SessionAccount(uid)) {
+					l.Kick(channel, uid, banKickReason(b))
+				}
+			}
+		}
+		if b.Until > 0 {
+			return []string{"Banned " + b.Mask + " on " + channel + " until " + fmtTime(b.Until) + "."}
+		}
+		return []string{"Banned " + b.Mask + " on " + channel + "."}
+
+	case "unban":
+		if len(args) < 1 {
+			return []string{"Usage: unban <mask|nick|account:name>"}
+		}
+		mask := banMaskFor(args[0])
+		if !bans.Del(channel, mask) {
+			return []string{"No ban on " + mask + " in " + channel + "."}
+		}
+		_ = bans.Save()
+		l.QChanMode(channel, "-b", mask)
+		return []string{"Removed ban on " + mask + " in " + channel + "."}
+
+	case "banlist":
+		list := bans.List(channel)
+		out := make([]string, 0, len(list)+1)
+		for _, b := range list {
+			line := b.Mask + " (by " + b.SetBy + ", " + fmtTime(b.SetTS)
+			if b.Until > 0 {
+				line += ", expires " + fmtTime(b.Until)
+			}
+			line += ")"
+			if b.Reason != "" {
+				line += ": " + b.Reason
+			}
+			out = append(out, line)
+		}
+		return append(out, "End of bans for "+channel+" ("+strconv.Itoa(len(list))+").")
+
+	case "banclear":
+		list := bans.Clear(channel)
+		_ = bans.Save()
+		for _, b := range list {
+			l.QChanMode(channel, "-b", b.Mask)
+		}
+		return []string{"Removed " + strconv.Itoa(len(list)) + " ban(s) from " + channel + "."}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChanBanMatches(t *testing.T) {
+	masks := []string{"Bob!bob@host.example", "Bob!bob@bob.users.example", "Bob!bob@192.0.2.7"}
+	cases := []struct {
+		mask    string
+		account string
+		want    bool
+	}{
+		{"*!*@host.example", "", true},
+		{"*!*@192.0.2.*", "", true},
+		{"bob!*@*", "", true},
+		{"alice!*@*", "", false},
+		{"account:bob", "Bob", true},
+		{"account:bob", "", false},
+		{"account:b*", "bobby", true},
+	}
+	for _, c := range cases {
+		b := ChanBan{Mask: c.mask}
+		if got := b.matches(masks, c.account); got != c.want {
+			t.Errorf("ban %q on account %q = %v, want %v", c.mask, c.account, got, c.want)
+		}
+	}
+}
+
+func TestBanStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	s := NewBanStore(path)
+	now := time.Now()
+	s.Add("#Chan", ChanBan{Mask: "*!*@bad.example", SetTS: 1})
+	s.Add("#chan", ChanBan{Mask: "account:spammer", SetTS: 2, Until: now.Add(time.Hour).Unix()})
+	s.Add("#chan", ChanBan{Mask: "*!*@BAD.example", SetTS: 3, Reason: "again"})
+	if got := s.List("#chan"); len(got) != 2 || got[1].Reason != "again" {
+		t.Fatalf("List = %+v", got)
+	}
+	if _, ok := s.Match("#CHAN", []string{"x!y@bad.example"}, ""); !ok {
+		t.Error("host ban did not match")
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2 := NewBanStore(path)
+	if err := s2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if expired := s2.Expire(now.Add(2 * time.Hour)); len(expired["#chan"]) != 1 || expired["#chan"][0].Mask != "account:spammer" {
+		t.Errorf("Expire = %+v", expired)
+	}
+	if !s2.Del("#chan", "*!*@bad.example") || s2.Del("#chan", "*!*@bad.example") {
+		t.Error("Del should succeed once")
+	}
+	if got := s2.List("#chan"); len(got) != 0 {
+		t.Errorf("bans left: %+v", got)
+	}
+}
+
+func TestBanMaskFor(t *testing.T) {
+	for tok, want := range map[string]string{
+		"*!*@host":          "*!*@host",
+		"account:alice":     "account:alice",
+		"someone":           "someone!*@*",
+		"user@host.example": "user@host.example",
+	} {
+		if got := banMaskFor(tok); got != want {
+			t.Errorf("banMaskFor(%q) = %q, want %q", tok, got, want)
+		}
+	}
+}
+
+func TestExpireBans(t *testing.T) {
+	oldBans := bans
+	defer func() { bans = oldBans; delete(chans, "#expire") }()
+	bans = NewBanStore(filepath.Join(t.TempDir(), "bans.json"))
+	now := time.Now()
+	bans.Add("#expire", ChanBan{Mask: "*!*@old.example", Until: now.Add(-time.Minute).Unix()})
+	bans.Add("#expire", ChanBan{Mask: "*!*@new.example", Until: now.Add(time.Hour).Unix()})
+	cs := newChanState(1)
+	cs.Bans["*!*@old.example"] = true
+	cs.Bans["*!*@new.example"] = true
+	chans["#expire"] = cs
+
+	l := &Link{Logger: NewLogger("error")}
+	expireBans(l, now)
+	if cs.Bans["*!*@old.example"] || !cs.Bans["*!*@new.example"] {
+		t.Errorf("channel bans after expiry = %v", cs.Bans)
+	}
+	if got := bans.List("#expire"); len(got) != 1 || got[0].Mask != "*!*@new.example" {
+		t.Errorf("stored bans = %+v", got)
+	}
+}
+
+func TestDoBanAccess(t *testing.T) {
+	withAccountStores(t)
+	oldBans := bans
+	defer func() { bans = oldBans; delNick("001AAAAAA") }()
+	bans = NewBanStore(filepath.Join(t.TempDir(), "bans.json"))
+	for _, n := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		if err := accDB.Create(n, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acl.SetOwner("#chan", "alice")
+	_, _ = acl.SetFlags("#chan", "alice", "n")
+	_, _ = acl.SetFlags("#chan", "bob", "mo")
+	_, _ = acl.SetFlags("#chan", "carol", "o")
+	_, _ = acl.SetFlags("#chan", "dave", "pv")
+	setNick("001AAAAAA", "Alice")
+	setUser("001AAAAAA", userInfo{User: "alice", Host: "alice.example"})
+	accDB.Bind("001AAAAAA", "alice")
+	accDB.Bind("001AAAAAB", "bob")
+	accDB.Bind("001AAAAAC", "carol")
+
+	l := &Link{Logger: NewLogger("error")}
+	cases := []struct {
+		actor, mask string
+		ok          bool
+	}{
+		{"001AAAAAC", "account:erin", true},
+		{"001AAAAAC", "account:bob", false},   // higher access
+		{"001AAAAAC", "account:carol", false}, // equal access
+		{"001AAAAAC", "account:dave", false},  // protected
+		{"001AAAAAB", "account:carol", true},
+		{"001AAAAAB", "account:dave", false}, // protected from masters too
+		{"001AAAAAB", "account:a*", false},   // covers the owner
+		{"001AAAAAB", "*!*@alice.example", false},
+	}
+	for _, c := range cases {
+		doBan(l, c.actor, "ban", "#chan", []string{c.mask})
+		set := len(bans.List("#chan")) == 1
+		if set != c.ok {
+			t.Errorf("%s banning %s: set %v, want %v", accDB.SessionAccount(c.actor), c.mask, set, c.ok)
+		}
+		bans.Del("#chan", c.mask)
+	}
+}
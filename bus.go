@@ -1,16 +1,30 @@
 package main
 
-import "strings"
+import (
+	"context"
+	"strings"
+)
 
 type Handler func(*Link, *Message)
 
+// Bus dispatches inbound messages to handlers on a single goroutine (Run).
+// Service state (chans, the nick maps, sessions) is only touched there, so
+// timers hand their work over with Post instead of running it themselves.
 type Bus struct {
 	handlers map[string][]Handler
 	log      *Logger
+
+	queue chan func()
+	done  chan struct{}
 }
 
 func NewBus(log *Logger) *Bus {
-	return &Bus{handlers: make(map[string][]Handler), log: log}
+	return &Bus{
+		handlers: make(map[string][]Handler),
+		log:      log,
+		queue:    make(chan func(), 1024),
+		done:     make(chan struct{}),
+	}
 }
 
 func (b *Bus) On(verb string, h Handler) {
@@ -31,3 +45,25 @@ func (b *Bus) Emit(l *Link, msg *Message) {
 		}
 	}
 }
+
+// Run executes queued messages and callbacks in order until ctx is done.
+func (b *Bus) Run(ctx context.Context) {
+	defer close(b.done)
+	for {
+		select {
+		case fn := <-b.queue:
+			fn()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Post queues fn to run on the bus goroutine. Once the bus has stopped
+// (the link went down) fn is dropped. Handlers must not call Post.
+func (b *Bus) Post(fn func()) {
+	select {
+	case b.queue <- fn:
+	case <-b.done:
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBusPostRunsInOrder(t *testing.T) {
+	b := NewBus(NewLogger("error"))
+	var got []string
+	b.On("PING", func(_ *Link, m *Message) { got = append(got, m.Command) })
+	b.Post(func() { b.Emit(nil, &Message{Command: "PING"}) })
+	b.Post(func() { got = append(got, "timer") })
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	b.Post(func() { close(finished) })
+	go b.Run(ctx)
+	<-finished
+	if len(got) != 2 || got[0] != "PING" || got[1] != "timer" {
+		t.Errorf("ran %v; want [PING timer]", got)
+	}
+
+	cancel()
+	<-b.done
+	posted := make(chan struct{})
+	go func() {
+		for i := 0; i < cap(b.queue)+1; i++ {
+			b.Post(func() { t.Error("ran after stop") })
+		}
+		close(posted)
+	}()
+	select {
+	case <-posted:
+	case <-time.After(time.Second):
+		t.Error("Post blocked on a stopped bus")
+	}
+}
+
+func TestVerifyAsync(t *testing.T) {
+	withAccountStores(t)
+	oldVerifying := verifying
+	defer func() { verifying = oldVerifying }()
+	verifying = make(map[string]bool)
+	if err := accDB.Create("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBus(NewLogger("error"))
+	l := &Link{Logger: NewLogger("error"), Bus: b}
+
+	got := make(map[string]bool)
+	record := func(uid string) func(bool) {
+		return func(ok bool) { got[uid] = ok }
+	}
+	if !verifyAsync(l, "001AAAAAA", "alice", "hunter2", record("001AAAAAA")) {
+		t.Fatal("first check refused")
+	}
+	if verifyAsync(l, "001AAAAAA", "alice", "hunter2", record("001AAAAAA")) {
+		t.Error("second check started while the first was running")
+	}
+	verifyAsync(l, "001AAAAAB", "alice", "wrong", record("001AAAAAB"))
+	verifyAsync(l, "001AAAAAC", "alice", "hunter2", record("001AAAAAC"))
+	forgetUser("001AAAAAC", "quit")
+
+	// the results wait for the bus
+	deadline := time.Now().Add(5 * time.Second)
+	for len(b.queue) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(got) != 0 {
+		t.Fatalf("results delivered off the bus: %v", got)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	finished := make(chan struct{})
+	b.Post(func() { close(finished) })
+	go b.Run(ctx)
+	<-finished
+
+	ok, delivered := got["001AAAAAA"]
+	if !delivered || !ok {
+		t.Errorf("right password: got %v, %v", ok, delivered)
+	}
+	if ok, delivered := got["001AAAAAB"]; !delivered || ok {
+		t.Errorf("wrong password: got %v, %v", ok, delivered)
+	}
+	if _, delivered := got["001AAAAAC"]; delivered {
+		t.Error("result delivered for a client that quit")
+	}
+	if len(verifying) != 0 {
+		t.Errorf("checks still marked running: %v", verifying)
+	}
+}
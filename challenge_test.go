@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// qResponse computes a CHALLENGEAUTH response the way a client script does.
+func qResponse(account, password, nonce string) string {
+	if len(password) > 10 {
+		password = password[:10]
+	}
+	pw := sha256.Sum256([]byte(password))
+	key := sha256.Sum256([]byte(ircLower(account) + ":" + hex.EncodeToString(pw[:])))
+	mac := hmac.New(sha256.New, []byte(hex.EncodeToString(key[:])))
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestChallengeResponse(t *testing.T) {
+	stored := challengeKeyFor("hunter2hunter2")
+	nonce := "0123456789abcdef"
+	tests := []struct {
+		name     string
+		account  string
+		response string
+		want     bool
+	}{
+		{"exact", "Alice", qResponse("alice", "hunter2hunter2", nonce), true},
+		{"upper-case hex", "Alice", strings.ToUpper(qResponse("alice", "hunter2hunter2", nonce)), true},
+		{"rfc1459 case", "Al[ce]", qResponse("al{ce}", "hunter2hunter2", nonce), true},
+		{"only 10 chars count", "Alice", qResponse("alice", "hunter2hunXXXX", nonce), true},
+		{"wrong password", "Alice", qResponse("alice", "hunter3", nonce), false},
+		{"wrong nonce", "Alice", qResponse("alice", "hunter2hunter2", "other"), false},
+		{"wrong account", "Bob", qResponse("alice", "hunter2hunter2", nonce), false},
+		{"empty", "Alice", "", false},
+	}
+	for _, tt := range tests {
+		if got := challengeResponseOK(tt.account, stored, nonce, tt.response); got != tt.want {
+			t.Errorf("%s: challengeResponseOK = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTakeChallenge(t *testing.T) {
+	nonce, err := newChallenge("001AAAAAA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := takeChallenge("001AAAAAA"); !ok || got != nonce {
+		t.Fatalf("takeChallenge = %q, %v", got, ok)
+	}
+	if _, ok := takeChallenge("001AAAAAA"); ok {
+		t.Error("challenge used twice")
+	}
+
+	_, _ = newChallenge("001AAAAAB")
+	challengeMu.Lock()
+	c := challenges["001AAAAAB"]
+	c.expires = time.Now().Add(-time.Second)
+	challenges["001AAAAAB"] = c
+	challengeMu.Unlock()
+	if _, ok := takeChallenge("001AAAAAB"); ok {
+		t.Error("expired challenge accepted")
+	}
+}
+
+func TestIRCLower(t *testing.T) {
+	tests := map[string]string{
+		"Alice":   "alice",
+		"[Foo]":   "{foo}",
+		`Back\~`:  "back|^",
+		"{a|b^c}": "{a|b^c}",
+	}
+	for in, want := range tests {
+		if got := ircLower(in); got != want {
+			t.Errorf("ircLower(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// The key is password-equivalent for CHALLENGEAUTH, so it is only stored
+// while the account has challengeauth turned on.
+func TestChallengeKeyOptIn(t *testing.T) {
+	db := NewAccountDB(filepath.Join(t.TempDir(), "accounts.json"))
+	key := func() string {
+		a, _ := db.Get("alice")
+		return a.ChallengeKey
+	}
+	if err := db.Create("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name string
+		do   func()
+		want string
+	}{
+		{"new account", func() {}, ""},
+		{"login without opt-in", func() { db.Verify("alice", "hunter2") }, ""},
+		{"opt in", func() { _ = db.SetChallengeAuth("alice", true) }, ""},
+		{"login after opt-in", func() { db.Verify("alice", "hunter2") }, challengeKeyFor("hunter2")},
+		{"password change", func() { _ = db.SetPassword("alice", "secret") }, challengeKeyFor("secret")},
+		{"opt out", func() { _ = db.SetChallengeAuth("alice", false) }, ""},
+		{"password change after opt-out", func() { _ = db.SetPassword("alice", "hunter2") }, ""},
+	}
+	for _, s := range steps {
+		s.do()
+		if got := key(); got != s.want {
+			t.Errorf("%s: ChallengeKey = %q, want %q", s.name, got, s.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"sort"
 	"strings"
@@ -9,8 +10,16 @@ import (
 )
 
 type ChanACL struct {
-	Owner  string         `json:"owner"`  // account name
-	Levels map[string]int `json:"levels"` // account -> level (1..500)
+	Owner string            `json:"owner"`           // account name; always holds +n
+	Flags map[string]string `json:"flags"`           // account -> chanlev flags (see chanlev.go)
+	MLock string            `json:"mlock,omitempty"` // e.g. "+ntl-s 50" (see mlock.go)
+	Topic ChanTopic         `json:"topic"`
+
+	// Settings holds channel behaviour, validated by chanSettings.
+	Settings map[string]string `json:"settings,omitempty"`
+
+	// Levels is the old 1-500 access model, only read to migrate it.
+	Levels map[string]int `json:"levels,omitempty"`
 }
 
 type ChanACLStore struct {
@@ -40,7 +49,29 @@ func (s *ChanACLStore) Load() error {
 	defer f.Close()
 
 	dec := json.NewDecoder(f)
-	return dec.Decode(&s.data)
+	if err := dec.Decode(&s.data); err != nil {
+		return err
+	}
+	for _, acl := range s.data {
+		migrateLevels(acl)
+	}
+	return nil
+}
+
+// migrateLevels turns numeric levels into chanlev flags (see levelToFlags).
+func migrateLevels(acl *ChanACL) {
+	if acl.Flags == nil {
+		acl.Flags = make(map[string]string)
+	}
+	for acc, lvl := range acl.Levels {
+		if f := levelToFlags(lvl); f != "" {
+			acl.Flags[acc] = normFlags(acl.Flags[acc] + f)
+		}
+	}
+	acl.Levels = nil
+	if acl.Owner != "" {
+		acl.Flags[acl.Owner] = normFlags(acl.Flags[acl.Owner] + levelToFlags(500))
+	}
 }
 
 func (s *ChanACLStore) Save() error {
@@ -67,7 +98,7 @@ func (s *ChanACLStore) ensure(ch string) *ChanACL {
 	key := strings.ToLower(ch)
 	acl := s.data[key]
 	if acl == nil {
-		acl = &ChanACL{Levels: make(map[string]int)}
+		acl = &ChanACL{Flags: make(map[string]string)}
 		s.data[key] = acl
 	}
 	return acl
@@ -79,10 +110,7 @@ func (s *ChanACLStore) SetOwner(channel, account string) {
 	account = strings.ToLower(account) // ← normalize
 	acl := s.ensure(channel)
 	acl.Owner = account
-	if acl.Levels == nil {
-		acl.Levels = make(map[string]int)
-	}
-	acl.Levels[account] = 500
+	acl.Flags[account] = normFlags(acl.Flags[account] + levelToFlags(500))
 }
 
 func (s *ChanACLStore) Owner(channel string) (string, bool) {
@@ -95,59 +123,127 @@ func (s *ChanACLStore) Owner(channel string) (string, bool) {
 	return acl.Owner, true
 }
 
-func (s *ChanACLStore) SetLevel(channel, account string, level int) {
-	if level < 0 {
-		level = 0
-	}
-	if level > 500 {
-		level = 500
+// Flags returns the account's chanlev flags on channel.
+func (s *ChanACLStore) Flags(channel, account string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	acl := s.data[strings.ToLower(channel)]
+	if acl == nil || account == "" {
+		return ""
 	}
+	return acl.Flags[strings.ToLower(account)]
+}
+
+// SetFlags replaces the account's flags (empty removes the entry) and
+// returns them normalized. The channel owner always keeps +n.
+func (s *ChanACLStore) SetFlags(channel, account, flags string) (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	account = strings.ToLower(account) // ← normalize
+	flags = normFlags(flags)
 	acl := s.ensure(channel)
-	if level == 0 {
-		if account != acl.Owner {
-			delete(acl.Levels, account)
-		} else {
-			acl.Levels[account] = 500
-		}
-		return
+	if account == acl.Owner && !strings.Contains(flags, "n") {
+		return "", errors.New("the channel owner keeps +n")
+	}
+	if flags == "" {
+		delete(acl.Flags, account)
+		return "", nil
 	}
-	acl.Levels[account] = level
+	acl.Flags[account] = flags
+	return flags, nil
 }
 
-func (s *ChanACLStore) GetLevel(channel, account string) int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *ChanACLStore) DelUser(channel, account string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	account = strings.ToLower(account) // ← normalize
 	acl := s.data[strings.ToLower(channel)]
 	if acl == nil {
-		return 0
+		return
 	}
 	if account == acl.Owner {
-		return 500
+		return
+	} // don’t remove owner here
+	delete(acl.Flags, account)
+}
+
+// MLock returns the channel's mode lock, "" if none.
+func (s *ChanACLStore) MLock(channel string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if acl := s.data[strings.ToLower(channel)]; acl != nil {
+		return acl.MLock
 	}
-	return acl.Levels[account]
+	return ""
 }
 
-func (s *ChanACLStore) DelUser(channel, account string) {
+// SetMLock stores the channel's mode lock; "" removes it.
+func (s *ChanACLStore) SetMLock(channel, lock string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if acl := s.data[strings.ToLower(channel)]; acl != nil {
+		acl.MLock = lock
+	}
+}
+
+// Topic returns the channel's stored topic and settings; ok is false for
+// unregistered channels.
+func (s *ChanACLStore) Topic(channel string) (ChanTopic, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	acl := s.data[strings.ToLower(channel)]
+	if acl == nil || acl.Owner == "" {
+		return ChanTopic{}, false
+	}
+	return acl.Topic, true
+}
+
+// SetTopic replaces the channel's stored topic and settings.
+func (s *ChanACLStore) SetTopic(channel string, t ChanTopic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if acl := s.data[strings.ToLower(channel)]; acl != nil {
+		acl.Topic = t
+	}
+}
+
+// Settings returns a copy of the channel's settings; ok is false for
+// unregistered channels.
+func (s *ChanACLStore) Settings(channel string) (map[string]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	acl := s.data[strings.ToLower(channel)]
+	if acl == nil || acl.Owner == "" {
+		return nil, false
+	}
+	out := make(map[string]string, len(acl.Settings))
+	for k, v := range acl.Settings {
+		out[k] = v
+	}
+	return out, true
+}
+
+// SetSetting stores a (validated) channel setting; an empty value resets it.
+func (s *ChanACLStore) SetSetting(channel, key, value string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	account = strings.ToLower(account) // ← normalize
 	acl := s.data[strings.ToLower(channel)]
 	if acl == nil {
 		return
 	}
-	if account == acl.Owner {
+	if value == "" {
+		delete(acl.Settings, key)
 		return
-	} // don’t remove owner here
-	delete(acl.Levels, account)
+	}
+	if acl.Settings == nil {
+		acl.Settings = make(map[string]string)
+	}
+	acl.Settings[key] = value
 }
 
 type AccessEntry struct {
 	Account string `json:"account"`
-	Level   int    `json:"level"`
+	Flags   string `json:"flags"`
 }
 
 func (s *ChanACLStore) Channels() []string {
@@ -162,6 +258,7 @@ func (s *ChanACLStore) Channels() []string {
 	return out
 }
 
+// List returns the channel's chanlev, highest ranked first.
 func (s *ChanACLStore) List(channel string) []AccessEntry {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -169,29 +266,93 @@ func (s *ChanACLStore) List(channel string) []AccessEntry {
 	if acl == nil {
 		return nil
 	}
-	out := make([]AccessEntry, 0, len(acl.Levels))
-	for acc, lvl := range acl.Levels {
-		out = append(out, AccessEntry{Account: acc, Level: lvl})
+	out := make([]AccessEntry, 0, len(acl.Flags))
+	for acc, flags := range acl.Flags {
+		out = append(out, AccessEntry{Account: acc, Flags: flags})
 	}
-	// make sure owner is present & level 500
-	if acl.Owner != "" {
-		found := false
-		for i := range out {
-			if out[i].Account == acl.Owner {
-				out[i].Level = 500
-				found = true
-				break
+	sort.Slice(out, func(i, j int) bool {
+		ri, rj := flagRank(out[i].Flags), flagRank(out[j].Flags)
+		if ri == rj {
+			return out[i].Account < out[j].Account
+		}
+		return ri > rj
+	})
+	return out
+}
+
+// DropAccount removes the account from every channel. For each channel it
+// owned, the result maps the channel to its successor: the best-ranked
+// remaining master (+m), who becomes owner, or "" if there is none.
+func (s *ChanACLStore) DropAccount(account string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	account = strings.ToLower(account)
+	owned := make(map[string]string)
+	for ch, acl := range s.data {
+		delete(acl.Flags, account)
+		if acl.Owner != account {
+			continue
+		}
+		succ, best := "", 0
+		for acc, flags := range acl.Flags {
+			if !hasPriv(flags, 'm') {
+				continue // only a master may inherit the channel
+			}
+			r := flagRank(flags)
+			if succ == "" || r > best || (r == best && acc < succ) {
+				succ, best = acc, r
 			}
 		}
-		if !found {
-			out = append(out, AccessEntry{Account: acl.Owner, Level: 500})
+		acl.Owner = succ
+		if succ != "" {
+			acl.Flags[succ] = normFlags(acl.Flags[succ] + levelToFlags(500))
+		}
+		owned[ch] = succ
+	}
+	return owned
+}
+
+// RenameAccount moves the account's owner status and flags to a new name.
+func (s *ChanACLStore) RenameAccount(oldAcc, newAcc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldAcc, newAcc = strings.ToLower(oldAcc), strings.ToLower(newAcc)
+	for _, acl := range s.data {
+		if flags, ok := acl.Flags[oldAcc]; ok {
+			delete(acl.Flags, oldAcc)
+			acl.Flags[newAcc] = flags
+		}
+		if acl.Owner == oldAcc {
+			acl.Owner = newAcc
+		}
+	}
+}
+
+// ChanAccess is one channel an account has access on.
+type ChanAccess struct {
+	Channel string
+	Flags   string
+	Owner   bool
+}
+
+// AccessFor walks every channel and returns the account's access, highest
+// ranked first.
+func (s *ChanACLStore) AccessFor(account string) []ChanAccess {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	account = strings.ToLower(account)
+	var out []ChanAccess
+	for ch, acl := range s.data {
+		if flags := acl.Flags[account]; flags != "" {
+			out = append(out, ChanAccess{Channel: ch, Flags: flags, Owner: acl.Owner == account})
 		}
 	}
 	sort.Slice(out, func(i, j int) bool {
-		if out[i].Level == out[j].Level {
-			return out[i].Account < out[j].Account
+		ri, rj := flagRank(out[i].Flags), flagRank(out[j].Flags)
+		if ri == rj {
+			return out[i].Channel < out[j].Channel
 		}
-		return out[i].Level > out[j].Level
+		return ri > rj
 	})
 	return out
 }
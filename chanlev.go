@@ -0,0 +1,227 @@
+// chanlev.go
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Chanlev flags, QuakeNet style. Each account on a channel holds a set of
+// single-letter flags; privileges nest (owner > master > op > voice), so a
+// check for +o also passes for +m and +n.
+const chanlevFlags = "abdgkmnopqtv"
+
+var chanlevHelp = map[byte]string{
+	'a': "auto-op on join",
+	'b': "banned",
+	'd': "never opped",
+	'g': "auto-voice on join",
+	'k': "known",
+	'm': "master",
+	'n': "owner",
+	'o': "op",
+	'p': "protected from deop/kick",
+	'q': "never voiced",
+	't': "may change the topic",
+	'v': "voice",
+}
+
+// masterGrantable are the flags a master may give or take on others.
+const masterGrantable = "abdgkopqtv"
+
+// normFlags returns the valid flags of s, sorted and without duplicates.
+func normFlags(s string) string {
+	var out []byte
+	for i := 0; i < len(chanlevFlags); i++ {
+		if strings.IndexByte(s, chanlevFlags[i]) >= 0 {
+			out = append(out, chanlevFlags[i])
+		}
+	}
+	return string(out)
+}
+
+// hasPriv reports whether flags grant privilege f, honouring the nesting
+// n > m > o > v.
+func hasPriv(flags string, f byte) bool {
+	if strings.Contains(flags, "b") {
+		return false
+	}
+	implied := map[byte]string{'v': "vomn", 'o': "omn", 'm': "mn", 't': "tomn"}[f]
+	if implied == "" {
+		implied = string(f)
+	}
+	return strings.ContainsAny(flags, implied)
+}
+
+// flagRank orders accounts for listings and ownership succession.
+func flagRank(flags string) int {
+	switch {
+	case strings.Contains(flags, "n"):
+		return 4
+	case strings.Contains(flags, "m"):
+		return 3
+	case strings.Contains(flags, "o"):
+		return 2
+	case strings.Contains(flags, "v"):
+		return 1
+	}
+	return 0
+}
+
+// parseFlagChange splits "+ao-v" into the flags to add and remove.
+func parseFlagChange(change string) (add, remove string, err error) {
+	if change == "" || (change[0] != '+' && change[0] != '-') {
+		return "", "", errors.New("flag changes look like +ov or -v+g")
+	}
+	adding := true
+	for i := 0; i < len(change); i++ {
+		switch c := change[i]; {
+		case c == '+':
+			adding = true
+		case c == '-':
+			adding = false
+		case strings.IndexByte(chanlevFlags, c) < 0:
+			return "", "", errors.New("unknown flag " + string(c))
+		case adding:
+			add += string(c)
+		default:
+			remove += string(c)
+		}
+	}
+	return normFlags(add), normFlags(remove), nil
+}
+
+// applyFlags returns cur with add set and remove cleared.
+func applyFlags(cur, add, remove string) string {
+	var b strings.Builder
+	for i := 0; i < len(cur); i++ {
+		if strings.IndexByte(remove, cur[i]) < 0 {
+			b.WriteByte(cur[i])
+		}
+	}
+	return normFlags(b.String() + add)
+}
+
+// chanlevAllowed applies the grant rules: owners may change anything;
+// masters may change the flags in masterGrantable on users who are not
+// masters or owners themselves; anyone may drop their own flags (but not the
+// restrictions b, d and q) and give themselves auto-op or auto-voice if they
+// already have op or voice. Nobody may put b, d or q on an owner or on
+// themselves, so a channel can't be locked out of its own owner.
+func chanlevAllowed(actor, target string, self bool, add, remove string) error {
+	if strings.ContainsAny(add, "bdq") && (self || strings.ContainsRune(target+add, 'n')) {
+		return errors.New("+b, +d and +q can't be set on owners or on yourself")
+	}
+	if hasPriv(actor, 'n') {
+		return nil
+	}
+	if self {
+		if strings.ContainsAny(remove, "bdq") && !hasPriv(actor, 'm') {
+			return errors.New("only masters and owners may lift +b, +d or +q")
+		}
+		for i := 0; i < len(add); i++ {
+			switch f := add[i]; {
+			case f == 'a' && hasPriv(actor, 'o'), f == 'g' && hasPriv(actor, 'v'):
+			case hasPriv(actor, 'm') && strings.IndexByte(masterGrantable, f) >= 0:
+			default:
+				return errors.New("you may not give yourself +" + string(f))
+			}
+		}
+		return nil
+	}
+	if !hasPriv(actor, 'm') {
+		return errors.New("only masters and owners may change other users' flags")
+	}
+	if strings.ContainsAny(target, "mn") {
+		return errors.New("only owners may change the flags of masters and owners")
+	}
+	for _, f := range add + remove {
+		if strings.IndexRune(masterGrantable, f) < 0 {
+			return errors.New("only owners may change +" + string(f))
+		}
+	}
+	return nil
+}
+
+// flagLegend explains the flags in flags, e.g. "m (master), o (op)".
+func flagLegend(flags string) string {
+	items := make([]string, 0, len(flags))
+	for i := 0; i < len(flags); i++ {
+		items = append(items, string(flags[i])+" ("+chanlevHelp[flags[i]]+")")
+	}
+	return strings.Join(items, ", ")
+}
+
+// levelToFlags maps a legacy 1-500 access level onto chanlev flags: the
+// owner level becomes an owner, 400+ (adduser/join rights) a master and
+// anything else an op, matching what each level could do before.
+func levelToFlags(level int) string {
+	switch {
+	case level >= 500:
+		return "mnotv"
+	case level >= 400:
+		return "motv"
+	case level >= 1:
+		return "ov"
+	}
+	return ""
+}
+
+// doChanlev runs one chanlev command for fromUID and returns the lines to
+// show. args are the words after the channel name.
+func doChanlev(l *Link, fromUID, channel string, args []string) []string {
+	if suspend.IsChanSuspended(channel) {
+		return []string{channel + " is suspended."}
+	}
+	if _, ok := acl.Owner(channel); !ok {
+		return []string{channel + " is not registered."}
+	}
+	acc := accDB.SessionAccount(fromUID)
+	mine := acl.Flags(channel, acc)
+	if (acc == "" || mine == "") && !opers[fromUID] {
+		return []string{"You have no access on " + channel + "."}
+	}
+
+	if len(args) == 0 {
+		entries := acl.List(channel)
+		out := make([]string, 0, len(entries)+1)
+		for _, e := range entries {
+			out = append(out, e.Account+" +"+e.Flags)
+		}
+		return append(out, "End of chanlev for "+channel+".")
+	}
+
+	target := resolveAccountFromToken(args[0])
+	ta, ok := accDB.Get(target)
+	if !ok {
+		return []string{"No such account: " + args[0]}
+	}
+	cur := acl.Flags(channel, ta.Name)
+	if len(args) < 2 {
+		if cur == "" {
+			return []string{ta.Name + " has no flags on " + channel + "."}
+		}
+		return []string{ta.Name + " has +" + cur + " on " + channel + ": " + flagLegend(cur)}
+	}
+
+	add, remove, err := parseFlagChange(args[1])
+	if err != nil {
+		return []string{err.Error()}
+	}
+	self := strings.EqualFold(ta.Name, acc)
+	if err := chanlevAllowed(mine, cur, self, add, remove); err != nil {
+		return []string{"Permission denied: " + err.Error() + "."}
+	}
+	if cur == "" && add == "" {
+		return []string{ta.Name + " has no access on " + channel + "."}
+	}
+	flags, err := acl.SetFlags(channel, ta.Name, applyFlags(cur, add, remove))
+	if err != nil {
+		return []string{"Chanlev failed: " + err.Error() + "."}
+	}
+	_ = acl.Save()
+	if flags == "" {
+		return []string{"Done. " + ta.Name + " no longer has access on " + channel + "."}
+	}
+	return []string{"Done. Flags for " + ta.Name + " on " + channel + " are now +" + flags + "."}
+}
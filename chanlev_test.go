@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFlagChange(t *testing.T) {
+	add, remove, err := parseFlagChange("+vao-gq+o")
+	if err != nil || add != "aov" || remove != "gq" {
+		t.Fatalf("parseFlagChange = %q, %q, %v", add, remove, err)
+	}
+	for _, bad := range []string{"", "ov", "+x"} {
+		if _, _, err := parseFlagChange(bad); err == nil {
+			t.Errorf("parseFlagChange(%q) accepted", bad)
+		}
+	}
+	if got := applyFlags("aotv", "gm", "at"); got != "gmov" {
+		t.Errorf("applyFlags = %q, want gmov", got)
+	}
+}
+
+func TestHasPriv(t *testing.T) {
+	cases := []struct {
+		flags string
+		priv  byte
+		want  bool
+	}{
+		{"n", 'o', true},
+		{"m", 'v', true},
+		{"o", 'm', false},
+		{"v", 'o', false},
+		{"o", 't', true},
+		{"bo", 'o', false},
+		{"k", 'k', true},
+	}
+	for _, c := range cases {
+		if got := hasPriv(c.flags, c.priv); got != c.want {
+			t.Errorf("hasPriv(%q, %c) = %v, want %v", c.flags, c.priv, got, c.want)
+		}
+	}
+}
+
+func TestChanlevAllowed(t *testing.T) {
+	cases := []struct {
+		name          string
+		actor, target string
+		self          bool
+		add, remove   string
+		ok            bool
+	}{
+		{"owner gives master", "mnotv", "ov", false, "m", "", true},
+		{"master gives op", "motv", "", false, "ov", "", true},
+		{"master gives master", "motv", "ov", false, "m", "", false},
+		{"master edits master", "motv", "mo", false, "", "o", false},
+		{"op edits others", "ov", "v", false, "", "v", false},
+		{"op takes auto-op", "ov", "ov", true, "a", "", true},
+		{"voice takes auto-op", "v", "v", true, "a", "", false},
+		{"voice takes auto-voice", "v", "v", true, "g", "", true},
+		{"user drops own op", "ov", "ov", true, "", "o", true},
+		{"user lifts own ban", "bk", "bk", true, "", "b", false},
+		{"owner bans op", "mnotv", "ov", false, "b", "", true},
+		{"owner bans self", "mnotv", "mnotv", true, "b", "", false},
+		{"owner quiets co-owner", "mnotv", "nov", false, "q", "", false},
+		{"owner makes banned owner", "mnotv", "k", false, "bn", "", false},
+		{"master deops self", "motv", "motv", true, "d", "", false},
+	}
+	for _, c := range cases {
+		err := chanlevAllowed(c.actor, c.target, c.self, c.add, c.remove)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err = %v, want ok %v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestChanACLMigratesLevels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chanacl.json")
+	old := `{"#chan": {"owner": "alice", "levels": {"alice": 500, "bob": 450, "carol": 100}}}`
+	if err := os.WriteFile(path, []byte(old), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewChanACLStore(path)
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for acc, want := range map[string]string{"alice": "mnotv", "bob": "motv", "carol": "ov"} {
+		if got := s.Flags("#Chan", acc); got != want {
+			t.Errorf("Flags(%s) = %q, want %q", acc, got, want)
+		}
+	}
+	if _, err := s.SetFlags("#chan", "alice", "mo"); err == nil {
+		t.Error("owner lost +n")
+	}
+	if _, err := s.SetFlags("#chan", "carol", ""); err != nil || s.Flags("#chan", "carol") != "" {
+		t.Errorf("clearing carol: %v", err)
+	}
+	if got := s.List("#chan"); len(got) != 2 || got[0].Account != "alice" {
+		t.Errorf("List = %v", got)
+	}
+}
+
+func TestChanACLDropAccountSuccession(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags map[string]string // account -> flags besides the owner's
+		want  string
+	}{
+		{"master inherits", map[string]string{"bob": "mo", "carol": "o"}, "bob"},
+		{"best ranked master", map[string]string{"bob": "mo", "carol": "mnotv"}, "carol"},
+		{"ties go by name", map[string]string{"dave": "m", "carol": "m"}, "carol"},
+		{"op is not enough", map[string]string{"bob": "otv"}, ""},
+		{"voice and known are not enough", map[string]string{"bob": "v", "carol": "kg"}, ""},
+		{"banned master", map[string]string{"bob": "mb"}, ""},
+		{"nobody left", nil, ""},
+	}
+	for _, tt := range tests {
+		s := NewChanACLStore(filepath.Join(t.TempDir(), "chanacl.json"))
+		s.SetOwner("#chan", "alice")
+		for acc, flags := range tt.flags {
+			if _, err := s.SetFlags("#chan", acc, flags); err != nil {
+				t.Fatalf("%s: SetFlags(%s): %v", tt.name, acc, err)
+			}
+		}
+		got := s.DropAccount("Alice")
+		if succ, ok := got["#chan"]; !ok || succ != tt.want {
+			t.Errorf("%s: successor = %q (%v), want %q", tt.name, succ, ok, tt.want)
+		}
+		if owner, _ := s.Owner("#chan"); owner != tt.want {
+			t.Errorf("%s: owner = %q, want %q", tt.name, owner, tt.want)
+		}
+		if s.Flags("#chan", "alice") != "" {
+			t.Errorf("%s: dropped account kept its flags", tt.name)
+		}
+		if tt.want != "" && !strings.Contains(s.Flags("#chan", tt.want), "n") {
+			t.Errorf("%s: successor flags %q lack +n", tt.name, s.Flags("#chan", tt.want))
+		}
+	}
+}
+
+func TestChanACLAccessFor(t *testing.T) {
+	s := NewChanACLStore(filepath.Join(t.TempDir(), "chanacl.json"))
+	s.SetOwner("#owned", "alice")
+	s.SetOwner("#b", "bob")
+	s.SetOwner("#a", "bob")
+	s.SetOwner("#voiced", "bob")
+	s.SetOwner("#none", "bob")
+	for ch, flags := range map[string]string{"#b": "o", "#a": "mo", "#voiced": "v"} {
+		if _, err := s.SetFlags(ch, "alice", flags); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := s.AccessFor("ALICE")
+	want := []ChanAccess{
+		{Channel: "#owned", Flags: "mnotv", Owner: true},
+		{Channel: "#a", Flags: "mo"},
+		{Channel: "#b", Flags: "o"},
+		{Channel: "#voiced", Flags: "v"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("AccessFor = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("AccessFor[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got := s.AccessFor("nobody"); len(got) != 0 {
+		t.Errorf("AccessFor(nobody) = %+v", got)
+	}
+}
+
+func TestDoChanlevNoAccess(t *testing.T) {
+	withAccountStores(t)
+	for _, n := range []string{"alice", "bob"} {
+		if err := accDB.Create(n, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acl.SetOwner("#chan", "alice")
+	_, _ = acl.SetFlags("#chan", "alice", "mnotv")
+	accDB.Bind("001AAAAAA", "alice")
+
+	l := &Link{Logger: NewLogger("error")}
+	got := doChanlev(l, "001AAAAAA", "#chan", []string{"bob", "-"})
+	if len(got) != 1 || got[0] != "bob has no access on #chan." {
+		t.Errorf("removing flags bob doesn't have: %q", got)
+	}
+}
@@ -0,0 +1,173 @@
+// chanmodes.go
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Channel mode knowledge. InspIRCd announces its channel modes in
+// CAPAB CHANMODES as "<type>:<name>=<letter>" (prefix modes carry a rank and
+// their prefix char: "prefix:30000:op=@o"). We keep the type per letter to
+// parse mode changes, and the letter per name to only set modes the ircd has.
+var (
+	chanModeTypes = map[byte]string{} // letter -> list, param, param-set, prefix, simple
+	chanModeNames = map[string]byte{} // name -> letter
+)
+
+// maxModes is how many mode changes the ircd accepts in one line
+// (MAXMODES in CAPAB CAPABILITIES).
+var maxModes = 20
+
+// learnCapabilities reads the KEY=VALUE list of CAPAB CAPABILITIES.
+func learnCapabilities(tokens string) {
+	for _, tok := range strings.Fields(tokens) {
+		if v, ok := strings.CutPrefix(tok, "MAXMODES="); ok {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				maxModes = n
+			}
+		}
+	}
+}
+
+// Used until the ircd tells us better.
+var defaultChanModeTypes = map[byte]string{
+	'b': "list", 'e': "list", 'I': "list",
+	'k': "param", 'l': "param-set",
+	'q': "prefix", 'a': "prefix", 'o': "prefix", 'h': "prefix", 'v': "prefix",
+}
+
+// learnChanModes reads the token list of CAPAB CHANMODES.
+func learnChanModes(tokens string) {
+	for _, tok := range strings.Fields(tokens) {
+		eq := strings.LastIndexByte(tok, '=')
+		colon := strings.IndexByte(tok, ':')
+		if eq < 0 || colon < 0 || eq == len(tok)-1 {
+			continue
+		}
+		typ := tok[:colon]
+		name := tok[strings.LastIndexByte(tok[:eq], ':')+1 : eq]
+		letter := tok[len(tok)-1]
+		chanModeTypes[letter] = typ
+		chanModeNames[name] = letter
+	}
+}
+
+// chanModeLetter returns the letter of a named mode, or 0 if the ircd
+// didn't announce it.
+func chanModeLetter(name string) byte {
+	return chanModeNames[name]
+}
+
+func modeTakesParam(letter byte, adding bool) bool {
+	typ, ok := chanModeTypes[letter]
+	if !ok {
+		typ = defaultChanModeTypes[letter]
+	}
+	switch typ {
+	case "list", "param", "prefix":
+		return true
+	case "param-set":
+		return adding
+	}
+	return false
+}
+
+// modeChange is one letter of a parsed mode string.
+type modeChange struct {
+	Adding bool
+	Mode   byte
+	Param  string
+}
+
+// parseModes splits "+ov-l nick1 nick2" into single changes.
+func parseModes(modes string, params []string) []modeChange {
+	var out []modeChange
+	adding := true
+	for i := 0; i < len(modes); i++ {
+		switch c := modes[i]; c {
+		case '+':
+			adding = true
+		case '-':
+			adding = false
+		default:
+			mc := modeChange{Adding: adding, Mode: c}
+			if modeTakesParam(c, adding) && len(params) > 0 {
+				mc.Param, params = params[0], params[1:]
+			}
+			out = append(out, mc)
+		}
+	}
+	return out
+}
+
+// fmtModes renders changes as a mode string and its parameters.
+func fmtModes(changes []modeChange) (string, []string) {
+	var b strings.Builder
+	var params []string
+	sign := byte(0)
+	for _, mc := range changes {
+		want := byte('-')
+		if mc.Adding {
+			want = '+'
+		}
+		if want != sign {
+			b.WriteByte(want)
+			sign = want
+		}
+		b.WriteByte(mc.Mode)
+		if mc.Param != "" {
+			params = append(params, mc.Param)
+		}
+	}
+	return b.String(), params
+}
+
+// sendModes sets changes on channel with FMODE, at most maxModes per line.
+func sendModes(l *Link, channel string, ts int64, changes []modeChange) {
+	for len(changes) > 0 {
+		n := min(len(changes), maxModes)
+		modes, params := fmtModes(changes[:n])
+		l.FMode(ts, channel, modes, params...)
+		changes = changes[n:]
+	}
+}
+
+// applyChanModes updates what we track of a channel after a mode change.
+func applyChanModes(channel, modes string, params []string) {
+	cs := chans[toLower(channel)]
+	if cs == nil {
+		return
+	}
+	for _, mc := range parseModes(modes, params) {
+		switch {
+		case mc.Mode == 'o' && mc.Param != "":
+			if mc.Adding {
+				cs.Ops[mc.Param] = true
+			} else {
+				delete(cs.Ops, mc.Param)
+			}
+		case mc.Mode == 'v' && mc.Param != "":
+			if mc.Adding {
+				cs.Voices[mc.Param] = true
+			} else {
+				delete(cs.Voices, mc.Param)
+			}
+		case mc.Mode == 'b' && mc.Param != "":
+			if mc.Adding {
+				cs.Bans[mc.Param] = true
+			} else {
+				delete(cs.Bans, mc.Param)
+			}
+		case mc.Mode == 'l':
+			cs.Limit, _ = strconv.Atoi(mc.Param) // 0 when removed
+		case mc.Mode == 'k':
+			cs.Key = ""
+			if mc.Adding {
+				cs.Key = mc.Param
+			}
+		case mc.Mode == 'i':
+			cs.Invite = mc.Adding
+		}
+	}
+}
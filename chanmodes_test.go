@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLearnChanModes(t *testing.T) {
+	defer func() {
+		chanModeTypes = map[byte]string{}
+		chanModeNames = map[string]byte{}
+	}()
+	learnChanModes("list:ban=b param-set:limit=l param:key=k prefix:30000:op=@o prefix:10000:voice=+v simple:c_registered=r simple:permanent=P")
+	if got := chanModeLetter("permanent"); got != 'P' {
+		t.Errorf("permanent = %q, want P", got)
+	}
+	if got := chanModeLetter("op"); got != 'o' {
+		t.Errorf("op = %q, want o", got)
+	}
+	if got := chanModeLetter("nosuchmode"); got != 0 {
+		t.Errorf("unknown mode = %q, want 0", got)
+	}
+	if got := regChanModes(); got != "rP" {
+		t.Errorf("regChanModes = %q, want rP", got)
+	}
+}
+
+func TestParseModes(t *testing.T) {
+	got := parseModes("+ntlo-vk+b", []string{"10", "042AAAAAB", "042AAAAAC", "secret", "*!*@bad"})
+	want := []modeChange{
+		{true, 'n', ""},
+		{true, 't', ""},
+		{true, 'l', "10"},
+		{true, 'o', "042AAAAAB"},
+		{false, 'v', "042AAAAAC"},
+		{false, 'k', "secret"},
+		{true, 'b', "*!*@bad"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseModes = %+v\nwant %+v", got, want)
+	}
+	if got := parseModes("-l+o", []string{"042AAAAAB"}); got[0].Param != "" || got[1].Param != "042AAAAAB" {
+		t.Errorf("-l must not take a parameter: %+v", got)
+	}
+}
+
+func TestApplyChanModesTracksOps(t *testing.T) {
+	chans["#test"] = newChanState(1)
+	defer delete(chans, "#test")
+	applyChanModes("#Test", "+oo", []string{"042AAAAAB", "042AAAAAC"})
+	applyChanModes("#test", "-o", []string{"042AAAAAB"})
+	if cs := chans["#test"]; cs.Ops["042AAAAAB"] || !cs.Ops["042AAAAAC"] {
+		t.Errorf("ops = %v, want only 042AAAAAC", cs.Ops)
+	}
+}
+
+func TestApplyChanModesTracksKeyAndInvite(t *testing.T) {
+	chans["#test"] = newChanState(1)
+	defer delete(chans, "#test")
+	applyChanModes("#test", "+ikl", []string{"secret", "20"})
+	cs := chans["#test"]
+	if !cs.Invite || cs.Key != "secret" || cs.Limit != 20 {
+		t.Fatalf("after +ikl: invite %v, key %q, limit %d", cs.Invite, cs.Key, cs.Limit)
+	}
+	applyChanModes("#test", "-ik", []string{"*"})
+	if cs.Invite || cs.Key != "" || cs.Limit != 20 {
+		t.Errorf("after -ik: invite %v, key %q, limit %d", cs.Invite, cs.Key, cs.Limit)
+	}
+}
+
+func TestLearnCapabilities(t *testing.T) {
+	defer func() { maxModes = 20 }()
+	learnCapabilities("CASEMAPPING=ascii MAXMODES=12 NICKMAX=30")
+	if maxModes != 12 {
+		t.Errorf("maxModes = %d, want 12", maxModes)
+	}
+	learnCapabilities("MAXMODES=bogus")
+	if maxModes != 12 {
+		t.Errorf("bad MAXMODES changed maxModes to %d", maxModes)
+	}
+}
@@ -0,0 +1,338 @@
+// chansettings.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Channel settings. Booleans carry a chanflags letter and are switched with
+// chanflags <#chan> +bp-w; values (like the welcome text) are set with
+// set <#chan> <key> <value>.
+var chanSettings = NewSettingRegistry(
+	SettingDef{Key: "bitch", Kind: settingBool, Default: "off", Flag: 'b',
+		Help: "only users with op access may hold ops"},
+	SettingDef{Key: "protect", Kind: settingBool, Default: "off", Flag: 'p',
+		Help: "re-op users with op access when deopped"},
+	SettingDef{Key: "autolimit", Kind: settingBool, Default: "off", Flag: 'c',
+		Help: "keep +l a little above the user count"},
+	SettingDef{Key: "limitoffset", Kind: settingString, Default: "10", Validate: validLimitOffset,
+		Help: "free slots autolimit leaves (1-500)"},
+	SettingDef{Key: "limitinterval", Kind: settingString, Default: "30", Validate: validLimitInterval,
+		Help: "seconds between autolimit updates (5-3600)"},
+	SettingDef{Key: "joinflood", Kind: settingBool, Default: "off", Flag: 'j',
+		Help: "set +i for a minute on a join flood"},
+	SettingDef{Key: "joinrate", Kind: settingString, Default: "5:10", Validate: validJoinRate,
+		Help: "joins:seconds that count as a flood"},
+	SettingDef{Key: "welcome", Kind: settingBool, Default: "off", Flag: 'w',
+		Help: "greet joining users with the welcome text"},
+	SettingDef{Key: "welcometext", Kind: settingString, Default: "",
+		Help: "the welcome text"},
+	SettingDef{Key: "forcetopic", Kind: settingBool, Default: "off", Flag: 'f',
+		Help: "only Q may change the topic (see settopic)"},
+	SettingDef{Key: "knownonly", Kind: settingBool, Default: "off", Flag: 'k',
+		Help: "keep the channel +i; users with access get in with invite"},
+)
+
+func validLimitOffset(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n < 1 || n > 500 {
+		return errors.New("limitoffset must be a number from 1 to 500")
+	}
+	return nil
+}
+
+func validLimitInterval(v string) error {
+	if n, err := strconv.Atoi(v); err != nil || n < 5 || n > 3600 {
+		return errors.New("limitinterval must be a number of seconds from 5 to 3600")
+	}
+	return nil
+}
+
+// parseJoinRate reads "joins:seconds".
+func parseJoinRate(v string) (joins int, per time.Duration, err error) {
+	a, b, ok := strings.Cut(v, ":")
+	n, err1 := strconv.Atoi(a)
+	s, err2 := strconv.Atoi(b)
+	if !ok || err1 != nil || err2 != nil || n < 2 || s < 1 || s > 300 {
+		return 0, 0, errors.New("joinrate looks like 5:10 (joins:seconds)")
+	}
+	return n, time.Duration(s) * time.Second, nil
+}
+
+func validJoinRate(v string) error {
+	_, _, err := parseJoinRate(v)
+	return err
+}
+
+// chanSetting resolves a setting for a channel (default if unregistered).
+func chanSetting(channel, key string) string {
+	values, _ := acl.Settings(channel)
+	return chanSettings.Get(values, key)
+}
+
+func chanFlag(channel, key string) bool {
+	return chanSetting(channel, key) == "on"
+}
+
+// chanFlagString renders the boolean settings that are on as "+bpw".
+func chanFlagString(values map[string]string) string {
+	var b []byte
+	for _, d := range chanSettings.Defs() {
+		if d.Flag != 0 && chanSettings.Bool(values, d.Key) {
+			b = append(b, d.Flag)
+		}
+	}
+	slices.Sort(b)
+	return "+" + string(b)
+}
+
+// parseChanFlags turns "+bp-w" into setting key -> "on"/"off".
+func parseChanFlags(change string) (map[string]string, error) {
+	if change == "" || (change[0] != '+' && change[0] != '-') {
+		return nil, errors.New("flag changes look like +bp or -w")
+	}
+	out := make(map[string]string)
+	value := "on"
+	for i := 0; i < len(change); i++ {
+		switch c := change[i]; c {
+		case '+':
+			value = "on"
+		case '-':
+			value = "off"
+		default:
+			d, ok := chanSettings.ByFlag(c)
+			if !ok {
+				return nil, fmt.Errorf("unknown channel flag %c", c)
+			}
+			out[d.Key] = value
+		}
+	}
+	return out, nil
+}
+
+// opRefusal explains why uid may not be given op or voice on channel, or
+// returns "" if it may.
+func opRefusal(channel, cmd, uid string) string {
+	flags := acl.Flags(channel, accDB.SessionAccount(uid))
+	switch cmd {
+	case "op":
+		if strings.Contains(flags, "d") {
+			return getNick(uid) + " may not be opped on " + channel + " (+d)."
+		}
+		if chanFlag(channel, "bitch") && !hasPriv(flags, 'o') {
+			return "Bitch mode is on: only users with op access may be opped on " + channel + "."
+		}
+	case "voice":
+		if strings.Contains(flags, "q") {
+			return getNick(uid) + " may not be voiced on " + channel + " (+q)."
+		}
+	}
+	return ""
+}
+
+// onJoinSettings greets users joining channel with its welcome text. Joins
+// replayed by a netburst are left alone: the members were there before.
+func onJoinSettings(l *Link, channel string, uids []string) {
+	values, ok := acl.Settings(channel)
+	if !ok || inBurst() {
+		return
+	}
+	text := chanSettings.Get(values, "welcometext")
+	if text == "" || !chanSettings.Bool(values, "welcome") {
+		return
+	}
+	for _, uid := range uids {
+		if uid != l.Cfg.ServiceUID {
+			l.NoticeFromService(uid, "["+channel+"] "+text)
+		}
+	}
+}
+
+// inviteOnlyMode is the letter of the invite-only channel mode.
+func inviteOnlyMode() byte {
+	if c := chanModeLetter("inviteonly"); c != 0 {
+		return c
+	}
+	return 'i'
+}
+
+// knownOn reports whether acc has access on channel that knownonly lets in.
+func knownOn(channel, acc string) bool {
+	flags := acl.Flags(channel, acc)
+	return flags != "" && !strings.Contains(flags, "b")
+}
+
+// doInvite has Q invite fromUID to channel if their account is known there,
+// which is how they get into a knownonly channel. It returns the lines to
+// show.
+func doInvite(l *Link, fromUID, channel string) []string {
+	if _, ok := acl.Owner(channel); !ok {
+		return []string{channel + " is not registered."}
+	}
+	acc := accDB.SessionAccount(fromUID)
+	if acc == "" {
+		return []string{"Login required."}
+	}
+	if !knownOn(channel, acc) {
+		return []string{"You have no access on " + channel + "."}
+	}
+	l.Invite(fromUID, channel)
+	return []string{"Invited you to " + channel + "."}
+}
+
+// joinFloods remembers recent joins per channel for joinflood.
+var joinFloods = struct {
+	sync.Mutex
+	joins map[string][]time.Time // lower(#chan) -> join times
+	until map[string]time.Time   // lower(#chan) -> +i set until
+}{joins: map[string][]time.Time{}, until: map[string]time.Time{}}
+
+// noteJoin counts a join on channel and reports whether it makes a flood
+// under the given rate; a channel already locked down doesn't flood again.
+func noteJoin(channel string, joins int, per time.Duration, now time.Time) bool {
+	key := toLower(channel)
+	joinFloods.Lock()
+	defer joinFloods.Unlock()
+	if now.Before(joinFloods.until[key]) {
+		return false
+	}
+	var recent []time.Time
+	for _, t := range joinFloods.joins[key] {
+		if now.Sub(t) < per {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) <= joins {
+		joinFloods.joins[key] = recent
+		return false
+	}
+	delete(joinFloods.joins, key)
+	joinFloods.until[key] = now.Add(time.Minute)
+	return true
+}
+
+// checkJoinFlood sets +i on channel for a minute when joins come too fast.
+func checkJoinFlood(l *Link, channel string) {
+	if !chanFlag(channel, "joinflood") {
+		return
+	}
+	joins, per, err := parseJoinRate(chanSetting(channel, "joinrate"))
+	if err != nil || !noteJoin(channel, joins, per, time.Now()) {
+		return
+	}
+	cs := chans[toLower(channel)]
+	if cs == nil {
+		return
+	}
+	inviteOnly := inviteOnlyMode()
+	l.FMode(cs.TS, channel, "+"+string(inviteOnly))
+	l.ChanMsg(channel, "Join flood detected; the channel is invite-only for a minute.")
+	time.AfterFunc(time.Minute, func() {
+		l.Post(func() {
+			if cs := chans[toLower(channel)]; cs != nil && !lockHas(channelMLock(channel), inviteOnly, true) {
+				l.FMode(cs.TS, channel, "-"+string(inviteOnly))
+			}
+		})
+	})
+}
+
+// doChanFlags shows or changes channel's chanflags for fromUID and returns
+// the lines to show. args are the words after the channel name.
+func doChanFlags(l *Link, fromUID, channel string, args []string) []string {
+	values, ok := acl.Settings(channel)
+	if !ok {
+		return []string{channel + " is not registered."}
+	}
+	if len(args) == 0 {
+		return []string{"Channel flags for " + channel + ": " + chanFlagString(values)}
+	}
+	if !canControlChannel(fromUID, channel, 'm') {
+		return []string{"You need +m on " + channel + " to change its flags."}
+	}
+	change, err := parseChanFlags(args[0])
+	if err != nil {
+		return []string{err.Error()}
+	}
+	for key, value := range change {
+		acl.SetSetting(channel, key, value)
+	}
+	_ = acl.Save()
+	for key := range change {
+		settingChanged(l, channel, key)
+	}
+	values, _ = acl.Settings(channel)
+	return []string{"Done. Channel flags for " + channel + " are now " + chanFlagString(values) + "."}
+}
+
+// settingChanged puts a changed setting into effect on the channel.
+func settingChanged(l *Link, channel, key string) {
+	switch key {
+	case "bitch":
+		if chanFlag(channel, key) {
+			enforceBitch(l, channel)
+		}
+	case "autolimit":
+		if chanFlag(channel, key) {
+			scheduleAutolimit(l, channel)
+		}
+	case "knownonly":
+		cs := chans[toLower(channel)]
+		if cs == nil {
+			return
+		}
+		i := inviteOnlyMode()
+		if chanFlag(channel, key) {
+			l.FMode(cs.TS, channel, "+"+string(i))
+		} else if !lockHas(channelMLock(channel), i, true) {
+			l.FMode(cs.TS, channel, "-"+string(i))
+		}
+	}
+}
+
+// doChanSet shows or changes one of channel's settings for fromUID and
+// returns the lines to show. args are the words after the channel name.
+func doChanSet(l *Link, fromUID, channel string, args []string) []string {
+	values, ok := acl.Settings(channel)
+	if !ok {
+		return []string{channel + " is not registered."}
+	}
+	if len(args) == 0 {
+		var out []string
+		for _, d := range chanSettings.Defs() {
+			flag := " "
+			if d.Flag != 0 {
+				flag = string(d.Flag)
+			}
+			out = append(out, fmt.Sprintf("%-12s %s %-8s %s", d.Key, flag, chanSettings.Get(values, d.Key), d.Help))
+		}
+		return append(out, "Change with: set "+channel+" <key> <value> (or 'default')")
+	}
+	d, ok := chanSettings.Lookup(args[0])
+	if !ok {
+		return []string{"Unknown setting " + args[0] + ". Type 'set " + channel + "' for a list."}
+	}
+	if len(args) < 2 {
+		return []string{d.Key + " is " + chanSettings.Get(values, d.Key) + " on " + channel + "."}
+	}
+	if !canControlChannel(fromUID, channel, 'm') {
+		return []string{"You need +m on " + channel + " to change its settings."}
+	}
+	value := ""
+	if raw := strings.Join(args[1:], " "); !strings.EqualFold(raw, "default") {
+		v, err := chanSettings.Normalize(d.Key, raw)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		value = v
+	}
+	acl.SetSetting(channel, d.Key, value)
+	_ = acl.Save()
+	settingChanged(l, channel, d.Key)
+	return []string{d.Key + " is now " + chanSetting(channel, d.Key) + " on " + channel + "."}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseChanFlags(t *testing.T) {
+	got, err := parseChanFlags("+bp-w+c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"bitch": "on", "protect": "on", "welcome": "off", "autolimit": "on"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseChanFlags = %v, want %v", got, want)
+	}
+	for _, bad := range []string{"", "bp", "+x"} {
+		if _, err := parseChanFlags(bad); err == nil {
+			t.Errorf("parseChanFlags(%q) accepted", bad)
+		}
+	}
+	if got := chanFlagString(map[string]string{"welcome": "on", "bitch": "on", "protect": "off"}); got != "+bw" {
+		t.Errorf("chanFlagString = %q, want +bw", got)
+	}
+}
+
+func TestChanSettingValues(t *testing.T) {
+	for key, cases := range map[string]map[string]bool{
+		"limitoffset": {"10": true, "0": false, "501": false, "x": false},
+		"joinrate":    {"5:10": true, "1:10": false, "5": false, "5:0": false},
+	} {
+		for v, ok := range cases {
+			if _, err := chanSettings.Normalize(key, v); (err == nil) != ok {
+				t.Errorf("%s=%q: err = %v, want ok %v", key, v, err, ok)
+			}
+		}
+	}
+	if d, ok := chanSettings.ByFlag('k'); !ok || d.Key != "knownonly" {
+		t.Errorf("ByFlag(k) = %+v, %v", d, ok)
+	}
+}
+
+func TestNoteJoin(t *testing.T) {
+	now := time.Now()
+	defer func() {
+		delete(joinFloods.joins, "#flood")
+		delete(joinFloods.until, "#flood")
+	}()
+	for i := 0; i < 3; i++ {
+		if noteJoin("#flood", 3, 10*time.Second, now.Add(time.Duration(i)*time.Second)) {
+			t.Fatalf("join %d flagged as a flood", i+1)
+		}
+	}
+	if !noteJoin("#Flood", 3, 10*time.Second, now.Add(3*time.Second)) {
+		t.Fatal("fourth join within 10s not flagged")
+	}
+	if noteJoin("#flood", 3, 10*time.Second, now.Add(4*time.Second)) {
+		t.Error("flood flagged again while locked down")
+	}
+	if noteJoin("#flood", 3, 10*time.Second, now.Add(2*time.Minute)) {
+		t.Error("join after the lockdown flagged")
+	}
+}
+
+func TestKnownOnly(t *testing.T) {
+	oldACL := acl
+	defer func() { acl = oldACL }()
+	acl = NewChanACLStore(filepath.Join(t.TempDir(), "chanacl.json"))
+	acl.SetOwner("#known", "alice")
+	if _, err := acl.SetFlags("#known", "bob", "kb"); err != nil {
+		t.Fatal(err)
+	}
+
+	if lockHas(channelMLock("#known"), 'i', true) {
+		t.Error("+i locked without knownonly")
+	}
+	acl.SetSetting("#known", "knownonly", "on")
+	acl.SetMLock("#known", "+nt")
+	if lock := channelMLock("#known"); !lockHas(lock, 'i', true) || !lockHas(lock, 't', true) {
+		t.Errorf("knownonly lock = %v, want +nt plus +i", lock)
+	}
+	acl.SetMLock("#known", "-i")
+	if lock := channelMLock("#known"); lockHas(lock, 'i', true) {
+		t.Errorf("mlock -i overridden: %v", lock)
+	}
+
+	for acc, want := range map[string]bool{"alice": true, "bob": false, "carol": false} {
+		if got := knownOn("#KNOWN", acc); got != want {
+			t.Errorf("knownOn(%s) = %v, want %v", acc, got, want)
+		}
+	}
+}
@@ -39,6 +39,73 @@ type Config struct {
 	// Logging
 	LogLevel string `json:"log_level"`
 
+	// Accounts
+	ResetTokenMins int  `json:"reset_token_mins"` // lifetime of resetpass tokens
+	EmailRequired  bool `json:"email_required"`   // register must include an email
+
+	// Registrations not confirmed within this many hours are dropped (0 = never)
+	PendingExpireHours int `json:"pending_expire_hours"`
+
+	// Login attempts kept per account for authhistory
+	AuthHistorySize int `json:"auth_history_size"`
+
+	// Network-wide cap on concurrent logins per account (0 = unlimited)
+	MaxSessions int `json:"max_sessions"`
+
+	// Password hashing: "bcrypt" or "argon2id"; legacy hashes are upgraded on login
+	PasswordHash    string `json:"password_hash"`
+	BcryptCost      int    `json:"bcrypt_cost"`
+	Argon2Time      int    `json:"argon2_time"`
+	Argon2MemoryKiB int    `json:"argon2_memory_kib"`
+	Argon2Threads   int    `json:"argon2_threads"`
+	PepperFile      string `json:"pepper_file"` // optional server-side secret
+
+	// Two-factor: TOTP secrets are encrypted with a key derived from this file
+	TOTPKeyFile string `json:"totp_key_file"`
+	TOTPIssuer  string `json:"totp_issuer"` // shown in authenticator apps
+
+	// Login throttling: this many failures on one account lock it for
+	// LockoutMins. Zero disables the lockout; failures still slow down retries
+	LockoutThreshold int `json:"lockout_threshold"`
+	LockoutMins      int `json:"lockout_mins"`
+
+	// Registration limits (0 disables each): accounts per host per window,
+	// a cooldown between registrations, channels per account and the account
+	// age needed before registering a channel
+	RegPerHost         int `json:"reg_per_host"`
+	RegWindowMins      int `json:"reg_window_mins"`
+	RegCooldownSecs    int `json:"reg_cooldown_secs"`
+	MaxChansPerAccount int `json:"max_chans_per_account"`
+	ChanRegMinAgeMins  int `json:"chanreg_min_age_mins"`
+
+	// Channel registration requirements: what the requester must be in the
+	// channel ("op", "present" or "none"), how many users it needs and how
+	// old it must be
+	ChanRegRequire     string `json:"chanreg_require"`
+	ChanRegMinUsers    int    `json:"chanreg_min_users"`
+	ChanRegChanAgeMins int    `json:"chanreg_chan_age_mins"`
+
+	// Nick ownership: unidentified users of an owned nick are renamed to
+	// GuestPrefix+digits after NickGraceSecs; recover holds a nick for NickHoldSecs
+	NickGraceSecs   int    `json:"nick_grace_secs"`
+	NickHoldSecs    int    `json:"nick_hold_secs"`
+	GuestPrefix     string `json:"guest_prefix"`
+	MaxGroupedNicks int    `json:"max_grouped_nicks"` // per account (0 = unlimited)
+
+	// Default kick reasons; {nick} is the requester, {chan} the channel
+	KickReason    string `json:"kick_reason"`
+	KickBanReason string `json:"kickban_reason"`
+
+	// Outbound mail (SMTP or a sendmail-compatible command)
+	SMTPHost        string `json:"smtp_host"`
+	SMTPPort        int    `json:"smtp_port"`
+	SMTPUser        string `json:"smtp_user"`
+	SMTPPass        string `json:"smtp_pass"`
+	SendmailPath    string `json:"sendmail_path"` // takes precedence over SMTP when set
+	MailFrom        string `json:"mail_from"`
+	OutboxPath      string `json:"outbox_path"`
+	MailMaxAttempts int    `json:"mail_max_attempts"`
+
 	// Where to read JSON overrides from (path is parsed here; file is loaded in main.go)
 	ConfigFile string `json:"-"`
 
@@ -87,6 +154,53 @@ func LoadConfigFromFlagsAndEnv() Config {
 	logLevel := flag.String("log", getenv("QSERV_LOG", "info"), "Log level (debug,info,warn,error)")
 	retry := flag.Int("reconnect", getenvInt("QSERV_RECONNECT", 2), "Reconnect seconds")
 
+	// Accounts
+	resetMins := flag.Int("reset-token-mins", getenvInt("QSERV_RESET_TOKEN_MINS", 60), "Password reset token lifetime (minutes)")
+	emailReq := flag.Bool("email-required", getenvBool("QSERV_EMAIL_REQUIRED", false), "Require an email address on register")
+	pendingHours := flag.Int("pending-expire-hours", getenvInt("QSERV_PENDING_EXPIRE_HOURS", 48), "Hours before an unconfirmed registration is dropped (0 = never)")
+	authHist := flag.Int("auth-history", getenvInt("QSERV_AUTH_HISTORY", 20), "Login attempts kept per account")
+	maxSess := flag.Int("max-sessions", getenvInt("QSERV_MAX_SESSIONS", 0), "Max concurrent logins per account (0 = unlimited)")
+	pwHash := flag.String("password-hash", getenv("QSERV_PASSWORD_HASH", "bcrypt"), `Password hash: "bcrypt" or "argon2id"`)
+	bcost := flag.Int("bcrypt-cost", getenvInt("QSERV_BCRYPT_COST", 10), "bcrypt cost")
+	a2t := flag.Int("argon2-time", getenvInt("QSERV_ARGON2_TIME", 3), "argon2id iterations")
+	a2m := flag.Int("argon2-memory", getenvInt("QSERV_ARGON2_MEMORY", 65536), "argon2id memory (KiB)")
+	a2p := flag.Int("argon2-threads", getenvInt("QSERV_ARGON2_THREADS", 2), "argon2id parallelism")
+	pepper := flag.String("pepper-file", getenv("QSERV_PEPPER_FILE", ""), "File holding the password pepper (optional)")
+	totpKeyFile := flag.String("totp-key-file", getenv("QSERV_TOTP_KEY_FILE", ""), "File holding the TOTP encryption key (enables 2FA)")
+	totpIssuer := flag.String("totp-issuer", getenv("QSERV_TOTP_ISSUER", ""), "Issuer name for TOTP (defaults to server name)")
+	lockN := flag.Int("lockout-threshold", getenvInt("QSERV_LOCKOUT_THRESHOLD", 10), "Failed logins before an account is locked (0 = never)")
+	lockMins := flag.Int("lockout-mins", getenvInt("QSERV_LOCKOUT_MINS", 15), "Account lockout duration (minutes)")
+
+	// Registration limits
+	regPerHost := flag.Int("reg-per-host", getenvInt("QSERV_REG_PER_HOST", 3), "Accounts one host may register per window (0 = unlimited)")
+	regWindow := flag.Int("reg-window", getenvInt("QSERV_REG_WINDOW", 1440), "Window for reg-per-host (minutes)")
+	regCooldown := flag.Int("reg-cooldown", getenvInt("QSERV_REG_COOLDOWN", 60), "Seconds between registrations from one host or account")
+	maxChans := flag.Int("max-chans", getenvInt("QSERV_MAX_CHANS", 10), "Channels one account may register (0 = unlimited)")
+	chanMinAge := flag.Int("chanreg-min-age", getenvInt("QSERV_CHANREG_MIN_AGE", 0), "Account age needed to register a channel (minutes)")
+	chanReq := flag.String("chanreg-require", getenv("QSERV_CHANREG_REQUIRE", "op"), `Requester must be "op", "present" or "none" in the channel`)
+	chanUsers := flag.Int("chanreg-min-users", getenvInt("QSERV_CHANREG_MIN_USERS", 0), "Users a channel needs to be registered")
+	chanAge := flag.Int("chanreg-chan-age", getenvInt("QSERV_CHANREG_CHAN_AGE", 0), "Minutes a channel must exist to be registered")
+
+	// Nicks
+	nickGrace := flag.Int("nick-grace", getenvInt("QSERV_NICK_GRACE", 60), "Seconds to log in before an owned nick is changed")
+	nickHold := flag.Int("nick-hold", getenvInt("QSERV_NICK_HOLD", 60), "Seconds a recovered nick stays held for its owner")
+	guestPrefix := flag.String("guest-prefix", getenv("QSERV_GUEST_PREFIX", "Guest"), "Prefix of forced guest nicks")
+	maxNicks := flag.Int("max-grouped-nicks", getenvInt("QSERV_MAX_GROUPED_NICKS", 10), "Nicks an account may group (0 = unlimited)")
+
+	// Kicks
+	kickReason := flag.String("kick-reason", getenv("QSERV_KICK_REASON", "Requested by {nick}"), "Default kick reason ({nick}, {chan})")
+	kickBanReason := flag.String("kickban-reason", getenv("QSERV_KICKBAN_REASON", "Banned by {nick}"), "Default kickban reason ({nick}, {chan})")
+
+	// Mail
+	smtpHost := flag.String("smtp-host", getenv("QSERV_SMTP_HOST", ""), "SMTP server host (empty disables SMTP)")
+	smtpPort := flag.Int("smtp-port", getenvInt("QSERV_SMTP_PORT", 25), "SMTP server port")
+	smtpUser := flag.String("smtp-user", getenv("QSERV_SMTP_USER", ""), "SMTP username (optional)")
+	smtpPass := flag.String("smtp-pass", getenv("QSERV_SMTP_PASS", ""), "SMTP password (optional)")
+	sendmail := flag.String("sendmail", getenv("QSERV_SENDMAIL", ""), "sendmail-compatible command (overrides SMTP)")
+	mailFrom := flag.String("mail-from", getenv("QSERV_MAIL_FROM", ""), "From address for outbound mail")
+	outbox := flag.String("outbox", getenv("QSERV_OUTBOX", "outbox.json"), "Outbound mail queue path")
+	mailTries := flag.Int("mail-max-attempts", getenvInt("QSERV_MAIL_MAX_ATTEMPTS", 5), "Delivery attempts before a mail is dropped")
+
 	flag.Parse()
 
 	// Build the base cfg from flags/env
@@ -113,6 +227,49 @@ func LoadConfigFromFlagsAndEnv() Config {
 	cfg.LogLevel = *logLevel
 	cfg.ReconnectSecs = *retry
 
+	cfg.ResetTokenMins = *resetMins
+	cfg.EmailRequired = *emailReq
+	cfg.PendingExpireHours = *pendingHours
+	cfg.AuthHistorySize = *authHist
+	cfg.MaxSessions = *maxSess
+	cfg.PasswordHash = *pwHash
+	cfg.BcryptCost = *bcost
+	cfg.Argon2Time = *a2t
+	cfg.Argon2MemoryKiB = *a2m
+	cfg.Argon2Threads = *a2p
+	cfg.PepperFile = *pepper
+	cfg.TOTPKeyFile = *totpKeyFile
+	cfg.TOTPIssuer = *totpIssuer
+	cfg.LockoutThreshold = *lockN
+	cfg.LockoutMins = *lockMins
+
+	cfg.RegPerHost = *regPerHost
+	cfg.RegWindowMins = *regWindow
+	cfg.RegCooldownSecs = *regCooldown
+	cfg.MaxChansPerAccount = *maxChans
+	cfg.ChanRegMinAgeMins = *chanMinAge
+
+	cfg.ChanRegRequire = *chanReq
+	cfg.ChanRegMinUsers = *chanUsers
+	cfg.ChanRegChanAgeMins = *chanAge
+
+	cfg.NickGraceSecs = *nickGrace
+	cfg.NickHoldSecs = *nickHold
+	cfg.GuestPrefix = *guestPrefix
+	cfg.MaxGroupedNicks = *maxNicks
+
+	cfg.KickReason = *kickReason
+	cfg.KickBanReason = *kickBanReason
+
+	cfg.SMTPHost = *smtpHost
+	cfg.SMTPPort = *smtpPort
+	cfg.SMTPUser = *smtpUser
+	cfg.SMTPPass = *smtpPass
+	cfg.SendmailPath = *sendmail
+	cfg.MailFrom = *mailFrom
+	cfg.OutboxPath = *outbox
+	cfg.MailMaxAttempts = *mailTries
+
 	normalize(&cfg)
 	return cfg
 }
@@ -184,6 +341,51 @@ func (c Config) Merge(other *Config) Config {
 	mergeStr(&out.LogLevel, other.LogLevel)
 	mergeInt(&out.ReconnectSecs, other.ReconnectSecs)
 
+	mergeInt(&out.ResetTokenMins, other.ResetTokenMins)
+	if other.EmailRequired {
+		out.EmailRequired = true
+	}
+	mergeInt(&out.PendingExpireHours, other.PendingExpireHours)
+	mergeInt(&out.AuthHistorySize, other.AuthHistorySize)
+	mergeInt(&out.MaxSessions, other.MaxSessions)
+	mergeStr(&out.PasswordHash, other.PasswordHash)
+	mergeInt(&out.BcryptCost, other.BcryptCost)
+	mergeInt(&out.Argon2Time, other.Argon2Time)
+	mergeInt(&out.Argon2MemoryKiB, other.Argon2MemoryKiB)
+	mergeInt(&out.Argon2Threads, other.Argon2Threads)
+	mergeStr(&out.PepperFile, other.PepperFile)
+	mergeStr(&out.TOTPKeyFile, other.TOTPKeyFile)
+	mergeStr(&out.TOTPIssuer, other.TOTPIssuer)
+	mergeInt(&out.LockoutThreshold, other.LockoutThreshold)
+	mergeInt(&out.LockoutMins, other.LockoutMins)
+
+	mergeInt(&out.RegPerHost, other.RegPerHost)
+	mergeInt(&out.RegWindowMins, other.RegWindowMins)
+	mergeInt(&out.RegCooldownSecs, other.RegCooldownSecs)
+	mergeInt(&out.MaxChansPerAccount, other.MaxChansPerAccount)
+	mergeInt(&out.ChanRegMinAgeMins, other.ChanRegMinAgeMins)
+
+	mergeStr(&out.ChanRegRequire, other.ChanRegRequire)
+	mergeInt(&out.ChanRegMinUsers, other.ChanRegMinUsers)
+	mergeInt(&out.ChanRegChanAgeMins, other.ChanRegChanAgeMins)
+
+	mergeInt(&out.NickGraceSecs, other.NickGraceSecs)
+	mergeInt(&out.NickHoldSecs, other.NickHoldSecs)
+	mergeStr(&out.GuestPrefix, other.GuestPrefix)
+	mergeInt(&out.MaxGroupedNicks, other.MaxGroupedNicks)
+
+	mergeStr(&out.KickReason, other.KickReason)
+	mergeStr(&out.KickBanReason, other.KickBanReason)
+
+	mergeStr(&out.SMTPHost, other.SMTPHost)
+	mergeInt(&out.SMTPPort, other.SMTPPort)
+	mergeStr(&out.SMTPUser, other.SMTPUser)
+	mergeStr(&out.SMTPPass, other.SMTPPass)
+	mergeStr(&out.SendmailPath, other.SendmailPath)
+	mergeStr(&out.MailFrom, other.MailFrom)
+	mergeStr(&out.OutboxPath, other.OutboxPath)
+	mergeInt(&out.MailMaxAttempts, other.MailMaxAttempts)
+
 	normalize(&out)
 	return out
 }
@@ -194,6 +396,48 @@ func normalize(c *Config) {
 	}
 	c.ReconnectBackoff = time.Duration(c.ReconnectSecs) * time.Second
 
+	if c.ResetTokenMins <= 0 {
+		c.ResetTokenMins = 60
+	}
+	if c.AuthHistorySize <= 0 {
+		c.AuthHistorySize = 20
+	}
+	if c.LockoutThreshold < 0 {
+		c.LockoutThreshold = 0
+	}
+	if c.LockoutMins <= 0 {
+		c.LockoutMins = 15
+	}
+	switch c.ChanRegRequire {
+	case "op", "present", "none":
+	default:
+		c.ChanRegRequire = "op"
+	}
+	if c.NickGraceSecs <= 0 {
+		c.NickGraceSecs = 60
+	}
+	if c.NickHoldSecs <= 0 {
+		c.NickHoldSecs = 60
+	}
+	if c.GuestPrefix == "" {
+		c.GuestPrefix = "Guest"
+	}
+	if c.KickReason == "" {
+		c.KickReason = "Requested by {nick}"
+	}
+	if c.KickBanReason == "" {
+		c.KickBanReason = "Banned by {nick}"
+	}
+	if c.SMTPPort <= 0 {
+		c.SMTPPort = 25
+	}
+	if c.OutboxPath == "" {
+		c.OutboxPath = "outbox.json"
+	}
+	if c.MailMaxAttempts <= 0 {
+		c.MailMaxAttempts = 5
+	}
+
 	if c.Protocol == "" {
 		c.Protocol = "insp4"
 	}
@@ -227,6 +471,12 @@ func normalize(c *Config) {
 	if c.QReal == "" {
 		c.QReal = "EmechNET IRC Services"
 	}
+	if c.TOTPIssuer == "" {
+		c.TOTPIssuer = c.ServerName
+	}
+	if c.MailFrom == "" {
+		c.MailFrom = c.QUser + "@" + c.QHost
+	}
 }
 
 func getenv(k, d string) string {
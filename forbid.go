@@ -0,0 +1,341 @@
+// forbid.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Forbids are oper-managed names that cannot be registered or used. A
+// pattern is a case-insensitive glob (* and ?) or, written as /expr/, a
+// regular expression. Glob forbids on nicks and channels are also pushed to
+// the ircd (Q-line and CBAN) so the names can't be used at all.
+
+const (
+	forbidAccount = "account"
+	forbidNick    = "nick"
+	forbidChan    = "chan"
+)
+
+type Forbid struct {
+	Kind    string `json:"kind"` // account, nick or chan
+	Pattern string `json:"pattern"`
+	Reason  string `json:"reason"`
+	SetBy   string `json:"set_by"`
+	SetTS   int64  `json:"set_ts"`
+	Until   int64  `json:"until,omitempty"` // unix seconds; 0 = permanent
+
+	re *regexp.Regexp // compiled /expr/ patterns
+}
+
+// IsRegex reports whether the pattern is a /regular expression/.
+func (f *Forbid) IsRegex() bool {
+	return len(f.Pattern) > 2 && strings.HasPrefix(f.Pattern, "/") && strings.HasSuffix(f.Pattern, "/")
+}
+
+func (f *Forbid) compile() error {
+	if !f.IsRegex() {
+		return nil
+	}
+	re, err := regexp.Compile("(?i)^(?:" + f.Pattern[1:len(f.Pattern)-1] + ")$")
+	if err != nil {
+		return err
+	}
+	f.re = re
+	return nil
+}
+
+func (f *Forbid) active(now int64) bool {
+	return f.Until == 0 || f.Until > now
+}
+
+func (f *Forbid) matches(name string) bool {
+	if f.re != nil {
+		return f.re.MatchString(name)
+	}
+	return globMatch(toLower(f.Pattern), toLower(name))
+}
+
+// lineType is the ircd X-line that enforces the forbid, if any.
+func (f *Forbid) lineType() string {
+	if f.IsRegex() {
+		return ""
+	}
+	switch f.Kind {
+	case forbidNick:
+		return "Q"
+	case forbidChan:
+		return "CBAN"
+	}
+	return ""
+}
+
+type ForbidStore struct {
+	mu      sync.RWMutex
+	path    string
+	Forbids []*Forbid `json:"forbids"`
+}
+
+func NewForbidStore(path string) *ForbidStore {
+	return &ForbidStore{path: path}
+}
+
+func (s *ForbidStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var st ForbidStore
+	if err := json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+	for _, f := range st.Forbids {
+		if err := f.compile(); err != nil {
+			return errors.New("forbid " + f.Pattern + ": " + err.Error())
+		}
+	}
+	s.Forbids = st.Forbids
+	s.prune(time.Now().Unix())
+	return nil
+}
+
+// Save writes the active forbids; expired ones are dropped for good.
+func (s *ForbidStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now().Unix())
+	return writeJSONAtomic(s.path, s)
+}
+
+// prune drops forbids that expired by now. The caller holds the lock.
+func (s *ForbidStore) prune(now int64) {
+	kept := s.Forbids[:0]
+	for _, f := range s.Forbids {
+		if f.active(now) {
+			kept = append(kept, f)
+		}
+	}
+	clear(s.Forbids[len(kept):])
+	s.Forbids = kept
+}
+
+// Add stores a forbid, replacing one with the same kind and pattern.
+func (s *ForbidStore) Add(f Forbid) (*Forbid, error) {
+	if f.Kind != forbidAccount && f.Kind != forbidNick && f.Kind != forbidChan {
+		return nil, errors.New("kind must be account, nick or chan")
+	}
+	if err := f.compile(); err != nil {
+		return nil, errors.New("bad regular expression: " + err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, old := range s.Forbids {
+		if old.Kind == f.Kind && strings.EqualFold(old.Pattern, f.Pattern) {
+			s.Forbids[i] = &f
+			return &f, nil
+		}
+	}
+	s.Forbids = append(s.Forbids, &f)
+	return &f, nil
+}
+
+// Del removes the forbid with the given kind and pattern.
+func (s *ForbidStore) Del(kind, pattern string) (*Forbid, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.Forbids {
+		if f.Kind == kind && strings.EqualFold(f.Pattern, pattern) {
+			s.Forbids = append(s.Forbids[:i:i], s.Forbids[i+1:]...)
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Match returns the active forbid of the given kind that matches name.
+func (s *ForbidStore) Match(kind, name string) (Forbid, bool) {
+	now := time.Now().Unix()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.Forbids {
+		if f.Kind == kind && f.active(now) && f.matches(name) {
+			return *f, true
+		}
+	}
+	return Forbid{}, false
+}
+
+// List returns active forbids (of kind, if set), sorted by kind and pattern.
+func (s *ForbidStore) List(kind string) []Forbid {
+	now := time.Now().Unix()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Forbid
+	for _, f := range s.Forbids {
+		if (kind == "" || f.Kind == kind) && f.active(now) {
+			out = append(out, *f)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind == out[j].Kind {
+			return toLower(out[i].Pattern) < toLower(out[j].Pattern)
+		}
+		return out[i].Kind < out[j].Kind
+	})
+	return out
+}
+
+// globMatch matches name against a pattern with * and ? wildcards.
+func globMatch(pattern, name string) bool {
+	p, n := 0, 0
+	star, mark := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, n
+			p++
+		case star >= 0:
+			mark++
+			p, n = star+1, mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// parseExpiry reads "+30m", "+12h", "+7d" or "+2w".
+func parseExpiry(s string) (time.Duration, error) {
+	if len(s) < 3 || s[0] != '+' {
+		return 0, errors.New("expiry looks like +30m, +12h, +7d or +2w")
+	}
+	n, err := strconv.Atoi(s[1 : len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, errors.New("expiry looks like +30m, +12h, +7d or +2w")
+	}
+	unit := map[byte]time.Duration{'m': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}[s[len(s)-1]]
+	if unit == 0 {
+		return 0, errors.New("expiry looks like +30m, +12h, +7d or +2w")
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// pushForbid adds the forbid's X-line on the ircd. The line carries the
+// forbid's set time, so its duration is counted from then too.
+func pushForbid(l *Link, f Forbid) {
+	typ := f.lineType()
+	if typ == "" || !f.active(time.Now().Unix()) {
+		return
+	}
+	var dur int64
+	if f.Until > 0 {
+		dur = f.Until - f.SetTS
+	}
+	_ = l.SendRaw(":%s ADDLINE %s %s %s %d %d :%s", l.Cfg.SID, typ, f.Pattern, l.Cfg.QNick, f.SetTS, dur, "Forbidden: "+f.Reason)
+}
+
+// unpushForbid removes the forbid's X-line from the ircd.
+func unpushForbid(l *Link, f Forbid) {
+	if typ := f.lineType(); typ != "" {
+		_ = l.SendRaw(":%s DELLINE %s %s", l.Cfg.SID, typ, f.Pattern)
+	}
+}
+
+// handleForbidCommand serves forbid add|del|list (IRCop only).
+func handleForbidCommand(l *Link, fromUID string, parts []string) {
+	if !opers[fromUID] {
+		l.NoticeFromService(fromUID, "IRCop only.")
+		return
+	}
+	usage := "Usage: forbid add <account|nick|chan> <pattern|/regex/> [+expiry] <reason> | forbid del <account|nick|chan> <pattern> | forbid list [kind]"
+	switch strings.ToLower(getOr(parts, 1, "")) {
+	case "add":
+		if len(parts) < 5 {
+			l.NoticeFromService(fromUID, usage)
+			return
+		}
+		f := Forbid{
+			Kind:    strings.ToLower(parts[2]),
+			Pattern: parts[3],
+			SetBy:   userMask(fromUID),
+			SetTS:   time.Now().Unix(),
+		}
+		rest := parts[4:]
+		if strings.HasPrefix(rest[0], "+") {
+			d, err := parseExpiry(rest[0])
+			if err != nil {
+				l.NoticeFromService(fromUID, err.Error())
+				return
+			}
+			f.Until = time.Now().Add(d).Unix()
+			rest = rest[1:]
+		}
+		f.Reason = strings.Join(rest, " ")
+		if f.Reason == "" {
+			l.NoticeFromService(fromUID, usage)
+			return
+		}
+		added, err := forbids.Add(f)
+		if err != nil {
+			l.NoticeFromService(fromUID, "Forbid failed: "+err.Error())
+			return
+		}
+		_ = forbids.Save()
+		pushForbid(l, *added)
+		l.Logger.Infof("forbid %s %s added by %s: %s", added.Kind, added.Pattern, userMask(fromUID), added.Reason)
+		l.NoticeFromService(fromUID, "Forbidden "+added.Kind+" "+added.Pattern+".")
+		if added.Kind == forbidNick {
+			for uid, nick := range uidToNick {
+				if added.matches(nick) {
+					checkNick(l, uid)
+				}
+			}
+		}
+
+	case "del":
+		if len(parts) < 4 {
+			l.NoticeFromService(fromUID, usage)
+			return
+		}
+		f, ok := forbids.Del(strings.ToLower(parts[2]), parts[3])
+		if !ok {
+			l.NoticeFromService(fromUID, "No such forbid.")
+			return
+		}
+		_ = forbids.Save()
+		unpushForbid(l, *f)
+		l.NoticeFromService(fromUID, "Removed forbid on "+f.Kind+" "+f.Pattern+".")
+
+	case "list":
+		list := forbids.List(strings.ToLower(getOr(parts, 2, "")))
+		for _, f := range list {
+			expiry := "permanent"
+			if f.Until > 0 {
+				expiry = "until " + fmtTime(f.Until)
+			}
+			l.NoticeFromService(fromUID, f.Kind+" "+f.Pattern+" ("+expiry+", by "+f.SetBy+"): "+f.Reason)
+		}
+		l.NoticeFromService(fromUID, "End of forbids ("+strconv.Itoa(len(list))+").")
+
+	default:
+		l.NoticeFromService(fromUID, usage)
+	}
+}
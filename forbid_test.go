@@ -0,0 +1,99 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"admin", "admin", true},
+		{"admin*", "administrator", true},
+		{"*serv", "nickserv", true},
+		{"*serv", "servers", false},
+		{"q", "q", true},
+		{"?", "qq", false},
+		{"#opers*", "#opers-chat", true},
+		{"*a*b*", "xxaxxbxx", true},
+		{"*a*b", "xxaxxbxx", false},
+		{"[x]*", "[x]bot", true},
+	}
+	for _, c := range cases {
+		if got := globMatch(c.pattern, c.name); got != c.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestForbidStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "forbids.json")
+	s := NewForbidStore(path)
+	for _, f := range []Forbid{
+		{Kind: forbidAccount, Pattern: "Admin*", Reason: "staff names"},
+		{Kind: forbidNick, Pattern: "/.*serv/", Reason: "service names"},
+		{Kind: forbidChan, Pattern: "#opers", Reason: "reserved", Until: time.Now().Add(-time.Minute).Unix()},
+	} {
+		if _, err := s.Add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Add(Forbid{Kind: "server", Pattern: "x"}); err == nil {
+		t.Error("unknown kind accepted")
+	}
+	if _, err := s.Add(Forbid{Kind: forbidNick, Pattern: "/(/"}); err == nil {
+		t.Error("bad regex accepted")
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewForbidStore(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		kind, name string
+		want       bool
+	}{
+		{forbidAccount, "administrator", true},
+		{forbidAccount, "sherby", false},
+		{forbidNick, "NickServ", true},
+		{forbidNick, "servant", false},
+		{forbidAccount, "nickserv", false},
+		{forbidChan, "#opers", false}, // expired
+	}
+	for _, c := range cases {
+		if _, got := loaded.Match(c.kind, c.name); got != c.want {
+			t.Errorf("Match(%s, %q) = %v, want %v", c.kind, c.name, got, c.want)
+		}
+	}
+	if got := len(loaded.List("")); got != 2 {
+		t.Errorf("List returned %d active forbids, want 2", got)
+	}
+	if got := len(loaded.Forbids); got != 2 {
+		t.Errorf("%d forbids stored, want the expired one pruned", got)
+	}
+	if _, ok := loaded.Del(forbidAccount, "admin*"); !ok {
+		t.Error("Del did not find the forbid")
+	}
+	if _, ok := loaded.Match(forbidAccount, "admin"); ok {
+		t.Error("deleted forbid still matches")
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	for in, want := range map[string]time.Duration{"+30m": 30 * time.Minute, "+12h": 12 * time.Hour, "+7d": 7 * 24 * time.Hour, "+2w": 14 * 24 * time.Hour} {
+		if got, err := parseExpiry(in); err != nil || got != want {
+			t.Errorf("parseExpiry(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"30m", "+m", "+0d", "+3y", "+-1h"} {
+		if _, err := parseExpiry(in); err == nil {
+			t.Errorf("parseExpiry(%q) accepted", in)
+		}
+	}
+}
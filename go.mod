@@ -5,3 +5,5 @@ go 1.23.0
 toolchain go1.23.12
 
 require golang.org/x/crypto v0.41.0
+
+require golang.org/x/sys v0.35.0 // indirect
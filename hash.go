@@ -0,0 +1,199 @@
+// hash.go
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Stored password hashes carry their algorithm as a prefix:
+//
+//	$2a$<cost>$...                          bcrypt (the legacy format)
+//	$argon2id$v=19$m=<KiB>,t=<n>,p=<n>$<salt>$<key>
+//
+// Either may be wrapped as "{pepper}<hash>", meaning the password was run
+// through HMAC-SHA-256 with the server-side pepper before hashing.
+const pepperPrefix = "{pepper}"
+
+type HashPolicy struct {
+	Algo         string // "bcrypt" or "argon2id"
+	BcryptCost   int
+	ArgonTime    uint32
+	ArgonMemory  uint32 // KiB
+	ArgonThreads uint8
+	Pepper       []byte
+}
+
+func defaultHashPolicy() HashPolicy {
+	return HashPolicy{
+		Algo:         "bcrypt",
+		BcryptCost:   bcrypt.DefaultCost,
+		ArgonTime:    3,
+		ArgonMemory:  64 * 1024,
+		ArgonThreads: 2,
+	}
+}
+
+// HashPolicyFromConfig builds the policy and reads the pepper file, if any.
+func HashPolicyFromConfig(c Config) (HashPolicy, error) {
+	p := defaultHashPolicy()
+	switch strings.ToLower(c.PasswordHash) {
+	case "", "bcrypt":
+		p.Algo = "bcrypt"
+	case "argon2id":
+		p.Algo = "argon2id"
+	default:
+		return p, fmt.Errorf("unknown password_hash %q (want bcrypt or argon2id)", c.PasswordHash)
+	}
+	if c.BcryptCost != 0 {
+		if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+			return p, fmt.Errorf("bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		p.BcryptCost = c.BcryptCost
+	}
+	if c.Argon2Time > 0 {
+		p.ArgonTime = uint32(c.Argon2Time)
+	}
+	if c.Argon2MemoryKiB > 0 {
+		p.ArgonMemory = uint32(c.Argon2MemoryKiB)
+	}
+	if c.Argon2Threads > 0 && c.Argon2Threads <= 255 {
+		p.ArgonThreads = uint8(c.Argon2Threads)
+	}
+	if c.PepperFile != "" {
+		b, err := os.ReadFile(c.PepperFile)
+		if err != nil {
+			return p, err
+		}
+		p.Pepper = bytes.TrimSpace(b)
+		if len(p.Pepper) == 0 {
+			return p, errors.New("pepper file is empty")
+		}
+	}
+	return p, nil
+}
+
+// prepare applies the pepper, if configured.
+func (p HashPolicy) prepare(password string) []byte {
+	if len(p.Pepper) == 0 {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, p.Pepper)
+	mac.Write([]byte(password))
+	return []byte(base64.RawStdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// Hash hashes password with the current policy.
+func (p HashPolicy) Hash(password string) ([]byte, error) {
+	pw := p.prepare(password)
+	var out []byte
+	switch p.Algo {
+	case "argon2id":
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		key := argon2.IDKey(pw, salt, p.ArgonTime, p.ArgonMemory, p.ArgonThreads, 32)
+		out = []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, p.ArgonMemory, p.ArgonTime, p.ArgonThreads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)))
+	default:
+		h, err := bcrypt.GenerateFromPassword(pw, p.BcryptCost)
+		if err != nil {
+			return nil, err
+		}
+		out = h
+	}
+	if len(p.Pepper) > 0 {
+		out = append([]byte(pepperPrefix), out...)
+	}
+	return out, nil
+}
+
+// Verify checks password against a stored hash of any supported format.
+func (p HashPolicy) Verify(hash []byte, password string) bool {
+	s := string(hash)
+	pw := []byte(password)
+	if strings.HasPrefix(s, pepperPrefix) {
+		if len(p.Pepper) == 0 {
+			return false // peppered hash but no pepper loaded
+		}
+		s = strings.TrimPrefix(s, pepperPrefix)
+		pw = p.prepare(password)
+	}
+	if strings.HasPrefix(s, "$argon2id$") {
+		a, ok := parseArgon2(s)
+		if !ok {
+			return false
+		}
+		key := argon2.IDKey(pw, a.salt, a.time, a.memory, a.threads, uint32(len(a.key)))
+		return subtle.ConstantTimeCompare(key, a.key) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(s), pw) == nil
+}
+
+// NeedsRehash reports whether hash was made with a different algorithm,
+// different parameters or a different pepper setting than the policy.
+func (p HashPolicy) NeedsRehash(hash []byte) bool {
+	s := string(hash)
+	peppered := strings.HasPrefix(s, pepperPrefix)
+	if peppered != (len(p.Pepper) > 0) {
+		return true
+	}
+	s = strings.TrimPrefix(s, pepperPrefix)
+	switch p.Algo {
+	case "argon2id":
+		a, ok := parseArgon2(s)
+		return !ok || a.time != p.ArgonTime || a.memory != p.ArgonMemory || a.threads != p.ArgonThreads
+	default:
+		if strings.HasPrefix(s, "$argon2id$") {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(s))
+		return err != nil || cost != p.BcryptCost
+	}
+}
+
+type argon2Hash struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2(s string) (argon2Hash, bool) {
+	var a argon2Hash
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	f := strings.Split(s, "$")
+	if len(f) != 6 || f[1] != "argon2id" {
+		return a, false
+	}
+	var v int
+	if _, err := fmt.Sscanf(f[2], "v=%d", &v); err != nil || v != argon2.Version {
+		return a, false
+	}
+	if _, err := fmt.Sscanf(f[3], "m=%d,t=%d,p=%d", &a.memory, &a.time, &a.threads); err != nil {
+		return a, false
+	}
+	var err error
+	if a.salt, err = base64.RawStdEncoding.DecodeString(f[4]); err != nil {
+		return a, false
+	}
+	if a.key, err = base64.RawStdEncoding.DecodeString(f[5]); err != nil || len(a.key) == 0 {
+		return a, false
+	}
+	return a, true
+}
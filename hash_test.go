@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPolicies keep the work factors low so the tests stay fast.
+func testPolicies() map[string]HashPolicy {
+	bc := defaultHashPolicy()
+	bc.BcryptCost = bcrypt.MinCost
+
+	ar := bc
+	ar.Algo = "argon2id"
+	ar.ArgonTime, ar.ArgonMemory, ar.ArgonThreads = 1, 1024, 1
+
+	bcPepper := bc
+	bcPepper.Pepper = []byte("pepper")
+
+	arPepper := ar
+	arPepper.Pepper = []byte("pepper")
+
+	return map[string]HashPolicy{
+		"bcrypt":          bc,
+		"argon2id":        ar,
+		"bcrypt+pepper":   bcPepper,
+		"argon2id+pepper": arPepper,
+	}
+}
+
+func TestHashVerify(t *testing.T) {
+	for name, p := range testPolicies() {
+		t.Run(name, func(t *testing.T) {
+			h, err := p.Hash("hunter2")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if peppered := strings.HasPrefix(string(h), pepperPrefix); peppered != (len(p.Pepper) > 0) {
+				t.Errorf("hash %q: pepper prefix = %v", h, peppered)
+			}
+			if !p.Verify(h, "hunter2") {
+				t.Error("correct password rejected")
+			}
+			if p.Verify(h, "hunter3") {
+				t.Error("wrong password accepted")
+			}
+			if p.NeedsRehash(h) {
+				t.Error("fresh hash needs rehash")
+			}
+		})
+	}
+}
+
+func TestHashPepperRequired(t *testing.T) {
+	p := testPolicies()["bcrypt+pepper"]
+	h, err := p.Hash("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	noPepper := p
+	noPepper.Pepper = nil
+	if noPepper.Verify(h, "hunter2") {
+		t.Error("peppered hash verified without the pepper")
+	}
+	other := p
+	other.Pepper = []byte("other")
+	if other.Verify(h, "hunter2") {
+		t.Error("peppered hash verified with another pepper")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	ps := testPolicies()
+	hashes := map[string][]byte{}
+	for name, p := range ps {
+		h, err := p.Hash("hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes[name] = h
+	}
+	costlier := ps["bcrypt"]
+	costlier.BcryptCost++
+	slower := ps["argon2id"]
+	slower.ArgonTime++
+
+	tests := []struct {
+		name   string
+		policy HashPolicy
+		hash   string
+		rehash bool
+		verify bool
+	}{
+		{"same bcrypt", ps["bcrypt"], "bcrypt", false, true},
+		{"same argon2id", ps["argon2id"], "argon2id", false, true},
+		{"bcrypt to argon2id", ps["argon2id"], "bcrypt", true, true},
+		{"argon2id to bcrypt", ps["bcrypt"], "argon2id", true, true},
+		{"bcrypt cost changed", costlier, "bcrypt", true, true},
+		{"argon2id time changed", slower, "argon2id", true, true},
+		{"pepper added", ps["bcrypt+pepper"], "bcrypt", true, true},
+		{"pepper removed", ps["bcrypt"], "bcrypt+pepper", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := hashes[tt.hash]
+			if got := tt.policy.NeedsRehash(h); got != tt.rehash {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.rehash)
+			}
+			if got := tt.policy.Verify(h, "hunter2"); got != tt.verify {
+				t.Errorf("Verify = %v, want %v", got, tt.verify)
+			}
+		})
+	}
+}
+
+func TestParseArgon2(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$a2V5", true},
+		{"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5", false},
+		{"$argon2id$v=19$m=1024,t=1$c2FsdA$a2V5", false},
+		{"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$", false},
+		{"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5", false},
+		{"$2a$10$abc", false},
+	}
+	for _, tt := range tests {
+		if _, ok := parseArgon2(tt.in); ok != tt.ok {
+			t.Errorf("parseArgon2(%q) ok = %v, want %v", tt.in, ok, tt.ok)
+		}
+	}
+}
@@ -45,19 +45,17 @@ func (l *Link) ServiceJoinWithTS(channel string, ts int64, giveOp bool) {
 	key := toLower(channel)
 	cs := chans[key]
 	if cs == nil {
-		cs = &chanState{TS: tsSec, Seen: map[string]bool{}}
+		cs = newChanState(tsSec)
 		chans[key] = cs
 	} else if cs.TS == 0 {
 		cs.TS = tsSec
 	}
-	if cs.Seen == nil {
-		cs.Seen = map[string]bool{}
-	}
 	cs.Seen[l.Cfg.ServiceUID] = true
 
 	if giveOp {
 		// FMODE MUST use the channel TS (seconds) and come from the SERVER SID
 		_ = l.SendRaw(":%s FMODE %s %d +o %s", l.Cfg.SID, channel, cs.TS, l.Cfg.ServiceUID)
+		cs.Ops[l.Cfg.ServiceUID] = true
 	}
 }
 
@@ -67,13 +65,28 @@ func (l *Link) FMode(chanTS int64, channel, modes string, args ...string) {
 		line += " " + a
 	}
 	_ = l.SendRaw(line)
+	applyChanModes(channel, modes, args)
 }
 
+// NoticeFromService replies to a user, as NOTICE or PRIVMSG per their
+// msgtype setting.
 func (l *Link) NoticeFromService(targetUID, text string) {
 	if targetUID == "" || text == "" {
 		return
 	}
-	_ = l.SendRaw(":%s NOTICE %s :%s", l.Cfg.ServiceUID, targetUID, text)
+	verb := "NOTICE"
+	if uidSetting(targetUID, "msgtype") == "privmsg" {
+		verb = "PRIVMSG"
+	}
+	_ = l.SendRaw(":%s %s %s :%s", l.Cfg.ServiceUID, verb, targetUID, text)
+}
+
+// CTCPReply always answers with a NOTICE, as CTCP requires.
+func (l *Link) CTCPReply(targetUID, text string) {
+	if targetUID == "" || text == "" {
+		return
+	}
+	_ = l.SendRaw(":%s NOTICE %s :\x01%s\x01", l.Cfg.ServiceUID, targetUID, text)
 }
 
 func (l *Link) ChanMsg(channel, text string) {
@@ -112,6 +125,53 @@ func (l *Link) SetVHost(uid, vhost string) {
 	_ = l.SendRaw(":%s CHGHOST %s %s", l.Cfg.SID, uid, vhost)
 }
 
+// SVSNick forces a user onto a new nick.
+func (l *Link) SVSNick(uid, nick string) {
+	if uid == "" || nick == "" {
+		return
+	}
+	_ = l.SendRaw(":%s SVSNICK %s %s %d", l.Cfg.SID, uid, nick, time.Now().Unix())
+}
+
+// Kill disconnects a user with Q as the source.
+func (l *Link) Kill(uid, reason string) {
+	if uid == "" {
+		return
+	}
+	_ = l.SendRaw(":%s KILL %s :%s", l.Cfg.ServiceUID, uid, reason)
+}
+
+// Topic sets a channel's topic with Q as the setter.
+func (l *Link) Topic(channel, text string) {
+	cs := chans[toLower(channel)]
+	if cs == nil {
+		return
+	}
+	_ = l.SendRaw(":%s FTOPIC %s %d %d %s :%s", l.Cfg.SID, channel, cs.TS, time.Now().Unix(), l.Cfg.QNick, text)
+	cs.Topic = text
+}
+
+// Invite has Q invite a user to a channel.
+func (l *Link) Invite(uid, channel string) {
+	var ts int64
+	if cs := chans[toLower(channel)]; cs != nil {
+		ts = cs.TS
+	}
+	// :<source> INVITE <target> <chan> <chants> <expiry>
+	_ = l.SendRaw(":%s INVITE %s %s %d 0", l.Cfg.ServiceUID, uid, channel, ts)
+}
+
+// Kick removes a user from a channel with Q as the source.
+func (l *Link) Kick(channel, uid, reason string) {
+	if uid == "" {
+		return
+	}
+	_ = l.SendRaw(":%s KICK %s %s :%s", l.Cfg.ServiceUID, channel, uid, reason)
+	if cs := chans[toLower(channel)]; cs != nil {
+		cs.leave(uid)
+	}
+}
+
 // ----- optional: simple nick <-> uid map (JOIN/NICK/QUIT tracking) -----
 
 var (
@@ -130,8 +190,10 @@ func uidFromNick(nick string) string {
 func (l *Link) QChanMode(channel, modes string, targetUID string) {
 	if targetUID != "" {
 		_ = l.SendRaw(":%s MODE %s %s %s", l.Cfg.ServiceUID, channel, modes, targetUID)
+		applyChanModes(channel, modes, []string{targetUID})
 	} else {
 		_ = l.SendRaw(":%s MODE %s %s", l.Cfg.ServiceUID, channel, modes)
+		applyChanModes(channel, modes, nil)
 	}
 }
 func requireLoginForChannel(l *Link, fromUID, channel string) (string, bool) {
@@ -0,0 +1,133 @@
+// kick.go
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// kick and kickban. A target is a nick on the channel or a mask
+// (nick!user@host globs or account:<name>) that hits every matching member.
+// Nobody may kick users with equal or higher access, and +p users only
+// yield to the owner. A kickban's mask may not match the requester, and
+// follows the rules of ban (see doBan) otherwise.
+
+// expandReason fills {nick} (the requester) and {chan} in a reason template.
+func expandReason(tmpl, nick, channel string) string {
+	return strings.NewReplacer("{nick}", nick, "{chan}", channel).Replace(tmpl)
+}
+
+// kickMask turns a wildcard target into a full mask, or returns "" for a
+// plain nick.
+func kickMask(tok string) string {
+	switch {
+	case strings.HasPrefix(toLower(tok), "account:"), strings.Contains(tok, "!"):
+		return tok
+	case strings.Contains(tok, "@"):
+		return "*!" + tok
+	case strings.ContainsAny(tok, "*?"):
+		return tok + "!*@*"
+	}
+	return ""
+}
+
+// kickAllowed checks an actor's chanlev flags against a target's.
+func kickAllowed(actor, target string) error {
+	if strings.Contains(target, "p") && !hasPriv(actor, 'n') {
+		return errors.New("is protected")
+	}
+	if r := flagRank(target); r > 0 && r >= flagRank(actor) {
+		return errors.New("has equal or higher access")
+	}
+	return nil
+}
+
+// kickTargets returns the members of channel that tok names.
+func kickTargets(channel, tok string) []string {
+	mask := kickMask(tok)
+	if mask == "" {
+		if uid := resolveUIDInChannel(channel, tok); uid != "" {
+			return []string{uid}
+		}
+		return nil
+	}
+	cs := chans[toLower(channel)]
+	if cs == nil {
+		return nil
+	}
+	b := ChanBan{Mask: mask}
+	var out []string
+	for uid := range cs.Seen {
+		if b.matches(userBanMasks(uid), accDB.SessionAccount(uid)) {
+			out = append(out, uid)
+		}
+	}
+	return out
+}
+
+// doKick runs kick or kickban on channel for fromUID and returns the lines
+// to show. args are the words after the channel name.
+func doKick(l *Link, fromUID, cmd, channel string, args []string) []string {
+	if _, ok := acl.Owner(channel); !ok {
+		return []string{channel + " is not registered."}
+	}
+	if !canControlChannel(fromUID, channel, 'o') {
+		return []string{"You need +o on " + channel + " to use " + cmd + "."}
+	}
+	if len(args) < 1 {
+		return []string{"Usage: " + cmd + " <nick|mask> [reason]"}
+	}
+	tmpl := l.Cfg.KickReason
+	if cmd == "kickban" {
+		tmpl = l.Cfg.KickBanReason
+	}
+	reason := strings.Join(args[1:], " ")
+	if reason == "" {
+		reason = expandReason(tmpl, getNick(fromUID), channel)
+	}
+
+	actor := acl.Flags(channel, accDB.SessionAccount(fromUID))
+	var kick, refused []string
+	for _, uid := range kickTargets(channel, args[0]) {
+		if uid == l.Cfg.ServiceUID || uid == fromUID {
+			continue
+		}
+		if err := kickAllowed(actor, acl.Flags(channel, accDB.SessionAccount(uid))); err != nil {
+			refused = append(refused, getNick(uid)+" "+err.Error())
+			continue
+		}
+		kick = append(kick, uid)
+	}
+	if len(kick) == 0 && len(refused) == 0 {
+		return []string{"No one on " + channel + " matches " + args[0] + "."}
+	}
+	if cmd == "kickban" {
+		if len(refused) > 0 {
+			return []string{"Not banning: " + strings.Join(refused, ", ") + "."}
+		}
+		mask := kickMask(args[0])
+		if mask == "" {
+			mask = banMaskFor(args[0])
+		}
+		b := &ChanBan{Mask: mask}
+		if b.matches(userBanMasks(fromUID), accDB.SessionAccount(fromUID)) {
+			return []string{"Not banning " + mask + ": it matches you."}
+		}
+		if owner, _ := acl.Owner(channel); banCovers(b, owner) {
+			return []string{"Not banning " + mask + ": it covers " + owner + ", the owner of " + channel + "."}
+		}
+		if refused := banRefusals(actor, channel, b); len(refused) > 0 {
+			return []string{"Not banning: " + strings.Join(refused, ", ") + "."}
+		}
+		l.QChanMode(channel, "+b", mask)
+	}
+	for _, uid := range kick {
+		l.Kick(channel, uid, reason)
+	}
+	out := []string{"Kicked " + strconv.Itoa(len(kick)) + " user(s) from " + channel + "."}
+	if len(refused) > 0 {
+		out = append(out, "Not kicked: "+strings.Join(refused, ", ")+".")
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandReason(t *testing.T) {
+	if got := expandReason("Requested by {nick} on {chan}", "alice", "#feds"); got != "Requested by alice on #feds" {
+		t.Errorf("expandReason = %q", got)
+	}
+}
+
+func TestKickMask(t *testing.T) {
+	for tok, want := range map[string]string{
+		"bob":           "",
+		"bob*":          "bob*!*@*",
+		"*@bad.example": "*!*@bad.example",
+		"*!*@1.2.3.*":   "*!*@1.2.3.*",
+		"account:spam":  "account:spam",
+	} {
+		if got := kickMask(tok); got != want {
+			t.Errorf("kickMask(%q) = %q, want %q", tok, got, want)
+		}
+	}
+}
+
+func TestKickAllowed(t *testing.T) {
+	cases := []struct {
+		actor, target string
+		ok            bool
+	}{
+		{"ov", "", true},
+		{"ov", "v", true},
+		{"ov", "ov", false},
+		{"motv", "ov", true},
+		{"motv", "mo", false},
+		{"motv", "pv", false},
+		{"mnotv", "opv", true},
+		{"ov", "k", true},
+	}
+	for _, c := range cases {
+		if err := kickAllowed(c.actor, c.target); (err == nil) != c.ok {
+			t.Errorf("kickAllowed(%q, %q) = %v, want ok %v", c.actor, c.target, err, c.ok)
+		}
+	}
+}
+
+func TestKickbanMatchingRequester(t *testing.T) {
+	withAccountStores(t)
+	oldBans := bans
+	defer func() {
+		bans = oldBans
+		delete(chans, "#chan")
+		delNick("001AAAAAA")
+		delNick("001AAAAAB")
+	}()
+	bans = NewBanStore(filepath.Join(t.TempDir(), "bans.json"))
+	if err := accDB.Create("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	acl.SetOwner("#chan", "alice")
+	_, _ = acl.SetFlags("#chan", "alice", "mnotv")
+	accDB.Bind("001AAAAAA", "alice")
+	cs := newChanState(1)
+	chans["#chan"] = cs
+	for uid, nick := range map[string]string{"001AAAAAA": "alice", "001AAAAAB": "mallory"} {
+		setNick(uid, nick)
+		setUser(uid, userInfo{User: nick, Host: "shared.example"})
+		cs.Seen[uid] = true
+	}
+
+	l := &Link{Logger: NewLogger("error")}
+	got := doKick(l, "001AAAAAA", "kickban", "#chan", []string{"*!*@shared.example"})
+	if len(got) != 1 || got[0] != "Not banning *!*@shared.example: it matches you." {
+		t.Errorf("kickban of a mask matching the requester: %q", got)
+	}
+	got = doKick(l, "001AAAAAA", "kickban", "#chan", []string{"mallory!*@*"})
+	if len(got) != 1 || got[0] != "Kicked 1 user(s) from #chan." {
+		t.Errorf("kickban of another user: %q", got)
+	}
+}
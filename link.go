@@ -60,7 +60,13 @@ func (l *Link) ConnectAndRun(ctx context.Context) error {
 	l.lastRx = time.Now().UnixNano()
 
 	// New bus for this connection
-	l.Bus = NewBus(l.Logger)
+	bus := NewBus(l.Logger)
+	l.mu.Lock()
+	l.Bus = bus
+	l.mu.Unlock()
+	busCtx, stopBus := context.WithCancel(ctx)
+	defer stopBus()
+	go bus.Run(busCtx)
 
 	// Handshake
 	switch strings.ToLower(l.Cfg.Protocol) {
@@ -105,6 +111,7 @@ func (l *Link) readLoop(ctx context.Context) error {
 		return fmt.Errorf("readLoop: not connected")
 	}
 
+	bus := l.Bus
 	sc := bufio.NewScanner(c)
 	sc.Buffer(make([]byte, 64*1024), 1024*1024)
 
@@ -165,8 +172,8 @@ func (l *Link) readLoop(ctx context.Context) error {
 			l.remoteSID = msg.Params[2] // e.g., "034"
 		}
 
-		// Dispatch async
-		go l.Bus.Emit(l, msg)
+		// Dispatch in order on the bus goroutine
+		bus.Post(func() { bus.Emit(l, msg) })
 	}
 
 	if err := sc.Err(); err != nil {
@@ -175,6 +182,20 @@ func (l *Link) readLoop(ctx context.Context) error {
 	return fmt.Errorf("server closed the connection")
 }
 
+// Post runs fn on the bus goroutine, alongside the message handlers. Timers
+// use it for anything that touches service state. Without a bus (tests) fn
+// runs right away.
+func (l *Link) Post(fn func()) {
+	l.mu.Lock()
+	bus := l.Bus
+	l.mu.Unlock()
+	if bus == nil {
+		fn()
+		return
+	}
+	bus.Post(fn)
+}
+
 // SendRaw writes one IRC line with a short write deadline and flush.
 func (l *Link) SendRaw(format string, a ...any) error {
 	line := fmt.Sprintf(format, a...)
@@ -0,0 +1,312 @@
+// mail.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// MailMsg is one queued outbound mail. The queue is persisted so mails
+// survive restarts and uplink drops.
+type MailMsg struct {
+	ID       string `json:"id"`
+	To       string `json:"to"`
+	Subject  string `json:"subject"`
+	Body     string `json:"body"`
+	Attempts int    `json:"attempts"`
+	NextTry  int64  `json:"next_try"` // unix seconds
+	LastErr  string `json:"last_err,omitempty"`
+}
+
+// MailData is what templates can reference.
+type MailData struct {
+	Account string
+	Email   string
+	Code    string
+	QNick   string
+	Server  string
+	Expires string
+}
+
+type mailTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+var mailTemplates = map[string]mailTemplate{
+	"register": mustMailTemplate(
+		"Confirm your {{.Server}} account {{.Account}}",
+		"Hello {{.Account}},\n\n"+
+			"someone (hopefully you) registered the account {{.Account}} with this address.\n"+
+			"To activate it, send:\n\n"+
+			"  /msg {{.QNick}} confirm {{.Code}}\n\n"+
+			"If this wasn't you, ignore this mail.\n"),
+	"setemail": mustMailTemplate(
+		"Confirm your new email for {{.Account}}",
+		"Hello {{.Account}},\n\n"+
+			"to use {{.Email}} as the address of your account, send:\n\n"+
+			"  /msg {{.QNick}} confirm {{.Code}}\n"),
+	"resetpass": mustMailTemplate(
+		"Password reset for {{.Account}}",
+		"Hello {{.Account}},\n\n"+
+			"a password reset was issued for your account. The token is valid until {{.Expires}}:\n\n"+
+			"  /msg {{.QNick}} reset {{.Account}} {{.Code}} <newpassword>\n"),
+}
+
+func mustMailTemplate(subject, body string) mailTemplate {
+	return mailTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    template.Must(template.New("body").Parse(body)),
+	}
+}
+
+type Mailer struct {
+	mu     sync.Mutex
+	path   string
+	cfg    Config
+	log    *Logger
+	Outbox []MailMsg `json:"outbox"`
+	wake   chan struct{}
+}
+
+var mailer = NewMailer(Config{}, NewLogger("info"))
+
+func NewMailer(cfg Config, log *Logger) *Mailer {
+	path := cfg.OutboxPath
+	if path == "" {
+		path = "outbox.json"
+	}
+	return &Mailer{
+		path: path,
+		cfg:  cfg,
+		log:  log,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Enabled reports whether a transport (SMTP or sendmail) is configured.
+func (m *Mailer) Enabled() bool {
+	return m.cfg.SendmailPath != "" || m.cfg.SMTPHost != ""
+}
+
+func (m *Mailer) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.Open(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(m)
+}
+
+// save must be called with m.mu held. Queued mails carry verification and
+// reset codes, so the outbox is only readable by us, and mails leave it as
+// soon as they are delivered or given up on.
+func (m *Mailer) save() error {
+	return writeJSONAtomic(m.path, m)
+}
+
+// Queue renders the named template and adds the mail to the outbox.
+func (m *Mailer) Queue(tmpl, to string, data MailData) error {
+	if !m.Enabled() {
+		return errors.New("mail is not configured")
+	}
+	t, ok := mailTemplates[tmpl]
+	if !ok {
+		return fmt.Errorf("unknown mail template %q", tmpl)
+	}
+	data.QNick = m.cfg.QNick
+	data.Server = m.cfg.ServerName
+	data.Email = to
+	var subj, body bytes.Buffer
+	if err := t.subject.Execute(&subj, data); err != nil {
+		return err
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return err
+	}
+	id, err := randomToken(8)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.Outbox = append(m.Outbox, MailMsg{
+		ID:      id,
+		To:      to,
+		Subject: subj.String(),
+		Body:    body.String(),
+		NextTry: time.Now().Unix(),
+	})
+	err = m.save()
+	m.mu.Unlock()
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// Run delivers queued mails until ctx is done. Failed deliveries are retried
+// with exponential backoff and dropped after MailMaxAttempts.
+func (m *Mailer) Run(ctx context.Context) {
+	t := time.NewTicker(15 * time.Second)
+	defer t.Stop()
+	for {
+		m.flush()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-m.wake:
+		}
+	}
+}
+
+func (m *Mailer) flush() {
+	now := time.Now().Unix()
+	m.mu.Lock()
+	due := make([]MailMsg, 0)
+	for _, msg := range m.Outbox {
+		if msg.NextTry <= now {
+			due = append(due, msg)
+		}
+	}
+	m.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	results := make(map[string]error, len(due))
+	for _, msg := range due {
+		results[msg.ID] = m.deliver(msg)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keep := m.Outbox[:0]
+	for _, msg := range m.Outbox {
+		err, tried := results[msg.ID]
+		switch {
+		case !tried:
+			keep = append(keep, msg)
+		case err == nil:
+			m.log.Infof("mail %s delivered to %s", msg.ID, msg.To)
+		default:
+			msg.Attempts++
+			msg.LastErr = err.Error()
+			if msg.Attempts >= m.cfg.MailMaxAttempts {
+				m.log.Errorf("mail %s to %s dropped after %d attempts: %v", msg.ID, msg.To, msg.Attempts, err)
+				continue
+			}
+			backoff := time.Duration(30<<uint(msg.Attempts-1)) * time.Second
+			msg.NextTry = time.Now().Add(backoff).Unix()
+			m.log.Warnf("mail %s to %s failed (attempt %d, retry in %s): %v", msg.ID, msg.To, msg.Attempts, backoff, err)
+			keep = append(keep, msg)
+		}
+	}
+	m.Outbox = keep
+	if err := m.save(); err != nil {
+		m.log.Errorf("saving outbox: %v", err)
+	}
+}
+
+// mailTimeout bounds one delivery, so a stuck SMTP server or sendmail
+// can't hold up the queue.
+var mailTimeout = time.Minute
+
+func (m *Mailer) deliver(msg MailMsg) error {
+	raw := m.render(msg)
+	if m.cfg.SendmailPath != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		args := strings.Fields(m.cfg.SendmailPath)
+		cmd := exec.CommandContext(ctx, args[0], append(args[1:], "-t", "-i")...)
+		cmd.Stdin = bytes.NewReader(raw)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	return m.sendSMTP(msg.To, raw)
+}
+
+// sendSMTP does what smtp.SendMail does, under a deadline: STARTTLS when
+// offered, AUTH PLAIN when a user is configured.
+func (m *Mailer) sendSMTP(to string, raw []byte) error {
+	addr := net.JoinHostPort(m.cfg.SMTPHost, strconv.Itoa(m.cfg.SMTPPort))
+	conn, err := net.DialTimeout("tcp", addr, mailTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(mailTimeout)); err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, m.cfg.SMTPHost)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.cfg.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if m.cfg.SMTPUser != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support AUTH")
+		}
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.SMTPUser, m.cfg.SMTPPass, m.cfg.SMTPHost)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.cfg.MailFrom); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (m *Mailer) render(msg MailMsg) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.cfg.MailFrom)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", msg.ID, m.cfg.ServerName)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
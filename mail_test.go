@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is just enough of an SMTP server for the mailer: it accepts
+// every mail, or refuses recipients with 451 while failing is set.
+type fakeSMTP struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	failing bool
+	mails   []string // DATA of accepted mails
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) setFailing(v bool) {
+	s.mu.Lock()
+	s.failing = v
+	s.mu.Unlock()
+}
+
+func (s *fakeSMTP) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.mails...)
+}
+
+func (s *fakeSMTP) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	fmt.Fprint(c, "220 fake ESMTP\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			fmt.Fprint(c, "250 fake\r\n")
+		case strings.HasPrefix(cmd, "RCPT"):
+			s.mu.Lock()
+			failing := s.failing
+			s.mu.Unlock()
+			if failing {
+				fmt.Fprint(c, "451 try again later\r\n")
+			} else {
+				fmt.Fprint(c, "250 ok\r\n")
+			}
+		case cmd == "DATA":
+			fmt.Fprint(c, "354 go ahead\r\n")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			s.mails = append(s.mails, data.String())
+			s.mu.Unlock()
+			fmt.Fprint(c, "250 queued\r\n")
+		case cmd == "QUIT":
+			fmt.Fprint(c, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(c, "250 ok\r\n")
+		}
+	}
+}
+
+func TestMailerDelivery(t *testing.T) {
+	srv := startFakeSMTP(t)
+	cfg := Config{
+		SMTPHost:        "127.0.0.1",
+		SMTPPort:        srv.port(),
+		MailFrom:        "q@services.example",
+		MailMaxAttempts: 2,
+		OutboxPath:      filepath.Join(t.TempDir(), "outbox.json"),
+		QNick:           "Q",
+		ServerName:      "services.example",
+	}
+	m := NewMailer(cfg, NewLogger("error"))
+
+	// enqueue: the mail is rendered and on disk before any delivery
+	if err := m.Queue("register", "alice@example.net", MailData{Account: "alice", Code: "abc123"}); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewMailer(cfg, NewLogger("error"))
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Outbox) != 1 || loaded.Outbox[0].To != "alice@example.net" {
+		t.Fatalf("persisted outbox = %+v", loaded.Outbox)
+	}
+
+	// retry: a refused delivery stays queued with a backoff, also on disk
+	srv.setFailing(true)
+	m.flush()
+	if len(m.Outbox) != 1 || m.Outbox[0].Attempts != 1 || m.Outbox[0].LastErr == "" {
+		t.Fatalf("outbox after a failure = %+v", m.Outbox)
+	}
+	if m.Outbox[0].NextTry <= time.Now().Unix() {
+		t.Error("failed mail not backed off")
+	}
+	m.flush()
+	if m.Outbox[0].Attempts != 1 {
+		t.Error("mail retried before its backoff ran out")
+	}
+	loaded = NewMailer(cfg, NewLogger("error"))
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Outbox) != 1 || loaded.Outbox[0].Attempts != 1 {
+		t.Fatalf("persisted outbox after a failure = %+v", loaded.Outbox)
+	}
+
+	// flush: once the server takes it, the mail is delivered and gone
+	srv.setFailing(false)
+	loaded.Outbox[0].NextTry = time.Now().Unix()
+	loaded.flush()
+	if len(loaded.Outbox) != 0 {
+		t.Fatalf("outbox after delivery = %+v", loaded.Outbox)
+	}
+	mails := srv.received()
+	if len(mails) != 1 || !strings.Contains(mails[0], "To: alice@example.net") || !strings.Contains(mails[0], "confirm abc123") {
+		t.Fatalf("server got %q", mails)
+	}
+	if err := m.Load(); err != nil || len(m.Outbox) != 0 {
+		t.Errorf("persisted outbox after delivery = %+v (%v)", m.Outbox, err)
+	}
+
+	// a mail that keeps failing is dropped after MailMaxAttempts
+	srv.setFailing(true)
+	if err := m.Queue("resetpass", "bob@example.net", MailData{Account: "bob", Code: "xyz"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < cfg.MailMaxAttempts; i++ {
+		if len(m.Outbox) == 1 {
+			m.Outbox[0].NextTry = time.Now().Unix()
+		}
+		m.flush()
+	}
+	if len(m.Outbox) != 0 {
+		t.Errorf("outbox after %d failures = %+v", cfg.MailMaxAttempts, m.Outbox)
+	}
+}
+
+func TestMailerTimeout(t *testing.T) {
+	old := mailTimeout
+	defer func() { mailTimeout = old }()
+	mailTimeout = 200 * time.Millisecond
+
+	// a server that accepts and never says a word
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	m := NewMailer(Config{SMTPHost: "127.0.0.1", SMTPPort: p, MailFrom: "q@services.example"}, NewLogger("error"))
+
+	start := time.Now()
+	if err := m.deliver(MailMsg{ID: "x", To: "alice@example.net"}); err == nil {
+		t.Fatal("delivery to a silent server succeeded")
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("delivery took %s despite the timeout", took)
+	}
+}
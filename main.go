@@ -34,12 +34,44 @@ func main() {
 		cancel()
 	}()
 
+	policy, err := HashPolicyFromConfig(cfg)
+	if err != nil {
+		logger.Fatalf("password hashing: %v", err)
+	}
+	accDB.SetHashPolicy(policy)
+	if cfg.TOTPKeyFile != "" {
+		if err := LoadTOTPKey(cfg.TOTPKeyFile); err != nil {
+			logger.Fatalf("totp key: %v", err)
+		}
+	}
+	throttle.Configure(cfg.LockoutThreshold, time.Duration(cfg.LockoutMins)*time.Minute)
+	go runThrottlePurge(ctx)
+	go runPendingPurge(ctx, logger, time.Duration(cfg.PendingExpireHours)*time.Hour)
+	regLimit.Configure(RegLimits{
+		PerHost:    cfg.RegPerHost,
+		Window:     time.Duration(cfg.RegWindowMins) * time.Minute,
+		Cooldown:   time.Duration(cfg.RegCooldownSecs) * time.Second,
+		MaxChans:   cfg.MaxChansPerAccount,
+		MinAccount: time.Duration(cfg.ChanRegMinAgeMins) * time.Minute,
+	})
+	if err := regLimit.Load(); err != nil {
+		logger.Errorf("failed to load registration limits: %v", err)
+	}
+
+	mailer = NewMailer(cfg, logger)
+	if err := mailer.Load(); err != nil {
+		logger.Errorf("failed to load outbox %s: %v", cfg.OutboxPath, err)
+	}
+	go mailer.Run(ctx)
+
 	link := &Link{
 		Cfg:    cfg,
 		Logger: logger,
 		Bus:    NewBus(logger),
 	}
 
+	go runBanExpiry(ctx, link)
+
 	// Reconnect loop
 	backoff := time.Second
 	for ctx.Err() == nil {
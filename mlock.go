@@ -0,0 +1,201 @@
+// mlock.go
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Mode locks. A registered channel may lock modes on ("+nt", "+k key",
+// "+l 50") or off ("-s"). Q reverts changes to locked modes as they happen
+// and re-applies the whole lock when the channel is created or its TS is
+// reset. Locks are stored as written, e.g. "+ntl-s 50".
+
+// parseMLock reads a lock like "+ntkl-s key 50". Modes locked on that take
+// a parameter need one; modes locked off never take one.
+func parseMLock(lock string) ([]modeChange, error) {
+	f := strings.Fields(lock)
+	if len(f) == 0 {
+		return nil, errors.New("empty mode lock")
+	}
+	modes, params := f[0], f[1:]
+	if modes[0] != '+' && modes[0] != '-' {
+		return nil, errors.New("mode locks look like +nt-s or +l 50")
+	}
+	var out []modeChange
+	seen := map[byte]bool{}
+	adding := true
+	for i := 0; i < len(modes); i++ {
+		c := modes[i]
+		switch c {
+		case '+':
+			adding = true
+			continue
+		case '-':
+			adding = false
+			continue
+		}
+		if seen[c] {
+			return nil, errors.New("mode " + string(c) + " is locked twice")
+		}
+		seen[c] = true
+		mc := modeChange{Adding: adding, Mode: c}
+		if adding && modeTakesParam(c, true) {
+			if len(params) == 0 {
+				return nil, errors.New("+" + string(c) + " needs a parameter")
+			}
+			mc.Param, params = params[0], params[1:]
+		}
+		out = append(out, mc)
+	}
+	if len(params) > 0 {
+		return nil, errors.New("too many parameters")
+	}
+	return out, nil
+}
+
+// checkMLock validates a parsed lock against the modes the ircd advertised
+// in CAPAB CHANMODES.
+func checkMLock(lock []modeChange) error {
+	if len(chanModeTypes) == 0 {
+		return errors.New("the ircd's channel modes aren't known yet")
+	}
+	for _, mc := range lock {
+		switch chanModeTypes[mc.Mode] {
+		case "":
+			return errors.New("the ircd has no channel mode " + string(mc.Mode))
+		case "list", "prefix":
+			return errors.New("list and prefix modes like " + string(mc.Mode) + " can't be locked")
+		}
+		if !mc.Adding && strings.IndexByte(regChanModes(), mc.Mode) >= 0 {
+			return errors.New("Q keeps +" + string(mc.Mode) + " set on registered channels")
+		}
+	}
+	return nil
+}
+
+// fmtMLock renders a parsed lock in canonical form: modes on, then off.
+func fmtMLock(lock []modeChange) string {
+	var on, off []modeChange
+	for _, mc := range lock {
+		if mc.Adding {
+			on = append(on, mc)
+		} else {
+			off = append(off, mc)
+		}
+	}
+	modes, params := fmtModes(append(on, off...))
+	return strings.Join(append([]string{modes}, params...), " ")
+}
+
+// mlockFixes returns the changes that undo whatever of changes breaks
+// lock. Only the last change to each mode counts.
+func mlockFixes(lock, changes []modeChange) []modeChange {
+	last := map[byte]modeChange{}
+	for _, mc := range changes {
+		last[mc.Mode] = mc
+	}
+	var fixes []modeChange
+	for _, lk := range lock {
+		mc, ok := last[lk.Mode]
+		if !ok {
+			continue
+		}
+		switch {
+		case lk.Adding && (!mc.Adding || (lk.Param != "" && mc.Param != lk.Param)):
+			fixes = append(fixes, lk)
+		case !lk.Adding && mc.Adding:
+			fix := modeChange{Mode: mc.Mode}
+			if modeTakesParam(mc.Mode, false) {
+				fix.Param = mc.Param
+			}
+			fixes = append(fixes, fix)
+		}
+	}
+	return fixes
+}
+
+// channelMLock returns the parsed lock of a registered channel. knownonly
+// adds +i unless the mlock itself locks i.
+func channelMLock(channel string) []modeChange {
+	var lock []modeChange
+	if s := acl.MLock(channel); s != "" {
+		lock, _ = parseMLock(s)
+	}
+	if i := inviteOnlyMode(); chanFlag(channel, "knownonly") && !lockHas(lock, i, true) && !lockHas(lock, i, false) {
+		lock = append(lock, modeChange{Mode: i, Adding: true})
+	}
+	return lock
+}
+
+// lockHas reports whether lock sets (adding) or unsets mode.
+func lockHas(lock []modeChange, mode byte, adding bool) bool {
+	for _, mc := range lock {
+		if mc.Mode == mode && mc.Adding == adding {
+			return true
+		}
+	}
+	return false
+}
+
+// enforceMLock reverts changes to channel that break its lock.
+func enforceMLock(l *Link, channel string, changes []modeChange) {
+	lock := channelMLock(channel)
+	cs := chans[toLower(channel)]
+	if lock == nil || cs == nil {
+		return
+	}
+	if fixes := mlockFixes(lock, changes); len(fixes) > 0 {
+		sendModes(l, channel, cs.TS, fixes)
+	}
+}
+
+// applyMLock sets the whole lock on channel, for new channels and TS resets.
+func applyMLock(l *Link, channel string) {
+	lock := channelMLock(channel)
+	cs := chans[toLower(channel)]
+	if lock == nil || cs == nil {
+		return
+	}
+	var changes []modeChange
+	for _, mc := range lock {
+		if !mc.Adding && modeTakesParam(mc.Mode, false) {
+			mc.Param = "*" // we don't know the key; any will do from a server
+		}
+		changes = append(changes, mc)
+	}
+	sendModes(l, channel, cs.TS, changes)
+}
+
+// doMLock shows or changes channel's mode lock for fromUID and returns the
+// lines to show. args are the words after the channel name.
+func doMLock(l *Link, fromUID, channel string, args []string) []string {
+	if _, ok := acl.Owner(channel); !ok {
+		return []string{channel + " is not registered."}
+	}
+	if len(args) == 0 {
+		if lock := acl.MLock(channel); lock != "" {
+			return []string{"Mode lock for " + channel + ": " + lock}
+		}
+		return []string{channel + " has no mode lock."}
+	}
+	if !canControlChannel(fromUID, channel, 'm') {
+		return []string{"You need +m on " + channel + " to change its mode lock."}
+	}
+	if strings.EqualFold(args[0], "none") {
+		acl.SetMLock(channel, "")
+		_ = acl.Save()
+		return []string{"Mode lock for " + channel + " removed."}
+	}
+	lock, err := parseMLock(strings.Join(args, " "))
+	if err == nil {
+		err = checkMLock(lock)
+	}
+	if err != nil {
+		return []string{"Bad mode lock: " + err.Error() + "."}
+	}
+	acl.SetMLock(channel, fmtMLock(lock))
+	_ = acl.Save()
+	applyMLock(l, channel)
+	return []string{"Mode lock for " + channel + " is now " + acl.MLock(channel) + "."}
+}
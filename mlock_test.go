@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMLock(t *testing.T) {
+	lock, err := parseMLock("+ntl-sk 50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []modeChange{{true, 'n', ""}, {true, 't', ""}, {true, 'l', "50"}, {false, 's', ""}, {false, 'k', ""}}
+	if !reflect.DeepEqual(lock, want) {
+		t.Errorf("parseMLock = %+v, want %+v", lock, want)
+	}
+	if got := fmtMLock([]modeChange{{false, 's', ""}, {true, 'k', "key"}, {true, 'n', ""}}); got != "+kn-s key" {
+		t.Errorf("fmtMLock = %q", got)
+	}
+	for _, bad := range []string{"", "nt", "+k", "+n extra", "+n-n"} {
+		if _, err := parseMLock(bad); err == nil {
+			t.Errorf("parseMLock(%q) accepted", bad)
+		}
+	}
+}
+
+func TestCheckMLock(t *testing.T) {
+	defer func() {
+		chanModeTypes = map[byte]string{}
+		chanModeNames = map[string]byte{}
+	}()
+	lock, _ := parseMLock("+nt-s")
+	if err := checkMLock(lock); err == nil {
+		t.Error("lock accepted before CHANMODES was known")
+	}
+	learnChanModes("list:ban=b param:key=k param-set:limit=l prefix:30000:op=@o simple:noextmsg=n simple:topiclock=t simple:secret=s simple:c_registered=r")
+	if err := checkMLock(lock); err != nil {
+		t.Errorf("checkMLock(+nt-s) = %v", err)
+	}
+	for _, bad := range []string{"+C", "+b *!*@*", "-r"} {
+		lock, err := parseMLock(bad)
+		if err == nil {
+			err = checkMLock(lock)
+		}
+		if err == nil {
+			t.Errorf("lock %q accepted", bad)
+		}
+	}
+}
+
+func TestMLockFixes(t *testing.T) {
+	lock, _ := parseMLock("+ntl-sk 50")
+	changes := parseModes("-n+s+l+k-t+t", []string{"10", "secret"})
+	want := []modeChange{{true, 'n', ""}, {true, 'l', "50"}, {false, 's', ""}, {false, 'k', "secret"}}
+	if got := mlockFixes(lock, changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("mlockFixes = %+v\nwant %+v", got, want)
+	}
+	if got := mlockFixes(lock, parseModes("+l+im", []string{"50"})); len(got) != 0 {
+		t.Errorf("changes within the lock needed fixes: %+v", got)
+	}
+}
+
+func TestFmtModes(t *testing.T) {
+	modes, params := fmtModes([]modeChange{{true, 'o', "u1"}, {true, 'l', "5"}, {false, 'k', "x"}, {false, 'm', ""}})
+	if modes != "+ol-km" || !reflect.DeepEqual(params, []string{"u1", "5", "x"}) {
+		t.Errorf("fmtModes = %q %v", modes, params)
+	}
+}
+
+func TestFJoinState(t *testing.T) {
+	defer delete(chans, "#fjoin")
+	stale := func(ts int64, members ...string) {
+		cs := newChanState(ts)
+		for _, uid := range members {
+			cs.join(uid, "o")
+		}
+		cs.Bans["*!*@old.example"] = true
+		cs.Limit = 50
+		chans["#fjoin"] = cs
+	}
+	tests := []struct {
+		name        string
+		setup       func()
+		ts          int64
+		wantTS      int64
+		fresh, gone bool
+	}{
+		{"new channel", func() { delete(chans, "#fjoin") }, 200, 200, true, false},
+		{"same ts", func() { stale(200, "042AAAAAA") }, 200, 200, false, false},
+		{"newer ts", func() { stale(200, "042AAAAAA") }, 300, 200, false, false},
+		{"lower ts", func() { stale(200, "042AAAAAA") }, 100, 100, true, false},
+		{"recreated after emptying", func() { stale(200) }, 300, 300, true, true},
+	}
+	for _, tt := range tests {
+		tt.setup()
+		cs, fresh, emptied := fjoinState("#FJoin", tt.ts)
+		if fresh != tt.fresh || emptied != tt.gone || cs.TS != tt.wantTS {
+			t.Errorf("%s: fresh %v emptied %v ts %d; want %v %v %d", tt.name, fresh, emptied, cs.TS, tt.fresh, tt.gone, tt.wantTS)
+		}
+		if fresh && (len(cs.Ops) != 0 || len(cs.Bans) != 0 || cs.Limit != 0) {
+			t.Errorf("%s: modes survived: ops %v bans %v limit %d", tt.name, cs.Ops, cs.Bans, cs.Limit)
+		}
+	}
+}
@@ -29,6 +29,40 @@ func delNick(uid string) {
 		delete(nickToUID, strings.ToLower(n))
 		delete(uidToNick, uid)
 	}
+	delete(uidToUser, uid)
+}
+
+// userInfo is what we learn about a client from its UID line.
+type userInfo struct {
+	User  string // real ident
+	Host  string // real host
+	VHost string // displayed host
+	IP    string
+}
+
+var uidToUser = make(map[string]userInfo)
+
+// setUser stores the ident/host details for a UID.
+func setUser(uid string, u userInfo) {
+	uidToUser[uid] = u
+}
+
+// getUser returns what we know about a UID.
+func getUser(uid string) (userInfo, bool) {
+	u, ok := uidToUser[uid]
+	return u, ok
+}
+
+// userHostKey identifies where a client connects from: its IP, else its host.
+func userHostKey(uid string) string {
+	u, ok := uidToUser[uid]
+	if !ok {
+		return ""
+	}
+	if u.IP != "" && u.IP != "0.0.0.0" {
+		return u.IP
+	}
+	return u.Host
 }
 
 // (Optional utility if you ever need it)
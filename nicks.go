@@ -0,0 +1,280 @@
+// nicks.go
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Nick ownership. An account owns its own name as a nick plus any nicks it
+// grouped. A client using an owned nick without being logged in to the owner
+// is warned and, once the grace period runs out, renamed to a guest nick with
+// SVSNICK. Owners get their nick back with ghost (kill the holder), recover
+// (rename the holder and hold the nick for a while) and release (drop the
+// hold early).
+
+// nickHold keeps a recovered nick free for its owner until Until.
+type nickHold struct {
+	Account string
+	Until   time.Time
+}
+
+type NickEnforcer struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer // uid -> pending guest rename
+	holds  map[string]nickHold    // lower(nick) -> hold
+}
+
+var nickEnf = NewNickEnforcer()
+
+func NewNickEnforcer() *NickEnforcer {
+	return &NickEnforcer{
+		timers: make(map[string]*time.Timer),
+		holds:  make(map[string]nickHold),
+	}
+}
+
+// Schedule runs fn for uid after d, replacing any pending run.
+func (e *NickEnforcer) Schedule(uid string, d time.Duration, fn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if t := e.timers[uid]; t != nil {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		e.mu.Lock()
+		current := e.timers[uid] == t
+		if current {
+			delete(e.timers, uid)
+		}
+		e.mu.Unlock()
+		if current {
+			fn()
+		}
+	})
+	e.timers[uid] = t
+}
+
+// Cancel drops uid's pending rename, if any.
+func (e *NickEnforcer) Cancel(uid string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if t := e.timers[uid]; t != nil {
+		t.Stop()
+		delete(e.timers, uid)
+	}
+}
+
+// Hold reserves nick for account for d.
+func (e *NickEnforcer) Hold(nick, account string, d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.holds[toLower(nick)] = nickHold{Account: account, Until: time.Now().Add(d)}
+}
+
+// Release drops the hold on nick and reports whether there was one.
+func (e *NickEnforcer) Release(nick string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	h, ok := e.holds[toLower(nick)]
+	delete(e.holds, toLower(nick))
+	return ok && time.Now().Before(h.Until)
+}
+
+// HeldFor returns the account an active hold on nick is for.
+func (e *NickEnforcer) HeldFor(nick string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	h, ok := e.holds[toLower(nick)]
+	if !ok {
+		return "", false
+	}
+	if !time.Now().Before(h.Until) {
+		delete(e.holds, toLower(nick))
+		return "", false
+	}
+	return h.Account, true
+}
+
+// checkNick enforces nick ownership for uid's current nick. It runs on
+// connect, on nick change and whenever uid's login changes.
+func checkNick(l *Link, uid string) {
+	nickEnf.Cancel(uid)
+	nick := getNick(uid)
+	if uid == l.Cfg.ServiceUID || nick == "" {
+		return
+	}
+	if f, ok := forbids.Match(forbidNick, nick); ok {
+		l.NoticeFromService(uid, "The nick "+nick+" is forbidden: "+f.Reason)
+		forceGuestNick(l, uid)
+		return
+	}
+	owner := accDB.NickOwner(nick)
+	held, isHeld := nickEnf.HeldFor(nick)
+	if isHeld {
+		owner = held
+	}
+	if owner == "" || strings.EqualFold(accDB.SessionAccount(uid), owner) {
+		return
+	}
+	if isHeld {
+		l.NoticeFromService(uid, "The nick "+nick+" is being held for its owner.")
+		forceGuestNick(l, uid)
+		return
+	}
+	grace := time.Duration(l.Cfg.NickGraceSecs) * time.Second
+	l.NoticeFromService(uid, "The nick "+nick+" belongs to another account. Log in to it or change your nick within "+fmtDuration(grace)+", or it will be changed for you.")
+	nickEnf.Schedule(uid, grace, func() {
+		l.Post(func() {
+			if !strings.EqualFold(getNick(uid), nick) || strings.EqualFold(accDB.SessionAccount(uid), owner) {
+				return
+			}
+			forceGuestNick(l, uid)
+		})
+	})
+}
+
+// forceGuestNick renames uid to a free guest nick.
+func forceGuestNick(l *Link, uid string) {
+	guest := guestNick(l.Cfg.GuestPrefix)
+	l.SVSNick(uid, guest)
+	l.NoticeFromService(uid, "Your nick has been changed to "+guest+".")
+}
+
+// guestNick picks prefix plus five digits, avoiding nicks in use.
+func guestNick(prefix string) string {
+	var nick string
+	for i := 0; i < 10; i++ {
+		nick = fmt.Sprintf("%s%05d", prefix, rand.IntN(100000))
+		if getUIDByNick(nick) == "" {
+			break
+		}
+	}
+	return nick
+}
+
+// asNickOwner runs fn once fromUID is shown to act for the owner of a nick:
+// by being logged in to it, or by giving its password. Wrong passwords
+// count as failed logins.
+func asNickOwner(l *Link, fromUID, owner, password, method string, fn func()) {
+	if strings.EqualFold(accDB.SessionAccount(fromUID), owner) {
+		fn()
+		return
+	}
+	if password == "" {
+		l.NoticeFromService(fromUID, "You must be logged in to "+owner+" or give its password.")
+		return
+	}
+	host := userHostKey(fromUID)
+	if wait, _ := throttle.Check(fromUID, host, owner); wait > 0 {
+		l.NoticeFromService(fromUID, "Too many failed logins. Try again in "+fmtDuration(wait)+".")
+		return
+	}
+	started := verifyAsync(l, fromUID, owner, password, func(ok bool) {
+		if !ok {
+			recordLoginFailure(l, fromUID, host, owner, userMask(fromUID), authEvent(fromUID, method, false))
+			l.NoticeFromService(fromUID, "Password incorrect.")
+			return
+		}
+		throttle.Success(fromUID, owner)
+		fn()
+	})
+	if !started {
+		l.NoticeFromService(fromUID, verifyBusy)
+	}
+}
+
+// handleNickCommand serves nick group|ungroup|list.
+func handleNickCommand(l *Link, fromUID string, parts []string) {
+	acc := accDB.SessionAccount(fromUID)
+	if acc == "" {
+		l.NoticeFromService(fromUID, "Login required.")
+		return
+	}
+	switch strings.ToLower(getOr(parts, 1, "")) {
+	case "group":
+		nick := getNick(fromUID)
+		if f, ok := forbids.Match(forbidNick, nick); ok {
+			l.NoticeFromService(fromUID, "Cannot group "+nick+": forbidden: "+f.Reason)
+			return
+		}
+		if err := accDB.GroupNick(acc, nick, l.Cfg.MaxGroupedNicks); err != nil {
+			l.NoticeFromService(fromUID, "Cannot group "+nick+": "+err.Error())
+			return
+		}
+		_ = accDB.Save()
+		l.NoticeFromService(fromUID, "Nick "+nick+" is now grouped to "+acc+".")
+
+	case "ungroup":
+		nick := getOr(parts, 2, getNick(fromUID))
+		if err := accDB.UngroupNick(acc, nick); err != nil {
+			l.NoticeFromService(fromUID, "Cannot ungroup "+nick+": "+err.Error())
+			return
+		}
+		_ = accDB.Save()
+		l.NoticeFromService(fromUID, "Nick "+nick+" is no longer grouped to "+acc+".")
+
+	case "list":
+		a, _ := accDB.Get(acc)
+		l.NoticeFromService(fromUID, "Nicks owned by "+a.Name+": "+strings.Join(append([]string{a.Name}, a.Nicks...), ", "))
+
+	default:
+		l.NoticeFromService(fromUID, "Usage: nick group | nick ungroup [nick] | nick list")
+	}
+}
+
+// handleNickRecovery serves ghost, recover and release.
+func handleNickRecovery(l *Link, fromUID, cmd string, parts []string) {
+	if len(parts) < 2 {
+		l.NoticeFromService(fromUID, "Usage: "+cmd+" <nick> [password]")
+		return
+	}
+	nick := parts[1]
+	owner := accDB.NickOwner(nick)
+	if owner == "" {
+		l.NoticeFromService(fromUID, nick+" is not a registered nick.")
+		return
+	}
+	asNickOwner(l, fromUID, owner, getOr(parts, 2, ""), cmd, func() {
+		recoverNick(l, fromUID, cmd, nick, owner)
+	})
+}
+
+// recoverNick carries out ghost, recover or release for the owner of nick.
+func recoverNick(l *Link, fromUID, cmd, nick, owner string) {
+	target := getUIDByNick(nick)
+
+	switch cmd {
+	case "ghost":
+		if target == "" || target == fromUID {
+			l.NoticeFromService(fromUID, nick+" is not in use by another client.")
+			return
+		}
+		l.Kill(target, "GHOST command used by "+getNick(fromUID))
+		forgetUser(target, "kill: ghost")
+		l.NoticeFromService(fromUID, nick+" has been ghosted.")
+
+	case "recover":
+		if target == "" || target == fromUID {
+			l.NoticeFromService(fromUID, nick+" is not in use by another client.")
+			return
+		}
+		hold := time.Duration(l.Cfg.NickHoldSecs) * time.Second
+		nickEnf.Hold(nick, owner, hold)
+		nickEnf.Cancel(target)
+		l.NoticeFromService(target, "The nick "+nick+" has been recovered by its owner.")
+		forceGuestNick(l, target)
+		l.NoticeFromService(fromUID, nick+" has been recovered and is held for "+owner+" for "+fmtDuration(hold)+". Log in to "+owner+" and change to it, or use: release "+nick)
+
+	case "release":
+		if !nickEnf.Release(nick) {
+			l.NoticeFromService(fromUID, nick+" is not being held.")
+			return
+		}
+		l.NoticeFromService(fromUID, nick+" has been released.")
+	}
+}
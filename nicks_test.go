@@ -0,0 +1,134 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGroupNick(t *testing.T) {
+	db := NewAccountDB(filepath.Join(t.TempDir(), "accounts.json"))
+	for _, n := range []string{"sherby", "bob"} {
+		if err := db.Create(n, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.GroupNick("sherby", "Sherby_", 2); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name    string
+		acc     string
+		nick    string
+		wantErr bool
+	}{
+		{"own account name", "sherby", "SHERBY", true},
+		{"another account's name", "sherby", "bob", true},
+		{"grouped elsewhere", "bob", "sherby_", true},
+		{"already grouped", "sherby", "sherby_", true},
+		{"second nick", "sherby", "sherb", false},
+		{"over the limit", "sherby", "sherbz", true},
+	}
+	for _, s := range steps {
+		if err := db.GroupNick(s.acc, s.nick, 2); (err != nil) != s.wantErr {
+			t.Errorf("%s: GroupNick = %v, want error %v", s.name, err, s.wantErr)
+		}
+	}
+
+	for nick, want := range map[string]string{"SHERBY": "sherby", "sherby_": "sherby", "Bob": "bob", "nobody": ""} {
+		if got := db.NickOwner(nick); got != want {
+			t.Errorf("NickOwner(%q) = %q, want %q", nick, got, want)
+		}
+	}
+	if err := db.Create("Sherb", "x"); err == nil {
+		t.Error("account created over a grouped nick")
+	}
+
+	if err := db.UngroupNick("bob", "sherby_"); err == nil {
+		t.Error("ungrouped another account's nick")
+	}
+	if err := db.UngroupNick("sherby", "SHERBY_"); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.NickOwner("sherby_"); got != "" {
+		t.Errorf("ungrouped nick still owned by %q", got)
+	}
+}
+
+func TestNickHold(t *testing.T) {
+	e := NewNickEnforcer()
+	e.Hold("Sherby", "sherby", time.Minute)
+	if acc, ok := e.HeldFor("SHERBY"); !ok || acc != "sherby" {
+		t.Fatalf("HeldFor = %q, %v; want sherby, true", acc, ok)
+	}
+	if !e.Release("sherby") || e.Release("sherby") {
+		t.Error("Release should succeed exactly once")
+	}
+
+	e.Hold("bob", "bob", -time.Second)
+	if _, ok := e.HeldFor("bob"); ok {
+		t.Error("expired hold still active")
+	}
+}
+
+func TestNickEnforcerCancel(t *testing.T) {
+	e := NewNickEnforcer()
+	fired := make(chan string, 2)
+	e.Schedule("042AAAAAB", 10*time.Millisecond, func() { fired <- "first" })
+	e.Schedule("042AAAAAB", 20*time.Millisecond, func() { fired <- "second" })
+	e.Schedule("042AAAAAC", 10*time.Millisecond, func() { fired <- "cancelled" })
+	e.Cancel("042AAAAAC")
+
+	select {
+	case got := <-fired:
+		if got != "second" {
+			t.Fatalf("%s run fired, want only the replacement", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("scheduled run never fired")
+	}
+	select {
+	case got := <-fired:
+		t.Fatalf("unexpected run %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRestoreSession(t *testing.T) {
+	oldDB, oldSuspend := accDB, suspend
+	defer func() { accDB, suspend = oldDB, oldSuspend }()
+	dir := t.TempDir()
+	accDB = NewAccountDB(filepath.Join(dir, "accounts.json"))
+	suspend = NewSuspendStore(filepath.Join(dir, "suspended.json"))
+	for _, n := range []string{"Sherby", "bob", "carol"} {
+		if err := accDB.Create(n, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	suspend.SuspendAcc("carol", time.Now().Add(time.Hour).Unix(), "test")
+	accDB.Bind("042AAAAAD", "bob")
+
+	l := &Link{Logger: NewLogger("error")}
+	tests := []struct {
+		name string
+		uid  string
+		acc  string
+		want string
+	}{
+		{"restored", "042AAAAAA", "sherby", "Sherby"},
+		{"no such account", "042AAAAAB", "dropped", ""},
+		{"suspended", "042AAAAAC", "carol", ""},
+		{"already logged in", "042AAAAAD", "sherby", "bob"},
+		{"unknown client", "042AAAAAE", "sherby", ""},
+	}
+	for _, tt := range tests {
+		if tt.uid != "042AAAAAE" {
+			setNick(tt.uid, "nick"+tt.uid[7:])
+			defer delNick(tt.uid)
+		}
+		restoreSession(l, tt.uid, tt.acc)
+		if got := accDB.SessionAccount(tt.uid); got != tt.want {
+			t.Errorf("%s: session = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
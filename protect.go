@@ -0,0 +1,77 @@
+// protect.go
+package main
+
+import "strings"
+
+// Op enforcement on mode changes made by others. With the bitch flag only
+// users with op access keep ops; with the protect flag (or +p on the user)
+// users with op access who are deopped by someone ranked no higher are
+// re-opped. Q always re-ops itself.
+
+// opFix returns the change that undoes mc, if mc breaks the rules. flags are
+// chanlev flags; self says the source changed its own status.
+func opFix(mc modeChange, sourceFlags, targetFlags string, bitch, protect, isQ, self bool) (modeChange, bool) {
+	if mc.Mode != 'o' || mc.Param == "" {
+		return modeChange{}, false
+	}
+	if mc.Adding {
+		if isQ || !bitch {
+			return modeChange{}, false
+		}
+		if hasPriv(targetFlags, 'o') && !strings.Contains(targetFlags, "d") {
+			return modeChange{}, false
+		}
+		return modeChange{Adding: false, Mode: 'o', Param: mc.Param}, true
+	}
+	switch {
+	case isQ:
+	case self:
+		return modeChange{}, false
+	case !hasPriv(targetFlags, 'o') || strings.Contains(targetFlags, "d"):
+		return modeChange{}, false
+	case !protect && !strings.Contains(targetFlags, "p"):
+		return modeChange{}, false
+	case flagRank(sourceFlags) > flagRank(targetFlags):
+		return modeChange{}, false
+	}
+	return modeChange{Adding: true, Mode: 'o', Param: mc.Param}, true
+}
+
+// enforceOps reverts op changes on channel that bitch mode or protection
+// forbid. source is the uid that made them, "" for servers.
+func enforceOps(l *Link, channel, source string, changes []modeChange) {
+	cs := chans[toLower(channel)]
+	values, registered := acl.Settings(channel)
+	if cs == nil || !registered {
+		return
+	}
+	bitch := chanSettings.Bool(values, "bitch")
+	protect := chanSettings.Bool(values, "protect")
+	sourceFlags := acl.Flags(channel, accDB.SessionAccount(source))
+	var fixes []modeChange
+	for _, mc := range changes {
+		target := mc.Param
+		fix, ok := opFix(mc, sourceFlags, acl.Flags(channel, accDB.SessionAccount(target)),
+			bitch, protect, target == l.Cfg.ServiceUID, source != "" && source == target)
+		if ok {
+			fixes = append(fixes, fix)
+		}
+	}
+	if len(fixes) > 0 {
+		sendModes(l, channel, cs.TS, fixes)
+	}
+}
+
+// enforceBitch deops everyone on channel that bitch mode doesn't allow ops,
+// for when the flag is switched on.
+func enforceBitch(l *Link, channel string) {
+	cs := chans[toLower(channel)]
+	if cs == nil {
+		return
+	}
+	var changes []modeChange
+	for uid := range cs.Ops {
+		changes = append(changes, modeChange{Adding: true, Mode: 'o', Param: uid})
+	}
+	enforceOps(l, channel, "", changes)
+}
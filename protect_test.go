@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestOpFix(t *testing.T) {
+	op := func(uid string) modeChange { return modeChange{Adding: true, Mode: 'o', Param: uid} }
+	deop := func(uid string) modeChange { return modeChange{Adding: false, Mode: 'o', Param: uid} }
+	cases := []struct {
+		name                      string
+		mc                        modeChange
+		source, target            string
+		bitch, protect, isQ, self bool
+		want                      *modeChange
+	}{
+		{"bitch deops stranger", op("u1"), "ov", "", true, false, false, false, &modeChange{false, 'o', "u1"}},
+		{"bitch deops +d", op("u1"), "mn", "dov", true, false, false, false, &modeChange{false, 'o', "u1"}},
+		{"bitch keeps op", op("u1"), "", "ov", true, false, false, false, nil},
+		{"no bitch keeps stranger", op("u1"), "ov", "", false, false, false, false, nil},
+		{"protect re-ops", deop("u1"), "ov", "ov", false, true, false, false, &modeChange{true, 'o', "u1"}},
+		{"+p re-ops", deop("u1"), "", "opv", false, false, false, false, &modeChange{true, 'o', "u1"}},
+		{"higher rank may deop", deop("u1"), "mov", "ov", false, true, false, false, nil},
+		{"self deop stands", deop("u1"), "ov", "ov", false, true, false, true, nil},
+		{"no protect, deop stands", deop("u1"), "", "ov", false, false, false, false, nil},
+		{"stranger deop stands", deop("u1"), "", "", false, true, false, false, nil},
+		{"Q is always re-opped", deop("Q"), "mnotv", "", false, false, true, false, &modeChange{true, 'o', "Q"}},
+		{"voice ignored", modeChange{true, 'v', "u1"}, "", "", true, true, false, false, nil},
+	}
+	for _, c := range cases {
+		got, ok := opFix(c.mc, c.source, c.target, c.bitch, c.protect, c.isQ, c.self)
+		switch {
+		case c.want == nil && ok:
+			t.Errorf("%s: unexpected fix %+v", c.name, got)
+		case c.want != nil && (!ok || got != *c.want):
+			t.Errorf("%s: fix = %+v, %v; want %+v", c.name, got, ok, *c.want)
+		}
+	}
+}
@@ -57,6 +57,11 @@ func Insp4Handshake(l *Link) error {
 		return err
 	}
 
+	// Offer SASL through us (m_sasl's target must be our server name)
+	if err := l.SendRaw(":%s METADATA * saslmechlist :%s", l.Cfg.SID, saslMechs); err != nil {
+		return err
+	}
+
 	// 5) End *our* burst
 	if err := l.SendRaw(":%s ENDBURST", l.Cfg.SID); err != nil {
 		return err
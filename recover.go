@@ -0,0 +1,172 @@
+// recover.go
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Channel recovery for owners and masters: recover takes a channel back
+// after a takeover, deopall and devoiceall clear status in bulk. Mode
+// changes go out maxModes at a time.
+
+// recoverOpts selects what recover does beyond deopping and re-opping.
+type recoverOpts struct {
+	aclBansOnly bool // only lift bans that hit users with access
+	kick        bool // kick everyone without access
+}
+
+// planRecover works out recover's mode changes and kicks for cs. flagsOf
+// returns a member's chanlev flags; liftBan says whether a ban mask should
+// go; lockedOn whether the mode lock keeps a mode set. Of i, k and l only
+// those set on the channel are removed.
+func planRecover(cs *chanState, q string, flagsOf func(uid string) string, liftBan func(mask string) bool, lockedOn func(byte) bool, opts recoverOpts) ([]modeChange, []string) {
+	var deops, reops, kicks []string
+	for uid := range cs.Seen {
+		if uid == q {
+			continue
+		}
+		flags := flagsOf(uid)
+		mayOp := hasPriv(flags, 'o') && !strings.Contains(flags, "d")
+		if opts.kick && flags == "" {
+			kicks = append(kicks, uid)
+		}
+		switch {
+		case cs.Ops[uid] && !mayOp:
+			deops = append(deops, uid)
+		case !cs.Ops[uid] && mayOp:
+			reops = append(reops, uid)
+		}
+	}
+	sort.Strings(deops)
+	sort.Strings(reops)
+	sort.Strings(kicks)
+
+	var changes []modeChange
+	for _, uid := range deops {
+		changes = append(changes, modeChange{Adding: false, Mode: 'o', Param: uid})
+	}
+	set := map[byte]bool{'i': cs.Invite, 'k': cs.Key != "", 'l': cs.Limit > 0}
+	for _, m := range []byte{'i', 'k', 'l'} {
+		if !set[m] || lockedOn(m) {
+			continue
+		}
+		mc := modeChange{Adding: false, Mode: m}
+		if m == 'k' {
+			mc.Param = "*" // any key will do from a server
+		}
+		changes = append(changes, mc)
+	}
+	var masks []string
+	for mask := range cs.Bans {
+		if liftBan(mask) {
+			masks = append(masks, mask)
+		}
+	}
+	sort.Strings(masks)
+	for _, mask := range masks {
+		changes = append(changes, modeChange{Adding: false, Mode: 'b', Param: mask})
+	}
+	for _, uid := range reops {
+		changes = append(changes, modeChange{Adding: true, Mode: 'o', Param: uid})
+	}
+	return changes, kicks
+}
+
+// statusChanges removes mode from every member who has it except q.
+func statusChanges(members map[string]bool, mode byte, q string) []modeChange {
+	var uids []string
+	for uid := range members {
+		if uid != q {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Strings(uids)
+	changes := make([]modeChange, 0, len(uids))
+	for _, uid := range uids {
+		changes = append(changes, modeChange{Adding: false, Mode: mode, Param: uid})
+	}
+	return changes
+}
+
+// doRecover runs recover, deopall or devoiceall on channel for fromUID and
+// returns the lines to show. args are the words after the channel name.
+func doRecover(l *Link, fromUID, cmd, channel string, args []string) []string {
+	if _, ok := acl.Owner(channel); !ok {
+		return []string{channel + " is not registered."}
+	}
+	if !canControlChannel(fromUID, channel, 'm') {
+		return []string{"You need +m on " + channel + " to use " + cmd + "."}
+	}
+	cs := chans[toLower(channel)]
+	if cs == nil || cs.TS == 0 {
+		return []string{"I don't know the TS for " + channel + " yet; try again shortly."}
+	}
+	q := l.Cfg.ServiceUID
+	if !cs.Seen[q] {
+		l.ServiceJoinWithTS(channel, cs.TS, true)
+	}
+
+	switch cmd {
+	case "deopall":
+		changes := statusChanges(cs.Ops, 'o', q)
+		sendModes(l, channel, cs.TS, changes)
+		return []string{"Deopped " + strconv.Itoa(len(changes)) + " user(s) on " + channel + "."}
+
+	case "devoiceall":
+		changes := statusChanges(cs.Voices, 'v', q)
+		sendModes(l, channel, cs.TS, changes)
+		return []string{"Devoiced " + strconv.Itoa(len(changes)) + " user(s) on " + channel + "."}
+	}
+
+	var opts recoverOpts
+	for _, a := range args {
+		switch strings.ToLower(a) {
+		case "aclbans":
+			opts.aclBansOnly = true
+		case "allbans":
+			opts.aclBansOnly = false
+		case "kick":
+			opts.kick = true
+		default:
+			return []string{"Usage: recover [allbans|aclbans] [kick]"}
+		}
+	}
+	flagsOf := func(uid string) string { return acl.Flags(channel, accDB.SessionAccount(uid)) }
+	stored := map[string]bool{}
+	for _, b := range bans.List(channel) {
+		stored[toLower(b.Mask)] = true
+	}
+	liftBan := func(mask string) bool {
+		if stored[toLower(mask)] {
+			return false // Q's own bans stay
+		}
+		if !opts.aclBansOnly {
+			return true
+		}
+		b := ChanBan{Mask: mask}
+		for uid := range uidToNick {
+			if acc := accDB.SessionAccount(uid); acc != "" && acl.Flags(channel, acc) != "" &&
+				b.matches(userBanMasks(uid), acc) {
+				return true
+			}
+		}
+		return false
+	}
+	lockedOn := func(m byte) bool {
+		for _, mc := range channelMLock(channel) {
+			if mc.Mode == m && mc.Adding {
+				return true
+			}
+		}
+		return false
+	}
+	changes, kicks := planRecover(cs, q, flagsOf, liftBan, lockedOn, opts)
+	sendModes(l, channel, cs.TS, changes)
+	for _, uid := range kicks {
+		l.Kick(channel, uid, "Channel recovery by "+getNick(fromUID)+".")
+	}
+	l.Logger.Infof("recover %s by %s: %d mode change(s), %d kick(s)", channel, userMask(fromUID), len(changes), len(kicks))
+	return []string{"Recovered " + channel + ": " + strconv.Itoa(len(changes)) + " mode change(s), " + strconv.Itoa(len(kicks)) + " kick(s)."}
+}
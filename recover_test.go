@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanRecover(t *testing.T) {
+	cs := newChanState(1)
+	for uid, prefixes := range map[string]string{"Q": "o", "owner": "", "taker": "o", "friend": "o", "guest": "", "voiced": "v"} {
+		cs.join(uid, prefixes)
+	}
+	cs.Bans["*!*@owner.host"] = true
+	cs.Bans["*!*@stored.host"] = true
+	cs.Invite, cs.Key, cs.Limit = true, "secret", 5
+	flags := map[string]string{"owner": "mnotv", "friend": "ov", "voiced": "v"}
+	flagsOf := func(uid string) string { return flags[uid] }
+	liftBan := func(mask string) bool { return mask != "*!*@stored.host" }
+	lockedOn := func(m byte) bool { return m == 'l' }
+
+	changes, kicks := planRecover(cs, "Q", flagsOf, liftBan, lockedOn, recoverOpts{})
+	want := []modeChange{
+		{false, 'o', "taker"},
+		{false, 'i', ""},
+		{false, 'k', "*"},
+		{false, 'b', "*!*@owner.host"},
+		{true, 'o', "owner"},
+	}
+	if !reflect.DeepEqual(changes, want) || len(kicks) != 0 {
+		t.Errorf("planRecover = %+v, %v\nwant %+v", changes, kicks, want)
+	}
+
+	_, kicks = planRecover(cs, "Q", flagsOf, liftBan, lockedOn, recoverOpts{kick: true})
+	if !reflect.DeepEqual(kicks, []string{"guest", "taker"}) {
+		t.Errorf("kicks = %v, want [guest taker]", kicks)
+	}
+
+	// modes that aren't set aren't removed
+	cs.Invite, cs.Key = false, ""
+	changes, _ = planRecover(cs, "Q", flagsOf, liftBan, lockedOn, recoverOpts{})
+	for _, mc := range changes {
+		if mc.Mode == 'i' || mc.Mode == 'k' || mc.Mode == 'l' {
+			t.Errorf("planRecover removes unset mode %c", mc.Mode)
+		}
+	}
+}
+
+func TestStatusChanges(t *testing.T) {
+	got := statusChanges(map[string]bool{"b": true, "Q": true, "a": true}, 'o', "Q")
+	want := []modeChange{{false, 'o', "a"}, {false, 'o', "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("statusChanges = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,249 @@
+// reglimit.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RegLimits caps how fast accounts and channels can be registered. Zero
+// disables a limit.
+type RegLimits struct {
+	PerHost    int           // accounts one host may register per Window
+	Window     time.Duration // window for PerHost
+	Cooldown   time.Duration // between registrations from one host / account
+	MaxChans   int           // channels one account may own
+	MinAccount time.Duration // account age needed to register a channel
+}
+
+// RegLimiter remembers recent registrations so the limits survive restarts.
+// Exempt masks (globs on host or account) bypass every limit.
+type RegLimiter struct {
+	mu     sync.Mutex
+	path   string
+	limits RegLimits
+
+	Exempts  []string           `json:"exempts,omitempty"`
+	Hosts    map[string][]int64 `json:"hosts"`     // host -> account registration times
+	ChanRegs map[string]int64   `json:"chan_regs"` // lower(account) -> last regchan
+}
+
+var regLimit = NewRegLimiter("reglimits.json")
+
+func NewRegLimiter(path string) *RegLimiter {
+	return &RegLimiter{
+		path:     path,
+		Hosts:    make(map[string][]int64),
+		ChanRegs: make(map[string]int64),
+	}
+}
+
+// Configure sets the limits to enforce.
+func (r *RegLimiter) Configure(limits RegLimits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = limits
+}
+
+func (r *RegLimiter) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(b, r); err != nil {
+		return err
+	}
+	if r.Hosts == nil {
+		r.Hosts = make(map[string][]int64)
+	}
+	if r.ChanRegs == nil {
+		r.ChanRegs = make(map[string]int64)
+	}
+	return nil
+}
+
+func (r *RegLimiter) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return writeJSONAtomic(r.path, r)
+}
+
+// Exempt reports whether host or account matches an exemption mask.
+func (r *RegLimiter) Exempt(host, account string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.Exempts {
+		if (host != "" && globMatch(toLower(m), toLower(host))) ||
+			(account != "" && globMatch(toLower(m), toLower(account))) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddExempt adds a mask; it reports false if it was already there.
+func (r *RegLimiter) AddExempt(mask string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.Exempts {
+		if strings.EqualFold(m, mask) {
+			return false
+		}
+	}
+	r.Exempts = append(r.Exempts, mask)
+	sort.Strings(r.Exempts)
+	return true
+}
+
+// DelExempt removes a mask and reports whether it was there.
+func (r *RegLimiter) DelExempt(mask string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, m := range r.Exempts {
+		if strings.EqualFold(m, mask) {
+			r.Exempts = append(r.Exempts[:i:i], r.Exempts[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RegLimiter) ExemptList() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.Exempts...)
+}
+
+// recent drops registrations from host older than the window and returns
+// the rest; the caller holds r.mu.
+func (r *RegLimiter) recent(host string, now time.Time) []int64 {
+	keep := r.limits.Window
+	if r.limits.Cooldown > keep {
+		keep = r.limits.Cooldown
+	}
+	cutoff := now.Add(-keep).Unix()
+	var out []int64
+	for _, ts := range r.Hosts[host] {
+		if ts > cutoff {
+			out = append(out, ts)
+		}
+	}
+	if len(out) == 0 {
+		delete(r.Hosts, host)
+	} else {
+		r.Hosts[host] = out
+	}
+	return out
+}
+
+// CheckAccount returns an error naming the limit host would exceed by
+// registering another account now.
+func (r *RegLimiter) CheckAccount(host string, now time.Time) error {
+	if host == "" {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	regs := r.recent(host, now)
+	if n := len(regs); n > 0 && r.limits.Cooldown > 0 {
+		if wait := time.Unix(regs[n-1], 0).Add(r.limits.Cooldown).Sub(now); wait > 0 {
+			return fmt.Errorf("registration cooldown: try again in %s", fmtDuration(wait))
+		}
+	}
+	if r.limits.PerHost > 0 && r.limits.Window > 0 {
+		cutoff := now.Add(-r.limits.Window).Unix()
+		n := 0
+		for _, ts := range regs {
+			if ts > cutoff {
+				n++
+			}
+		}
+		if n >= r.limits.PerHost {
+			return fmt.Errorf("accounts per host: %d registered from your host in the last %s (limit %d)", n, r.limits.Window, r.limits.PerHost)
+		}
+	}
+	return nil
+}
+
+// NoteAccount records an account registration from host.
+func (r *RegLimiter) NoteAccount(host string, now time.Time) {
+	if host == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Hosts[host] = append(r.recent(host, now), now.Unix())
+}
+
+// CheckChannel returns an error naming the limit account would exceed by
+// registering another channel now. created is the account's registration
+// time and owned how many channels it already owns.
+func (r *RegLimiter) CheckChannel(account string, created int64, owned int, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limits.MinAccount > 0 {
+		if wait := time.Unix(created, 0).Add(r.limits.MinAccount).Sub(now); wait > 0 {
+			return fmt.Errorf("minimum account age: accounts must be %s old to register channels; wait %s", r.limits.MinAccount, fmtDuration(wait))
+		}
+	}
+	if r.limits.MaxChans > 0 && owned >= r.limits.MaxChans {
+		return fmt.Errorf("channels per account: you already own %d (limit %d)", owned, r.limits.MaxChans)
+	}
+	if last := r.ChanRegs[toLower(account)]; last > 0 && r.limits.Cooldown > 0 {
+		if wait := time.Unix(last, 0).Add(r.limits.Cooldown).Sub(now); wait > 0 {
+			return fmt.Errorf("registration cooldown: try again in %s", fmtDuration(wait))
+		}
+	}
+	return nil
+}
+
+// NoteChannel records a channel registration by account.
+func (r *RegLimiter) NoteChannel(account string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ChanRegs[toLower(account)] = now.Unix()
+}
+
+// handleRegExemptCommand serves regexempt add|del|list (IRCop only).
+func handleRegExemptCommand(l *Link, fromUID string, parts []string) {
+	if !opers[fromUID] {
+		l.NoticeFromService(fromUID, "IRCop only.")
+		return
+	}
+	sub, mask := strings.ToLower(getOr(parts, 1, "")), getOr(parts, 2, "")
+	switch {
+	case sub == "add" && mask != "":
+		if !regLimit.AddExempt(mask) {
+			l.NoticeFromService(fromUID, mask+" is already exempt.")
+			return
+		}
+		_ = regLimit.Save()
+		l.NoticeFromService(fromUID, mask+" is now exempt from registration limits.")
+	case sub == "del" && mask != "":
+		if !regLimit.DelExempt(mask) {
+			l.NoticeFromService(fromUID, mask+" is not exempt.")
+			return
+		}
+		_ = regLimit.Save()
+		l.NoticeFromService(fromUID, mask+" is no longer exempt.")
+	case sub == "list":
+		list := regLimit.ExemptList()
+		if len(list) == 0 {
+			l.NoticeFromService(fromUID, "No registration exemptions.")
+			return
+		}
+		l.NoticeFromService(fromUID, "Exempt from registration limits: "+strings.Join(list, " "))
+	default:
+		l.NoticeFromService(fromUID, "Usage: regexempt add|del <host or account mask> | regexempt list")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegLimiterAccounts(t *testing.T) {
+	r := NewRegLimiter(filepath.Join(t.TempDir(), "reglimits.json"))
+	r.Configure(RegLimits{PerHost: 2, Window: time.Hour, Cooldown: time.Minute})
+	now := time.Unix(1_000_000, 0)
+
+	if err := r.CheckAccount("1.2.3.4", now); err != nil {
+		t.Fatalf("first registration refused: %v", err)
+	}
+	r.NoteAccount("1.2.3.4", now)
+	if err := r.CheckAccount("1.2.3.4", now.Add(30*time.Second)); err == nil || !strings.Contains(err.Error(), "cooldown") {
+		t.Errorf("within cooldown: err = %v, want cooldown", err)
+	}
+	if err := r.CheckAccount("5.6.7.8", now.Add(30*time.Second)); err != nil {
+		t.Errorf("other host refused: %v", err)
+	}
+	r.NoteAccount("1.2.3.4", now.Add(2*time.Minute))
+	if err := r.CheckAccount("1.2.3.4", now.Add(5*time.Minute)); err == nil || !strings.Contains(err.Error(), "accounts per host") {
+		t.Errorf("over per-host limit: err = %v, want accounts per host", err)
+	}
+	if err := r.CheckAccount("1.2.3.4", now.Add(time.Hour+time.Minute)); err != nil {
+		t.Errorf("first registration left the window: %v", err)
+	}
+}
+
+func TestRegLimiterChannels(t *testing.T) {
+	r := NewRegLimiter(filepath.Join(t.TempDir(), "reglimits.json"))
+	r.Configure(RegLimits{Cooldown: time.Minute, MaxChans: 2, MinAccount: 24 * time.Hour})
+	now := time.Unix(1_000_000, 0)
+	old := now.Add(-48 * time.Hour).Unix()
+
+	cases := []struct {
+		name    string
+		created int64
+		owned   int
+		want    string
+	}{
+		{"new account", now.Add(-time.Hour).Unix(), 0, "minimum account age"},
+		{"at the cap", old, 2, "channels per account"},
+		{"fine", old, 1, ""},
+	}
+	for _, c := range cases {
+		err := r.CheckChannel("sherby", c.created, c.owned, now)
+		if (c.want == "" && err != nil) || (c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want))) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.want)
+		}
+	}
+	r.NoteChannel("Sherby", now)
+	if err := r.CheckChannel("sherby", old, 1, now.Add(time.Second)); err == nil || !strings.Contains(err.Error(), "cooldown") {
+		t.Errorf("within cooldown: err = %v", err)
+	}
+}
+
+func TestRegLimiterExempt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reglimits.json")
+	r := NewRegLimiter(path)
+	r.AddExempt("10.0.*")
+	r.AddExempt("Trusted")
+	if r.AddExempt("trusted") {
+		t.Error("duplicate exemption added")
+	}
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewRegLimiter(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Exempt("10.0.0.7", "") || !loaded.Exempt("", "TRUSTED") || loaded.Exempt("10.1.0.7", "sherby") {
+		t.Error("exemption masks matched wrongly")
+	}
+	if !loaded.DelExempt("10.0.*") || loaded.Exempt("10.0.0.7", "") {
+		t.Error("DelExempt did not remove the mask")
+	}
+}
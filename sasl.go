@@ -0,0 +1,209 @@
+// sasl.go
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"time"
+)
+
+// SASL PLAIN for InspIRCd v4 (m_sasl with its target set to our server).
+// The ircd relays the exchange as
+//
+//	:<sid> ENCAP <target> SASL <client uid> <agent> <type> [data...]
+//
+// and we answer with ENCAP <client's sid> SASL <Q uid> <client uid> ...
+// Types are S (start, with the mechanism), H (client host and IP),
+// C (base64 client data, "+" for empty) and D (done; F fail, S success,
+// A abort). The password goes through the same Verify and throttle as
+// login. Accounts with two-factor send "<password> <code>" as password.
+const (
+	saslMechs    = "PLAIN"
+	saslChunk    = 400 // client data arrives in chunks of this size
+	saslMaxData  = 4 * saslChunk
+	saslDeadline = 2 * time.Minute
+)
+
+type saslSession struct {
+	started time.Time
+	host    string
+	ip      string
+	data    []byte
+}
+
+// saslLogin is a successful exchange waiting for the client's UID.
+type saslLogin struct {
+	account string
+	at      time.Time
+}
+
+var (
+	saslSessions = make(map[string]*saslSession) // uid -> exchange in progress
+	saslLogins   = make(map[string]saslLogin)    // uid -> verified login
+)
+
+func saslSend(l *Link, uid, typ, data string) {
+	_ = l.SendRaw(":%s ENCAP %s SASL %s %s %s %s", l.Cfg.SID, uid[:3], l.Cfg.ServiceUID, uid, typ, data)
+}
+
+// onSASL handles one relayed SASL message; args are the words after SASL.
+func onSASL(l *Link, args []string) {
+	if len(args) < 3 || len(args[0]) < 3 {
+		return
+	}
+	uid, typ := args[0], args[2]
+	data := args[3:]
+	s := saslSessions[uid]
+
+	switch typ {
+	case "S":
+		purgeSASL()
+		if len(data) < 1 || !strings.EqualFold(data[0], "PLAIN") {
+			saslSend(l, uid, "M", saslMechs)
+			saslSend(l, uid, "D", "F")
+			delete(saslSessions, uid)
+			return
+		}
+		if s == nil {
+			s = &saslSession{}
+			saslSessions[uid] = s
+		}
+		s.started, s.data = time.Now(), nil
+		saslSend(l, uid, "C", "+")
+
+	case "H":
+		// sent ahead of S
+		if len(data) < 2 {
+			return
+		}
+		if s == nil {
+			s = &saslSession{started: time.Now()}
+			saslSessions[uid] = s
+		}
+		s.host, s.ip = data[0], data[1]
+
+	case "C":
+		if s == nil || len(data) < 1 {
+			return
+		}
+		if data[0] != "+" {
+			s.data = append(s.data, data[0]...)
+		}
+		if len(s.data) > saslMaxData {
+			delete(saslSessions, uid)
+			saslSend(l, uid, "D", "F")
+			return
+		}
+		if len(data[0]) == saslChunk {
+			return // more to come
+		}
+		delete(saslSessions, uid)
+		saslPlain(l, uid, s, func(acc string, ok bool) {
+			if !ok {
+				saslSend(l, uid, "D", "F")
+				return
+			}
+			saslLogins[uid] = saslLogin{account: acc, at: time.Now()}
+			l.SetAccountMetaOnly(uid, acc)
+			saslSend(l, uid, "D", "S")
+		})
+
+	case "D":
+		delete(saslSessions, uid)
+	}
+}
+
+// saslPlain checks a PLAIN response the way login does and calls done, on
+// the bus, with the account to log uid in to.
+func saslPlain(l *Link, uid string, s *saslSession, done func(acc string, ok bool)) {
+	raw, err := base64.StdEncoding.DecodeString(string(s.data))
+	if err != nil {
+		done("", false)
+		return
+	}
+	// authzid NUL authcid NUL password
+	f := bytes.Split(raw, []byte{0})
+	if len(f) != 3 || len(f[1]) == 0 {
+		done("", false)
+		return
+	}
+	acc, pass := string(f[1]), string(f[2])
+	if len(f[0]) > 0 && !strings.EqualFold(string(f[0]), acc) {
+		done("", false) // no logging in as someone else
+		return
+	}
+	host := s.ip
+	if host == "" || host == "0.0.0.0" {
+		host = s.host
+	}
+	if wait, locked := throttle.Check(uid, host, acc); locked || wait > 0 {
+		done("", false)
+		return
+	}
+	ev := AuthEvent{TS: time.Now().Unix(), UserHost: "*@" + s.host, IP: s.ip, Method: "sasl", UID: uid}
+	mask := "*!*@" + s.host
+	code := ""
+	if accDB.HasTOTP(acc) {
+		if i := strings.LastIndexByte(pass, ' '); i >= 0 {
+			pass, code = pass[:i], pass[i+1:]
+		}
+	}
+	started := verifyAsync(l, uid, acc, pass, func(ok bool) {
+		if !ok {
+			recordLoginFailure(l, uid, host, acc, mask, ev)
+			done("", false)
+			return
+		}
+		a, _ := accDB.Get(acc)
+		if a.Pending || suspend.IsAccSuspended(a.Name) {
+			done("", false)
+			return
+		}
+		if len(a.TOTPSecret) > 0 {
+			if code == "" {
+				done("", false) // two-factor is required, like login without a code
+				return
+			}
+			if !checkSecondFactor(acc, code) {
+				recordLoginFailure(l, uid, host, acc, mask, ev)
+				done("", false)
+				return
+			}
+		}
+		if _, full := sessionsFull(l, a, uid); full {
+			done("", false) // checked before the ircd hears of the login
+			return
+		}
+		throttle.Success(uid, acc)
+		done(a.Name, true)
+	})
+	if !started {
+		done("", false)
+	}
+}
+
+// finishSASLLogin completes a SASL login once the client's UID arrives.
+func finishSASLLogin(l *Link, uid string) {
+	sl, ok := saslLogins[uid]
+	if !ok {
+		return
+	}
+	delete(saslLogins, uid)
+	completeLogin(l, uid, sl.account, "sasl")
+}
+
+// purgeSASL drops exchanges and logins of clients that never registered.
+func purgeSASL() {
+	now := time.Now()
+	for uid, s := range saslSessions {
+		if now.Sub(s.started) > saslDeadline {
+			delete(saslSessions, uid)
+		}
+	}
+	for uid, sl := range saslLogins {
+		if now.Sub(sl.at) > saslDeadline {
+			delete(saslLogins, uid)
+		}
+	}
+}
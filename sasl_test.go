@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSASLPlain(t *testing.T) {
+	oldKey, oldDB, oldThrottle := totpKey, accDB, throttle
+	defer func() { totpKey, accDB, throttle = oldKey, oldDB, oldThrottle }()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "totp.key"), "key material")
+	if err := LoadTOTPKey(filepath.Join(dir, "totp.key")); err != nil {
+		t.Fatal(err)
+	}
+	accDB = NewAccountDB(filepath.Join(dir, "accounts.json"))
+	for _, name := range []string{"alice", "bob", "dave"} {
+		if err := accDB.Create(name, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secret, _ := newTOTPSecret()
+	sealed, _ := sealSecret(secret)
+	_, hashed, _ := newRecoveryCodes()
+	_ = accDB.SetTOTPPending("bob", sealed)
+	_ = accDB.ConfirmTOTP("bob", hashed)
+	_ = accDB.SetMaxSessions("dave", 1)
+	accDB.Bind("001AAAAZZ", "dave")
+	code := totpCode(secret, time.Now().Unix()/totpPeriod)
+
+	l := &Link{Logger: NewLogger("error")}
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"plain", "\x00alice\x00hunter2", "alice"},
+		{"authzid matches", "Alice\x00alice\x00hunter2", "alice"},
+		{"authzid differs", "bob\x00alice\x00hunter2", ""},
+		{"wrong password", "\x00alice\x00hunter3", ""},
+		{"no such account", "\x00carol\x00hunter2", ""},
+		{"missing field", "alice\x00hunter2", ""},
+		{"2fa without code", "\x00bob\x00hunter2", ""},
+		{"2fa with code", "\x00bob\x00hunter2 " + code, "bob"},
+		{"2fa wrong code", "\x00bob\x00hunter2 000000", ""},
+		{"session limit reached", "\x00dave\x00hunter2", ""},
+	}
+	for i, tt := range tests {
+		s := &saslSession{host: "example.net", ip: "192.0.2.1",
+			data: []byte(base64.StdEncoding.EncodeToString([]byte(tt.payload)))}
+		throttle = NewLoginThrottle(0, time.Minute)
+		var acc string
+		var ok bool
+		saslPlain(l, "001AAAA"+string(rune('A'+i)), s, func(a string, v bool) { acc, ok = a, v })
+		if ok != (tt.want != "") || acc != tt.want {
+			t.Errorf("%s: saslPlain = %q, %v; want %q", tt.name, acc, ok, tt.want)
+		}
+	}
+}
@@ -1,32 +1,154 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net/mail"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type chanState struct {
-	TS   int64
-	Seen map[string]bool // uid -> present
+	TS     int64
+	Seen   map[string]bool // uid -> present
+	Ops    map[string]bool // uid -> has +o
+	Voices map[string]bool // uid -> has +v
+	Bans   map[string]bool // +b masks
+
+	Topic  string // current topic, as far as we've seen
+	Limit  int    // current +l, 0 if unset
+	Key    string // current +k, "" if unset
+	Invite bool   // +i is set
+}
+
+func newChanState(ts int64) *chanState {
+	cs := &chanState{TS: ts, Seen: map[string]bool{}}
+	cs.resetModes()
+	return cs
+}
+
+// resetModes forgets member status, bans, limit, key and +i, as a TS reset
+// does.
+func (cs *chanState) resetModes() {
+	cs.Ops = map[string]bool{}
+	cs.Voices = map[string]bool{}
+	cs.Bans = map[string]bool{}
+	cs.Limit = 0
+	cs.Key = ""
+	cs.Invite = false
+}
+
+// fjoinState returns channel's state for an FJOIN carrying ts. fresh means
+// its modes start over: the channel is new to us, or a lower TS reset it.
+// A channel everyone had left (emptied) was recreated with a new TS and
+// no modes, so it counts as new as well.
+func fjoinState(channel string, ts int64) (cs *chanState, fresh, emptied bool) {
+	key := toLower(channel)
+	cs = chans[key]
+	emptied = cs != nil && len(cs.Seen) == 0
+	fresh = cs == nil || emptied || (ts > 0 && ts < cs.TS)
+	switch {
+	case cs == nil:
+		cs = newChanState(ts)
+		chans[key] = cs
+	case fresh:
+		if ts > 0 {
+			cs.TS = ts
+		}
+		cs.resetModes()
+	case cs.TS == 0:
+		cs.TS = ts
+	}
+	return cs, fresh, emptied
+}
+
+// join records uid on the channel with the given prefix modes ("ov").
+func (cs *chanState) join(uid, prefixes string) {
+	cs.Seen[uid] = true
+	if strings.IndexByte(prefixes, 'o') >= 0 {
+		cs.Ops[uid] = true
+	}
+	if strings.IndexByte(prefixes, 'v') >= 0 {
+		cs.Voices[uid] = true
+	}
+}
+
+// leave forgets uid on the channel.
+func (cs *chanState) leave(uid string) {
+	delete(cs.Seen, uid)
+	delete(cs.Ops, uid)
+	delete(cs.Voices, uid)
 }
 
 var (
 	qStore  = NewStore("state.json")
 	acl     = NewChanACLStore("chan_access.json")
 	suspend = NewSuspendStore("suspended.json")
+	forbids = NewForbidStore("forbids.json")
 
 	chans        = make(map[string]*chanState)
 	serviceChans = []string{"#feds", "#services", "#opers"}
 	opers        = make(map[string]bool) // uid -> oper
+
+	bursting  = make(map[string]bool) // sid -> netburst in progress
+	burstUIDs []string                // clients introduced during a burst, checked at ENDBURST
 )
 
+// inBurst reports whether a netburst is still being received.
+func inBurst() bool {
+	return len(bursting) > 0
+}
+
+// restoreSession rebinds uid to the account the ircd has it logged in to.
+// Sessions live in memory only, so after a restart the burst's accountname
+// METADATA is what tells us who is logged in.
+func restoreSession(l *Link, uid, acc string) {
+	if acc == "" || getNick(uid) == "" || accDB.SessionAccount(uid) != "" {
+		return
+	}
+	a, ok := accDB.Get(acc)
+	if !ok || a.Pending || suspend.IsAccSuspended(a.Name) {
+		l.ClearAccount(uid)
+		return
+	}
+	accDB.Bind(uid, a.Name)
+}
+
+// pacedStep is one step of a sequence run by runPaced.
+type pacedStep struct {
+	wait time.Duration // before the next step
+	fn   func()
+}
+
+// runPaced runs the first step now and posts each later one to the bus
+// after the wait before it, so a long sequence doesn't hold up the link.
+func runPaced(l *Link, steps []pacedStep) {
+	if len(steps) == 0 {
+		return
+	}
+	steps[0].fn()
+	if len(steps) > 1 {
+		time.AfterFunc(steps[0].wait, func() {
+			l.Post(func() { runPaced(l, steps[1:]) })
+		})
+	}
+}
+
 func registerServiceHandlers(l *Link) {
 	_ = qStore.Load()
 	_ = accDB.Load()
 	_ = acl.Load()
 	_ = suspend.Load()
+	if err := bans.Load(); err != nil {
+		l.Logger.Errorf("failed to load bans: %v", err)
+	}
+	if err := forbids.Load(); err != nil {
+		l.Logger.Errorf("failed to load forbids: %v", err)
+	}
+	bursting = make(map[string]bool)
+	burstUIDs = nil
+	verifying = make(map[string]bool)
 
 	// Track FJOIN / JOIN / PART / QUIT / OPERTYPE ...
 	l.Bus.On("FJOIN", func(l *Link, m *Message) {
@@ -35,71 +157,203 @@ func registerServiceHandlers(l *Link) {
 		}
 		ch := m.Params[0]
 		ts, _ := strconv.ParseInt(m.Params[1], 10, 64)
-		key := toLower(ch)
-		cs := chans[key]
-		if cs == nil {
-			cs = &chanState{TS: ts, Seen: map[string]bool{}}
-			chans[key] = cs
-		} else if cs.TS == 0 {
-			cs.TS = ts
+		cs, fresh, emptied := fjoinState(ch, ts)
+		if ts <= cs.TS {
+			// the losing side of a TS clash keeps none of its modes
+			applyChanModes(ch, m.Params[2], m.Params[3:])
 		}
+		var joined []string
 		for _, entry := range strings.Fields(m.Trailing) {
-			uid := entry
+			// <prefix modes>,<uid>:<membership id>
+			uid, prefixes := entry, ""
 			if i := strings.IndexByte(uid, ','); i >= 0 {
-				uid = uid[i+1:]
+				prefixes, uid = uid[:i], uid[i+1:]
 			}
 			if i := strings.IndexByte(uid, ':'); i >= 0 {
 				uid = uid[:i]
 			}
 			if uid != "" {
-				cs.Seen[uid] = true
+				cs.join(uid, prefixes)
+				joined = append(joined, uid)
+			}
+		}
+		if fresh {
+			applyMLock(l, ch)
+		}
+		if emptied {
+			restoreTopic(l, ch)
+		}
+		joined = enforceBans(l, ch, joined)
+		onJoinSettings(l, ch, joined)
+		giveAutoModes(l, ch, joined)
+		scheduleAutolimit(l, ch)
+	})
+	l.Bus.On("FMODE", func(l *Link, m *Message) {
+		// :<source> FMODE <chan> <ts> <modes> [params...]
+		if len(m.Params) >= 3 {
+			params := modeParams(m, 3)
+			applyChanModes(m.Params[0], m.Params[2], params)
+			changes := parseModes(m.Params[2], params)
+			enforceMLock(l, m.Params[0], changes)
+			enforceOps(l, m.Params[0], modeSource(m.Prefix), changes)
+		}
+	})
+	l.Bus.On("MODE", func(l *Link, m *Message) {
+		// :<source> MODE <target> <modes> [params...]
+		if len(m.Params) >= 2 && strings.HasPrefix(m.Params[0], "#") {
+			params := modeParams(m, 2)
+			applyChanModes(m.Params[0], m.Params[1], params)
+			changes := parseModes(m.Params[1], params)
+			enforceMLock(l, m.Params[0], changes)
+			enforceOps(l, m.Params[0], modeSource(m.Prefix), changes)
+		}
+	})
+	l.Bus.On("LMODE", func(_ *Link, m *Message) {
+		// :<sid> LMODE <chan> <chants> <mode> [<mask> <setter> <settime>]...
+		if len(m.Params) < 3 || m.Params[2] != "b" {
+			return
+		}
+		if cs := chans[toLower(m.Params[0])]; cs != nil {
+			entries := modeParams(m, 3)
+			for i := 0; i+2 < len(entries); i += 3 {
+				cs.Bans[entries[i]] = true
+			}
+		}
+	})
+	l.Bus.On("FTOPIC", func(l *Link, m *Message) {
+		// :<source> FTOPIC <chan> <chants> <topicts> [setter] :<topic>
+		if len(m.Params) >= 3 {
+			setter := getOr(m.Params, 3, getNick(m.Prefix))
+			uid := ""
+			if getNick(m.Prefix) != "" {
+				uid = m.Prefix
 			}
+			onTopic(l, m.Params[0], uid, setter, m.Trailing)
+		}
+	})
+	l.Bus.On("TOPIC", func(l *Link, m *Message) {
+		// :<uid> TOPIC <chan> :<topic>
+		if len(m.Params) >= 1 && m.Prefix != "" {
+			onTopic(l, m.Params[0], m.Prefix, getNick(m.Prefix), m.Trailing)
+		}
+	})
+	l.Bus.On("CAPAB", func(_ *Link, m *Message) {
+		if len(m.Params) < 1 {
+			return
+		}
+		switch strings.ToUpper(m.Params[0]) {
+		case "CHANMODES":
+			learnChanModes(m.Trailing)
+		case "CAPABILITIES":
+			learnCapabilities(m.Trailing)
 		}
 	})
 
 	// Keep nick map fresh
-	l.Bus.On("UID", func(_ *Link, m *Message) {
-		// UID <uid> <ts> <nick> ...
+	l.Bus.On("UID", func(l *Link, m *Message) {
+		// UID <uid> <ts> <nick> <real-host> <displayed-host> <real-user> <displayed-user> <ip> ...
 		if len(m.Params) >= 3 {
 			setNick(m.Params[0], m.Params[2])
 		}
+		if len(m.Params) >= 8 {
+			setUser(m.Params[0], userInfo{
+				User:  m.Params[5],
+				Host:  m.Params[3],
+				VHost: m.Params[4],
+				IP:    m.Params[7],
+			})
+		}
+		if len(m.Params) >= 3 {
+			finishSASLLogin(l, m.Params[0])
+			if inBurst() {
+				burstUIDs = append(burstUIDs, m.Params[0]) // its accountname may follow
+			} else {
+				checkNick(l, m.Params[0])
+			}
+		}
 	})
-	l.Bus.On("NICK", func(_ *Link, m *Message) {
+	l.Bus.On("NICK", func(l *Link, m *Message) {
 		// :<uid> NICK <newnick>
 		if len(m.Params) >= 1 && m.Prefix != "" {
 			setNick(m.Prefix, m.Params[0])
+			checkNick(l, m.Prefix)
 		}
 	})
 
-	l.Bus.On("JOIN", func(_ *Link, m *Message) {
+	onJoin := func(l *Link, m *Message) {
+		// :<uid> JOIN <chan>  |  :<uid> IJOIN <chan> <membid> [<ts> <modes>]
 		if len(m.Params) < 1 || m.Prefix == "" {
 			return
 		}
 		key := toLower(m.Params[0])
 		cs := chans[key]
 		if cs == nil {
-			cs = &chanState{Seen: map[string]bool{}}
+			cs = newChanState(0)
 			chans[key] = cs
 		}
-		cs.Seen[m.Prefix] = true
-	})
-	l.Bus.On("PART", func(_ *Link, m *Message) {
+		cs.join(m.Prefix, getOr(m.Params, 3, ""))
+		checkJoinFlood(l, m.Params[0])
+		kept := enforceBans(l, m.Params[0], []string{m.Prefix})
+		onJoinSettings(l, m.Params[0], kept)
+		giveAutoModes(l, m.Params[0], kept)
+		scheduleAutolimit(l, m.Params[0])
+	}
+	l.Bus.On("JOIN", onJoin)
+	l.Bus.On("IJOIN", onJoin)
+	l.Bus.On("PART", func(l *Link, m *Message) {
 		if len(m.Params) >= 1 && m.Prefix != "" {
 			if cs := chans[toLower(m.Params[0])]; cs != nil {
-				delete(cs.Seen, m.Prefix)
+				cs.leave(m.Prefix)
+			}
+			scheduleAutolimit(l, m.Params[0])
+		}
+	})
+	l.Bus.On("KICK", func(l *Link, m *Message) {
+		// :<source> KICK <chan> <uid> :<reason>
+		if len(m.Params) >= 2 {
+			if cs := chans[toLower(m.Params[0])]; cs != nil {
+				cs.leave(m.Params[1])
 			}
+			scheduleAutolimit(l, m.Params[0])
 		}
 	})
-	l.Bus.On("QUIT", func(_ *Link, m *Message) {
-		uid := m.Prefix
-		if uid == "" {
+	l.Bus.On("QUIT", func(l *Link, m *Message) {
+		left := userChannels(m.Prefix)
+		forgetUser(m.Prefix, "quit: "+m.Trailing)
+		for _, ch := range left {
+			scheduleAutolimit(l, ch)
+		}
+	})
+	l.Bus.On("KILL", func(l *Link, m *Message) {
+		// :<source> KILL <uid> :<reason>
+		if len(m.Params) >= 1 {
+			left := userChannels(m.Params[0])
+			forgetUser(m.Params[0], "kill: "+m.Trailing)
+			for _, ch := range left {
+				scheduleAutolimit(l, ch)
+			}
+		}
+	})
+	l.Bus.On("ENCAP", func(l *Link, m *Message) {
+		// :<sid> ENCAP <target> <command> [args...]
+		if len(m.Params) < 2 || !strings.EqualFold(m.Params[1], "SASL") {
 			return
 		}
-		for _, cs := range chans {
-			delete(cs.Seen, uid)
+		args := append([]string(nil), m.Params[2:]...)
+		if m.Trailing != "" {
+			args = append(args, m.Trailing)
+		}
+		onSASL(l, args)
+	})
+	l.Bus.On("METADATA", func(l *Link, m *Message) {
+		// :<sid> METADATA <uid> accountname :<account>
+		if len(m.Params) >= 2 && m.Params[1] == "accountname" {
+			restoreSession(l, m.Params[0], m.Trailing)
 		}
-		delNick(uid)
-		delete(opers, uid)
+	})
+	l.Bus.On("BURST", func(_ *Link, m *Message) {
+		// :<sid> BURST <ts>
+		bursting[m.Prefix] = true
 	})
 	l.Bus.On("OPERTYPE", func(_ *Link, m *Message) {
 		if m.Prefix != "" {
@@ -107,38 +361,57 @@ func registerServiceHandlers(l *Link) {
 		}
 	})
 
-	l.Bus.On("ENDBURST", func(l *Link, _ *Message) {
+	l.Bus.On("ENDBURST", func(l *Link, m *Message) {
+		delete(bursting, m.Prefix)
+		if !inBurst() {
+			for _, uid := range burstUIDs {
+				checkNick(l, uid)
+			}
+			burstUIDs = nil
+		}
+
 		l.SetServiceWhois(l.Cfg.ServiceUID, "is a Network Service")
 		l.SetAccountMetaOnly(l.Cfg.ServiceUID, l.Cfg.QNick) // shows “Logged in as Q” in WHOIS
 		// Hide channels in WHOIS to users who don’t share a channel (m_hidechans +I):
 		l.ServerMode(l.Cfg.ServiceUID, "+I")
 
-		// Join service channels
+		// Re-assert forbids the ircd may have lost
+		for _, f := range forbids.List("") {
+			pushForbid(l, f)
+		}
+
+		// Join service channels, then all registered channels not among
+		// them, paced so the ircd isn't flooded with joins.
+		var steps []pacedStep
 		joined := make(map[string]struct{})
 		for _, ch := range serviceChans {
-			chLower := toLower(ch)
-			var ts int64
-			if cs := chans[chLower]; cs != nil {
-				ts = cs.TS
-			}
-			l.ServiceJoinWithTS(ch, ts, true)
-			joined[chLower] = struct{}{}
-			time.Sleep(50 * time.Millisecond)
+			joined[toLower(ch)] = struct{}{}
+			steps = append(steps, pacedStep{50 * time.Millisecond, func() {
+				var ts int64
+				if cs := chans[toLower(ch)]; cs != nil {
+					ts = cs.TS
+				}
+				l.ServiceJoinWithTS(ch, ts, true)
+			}})
 		}
-
-		// Join all registered channels, except ones already joined
 		for _, ch := range acl.Channels() {
-			chLower := toLower(ch)
-			if _, dup := joined[chLower]; dup {
+			if _, dup := joined[toLower(ch)]; dup {
 				continue
 			}
-			var ts int64
-			if cs := chans[chLower]; cs != nil {
-				ts = cs.TS
-			}
-			l.ServiceJoinWithTS(ch, ts, true)
-			time.Sleep(30 * time.Millisecond)
+			steps = append(steps, pacedStep{30 * time.Millisecond, func() {
+				var ts int64
+				empty := true // Q's join creates it
+				if cs := chans[toLower(ch)]; cs != nil {
+					ts = cs.TS
+					empty = len(cs.Seen) == 0
+				}
+				l.ServiceJoinWithTS(ch, ts, true)
+				if empty {
+					restoreTopic(l, ch)
+				}
+			}})
 		}
+		runPaced(l, steps)
 	})
 
 	// Debug tap
@@ -158,7 +431,7 @@ func registerServiceHandlers(l *Link) {
 		if strings.EqualFold(text, "\x01VERSION\x01") {
 			from := m.Prefix
 			if from != "" {
-				l.NoticeFromService(from, "\x01VERSION qserv-v1.1.a\x01")
+				l.CTCPReply(from, "VERSION qserv-v1.1.a")
 			}
 		}
 	})
@@ -190,6 +463,34 @@ func registerServiceHandlers(l *Link) {
 
 // ----- Helpers -----
 
+// userChannels returns the (lowercased) channels uid is on.
+func userChannels(uid string) []string {
+	var out []string
+	for ch, cs := range chans {
+		if cs.Seen[uid] {
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// forgetUser drops everything we track for a client that left the network.
+func forgetUser(uid, why string) {
+	if uid == "" {
+		return
+	}
+	endSession(uid, why)
+	for _, cs := range chans {
+		cs.leave(uid)
+	}
+	nickEnf.Cancel(uid)
+	delNick(uid)
+	delete(opers, uid)
+	delete(verifying, uid)
+	throttle.ForgetUID(uid)
+	forgetChallenge(uid)
+}
+
 func enforceNotSuspendedPM(l *Link, uid string) bool {
 	acc := accDB.SessionAccount(uid)
 	if acc != "" && suspend.IsAccSuspended(acc) {
@@ -199,21 +500,61 @@ func enforceNotSuspendedPM(l *Link, uid string) bool {
 	return true
 }
 
-func canControlChannel(l *Link, uid, channel string, min int) (ok bool, level int, isOwner bool) {
+// canControlChannel reports whether uid's account holds chanlev privilege
+// priv on channel (see hasPriv).
+func canControlChannel(uid, channel string, priv byte) bool {
 	if suspend.IsChanSuspended(channel) {
-		return false, 0, false
+		return false
 	}
 	acc := accDB.SessionAccount(uid)
 	if acc == "" {
-		return false, 0, false
+		return false
+	}
+	return hasPriv(acl.Flags(channel, acc), priv)
+}
+
+// modePriv is the chanlev privilege needed for op/deop/voice/devoice.
+func modePriv(cmd string) byte {
+	if cmd == "voice" || cmd == "devoice" {
+		return 'v'
+	}
+	return 'o'
+}
+
+// adduserChange turns adduser's flags or legacy level argument into a
+// chanlev change.
+func adduserChange(arg string) (string, bool) {
+	if lvl, err := strconv.Atoi(arg); err == nil {
+		if lvl < 1 || lvl > 500 {
+			return "", false
+		}
+		return "+" + levelToFlags(lvl), true
+	}
+	if !strings.HasPrefix(arg, "+") {
+		arg = "+" + arg
+	}
+	return arg, true
+}
+
+// modeParams returns the mode parameters of m starting at Params[from],
+// including a trailing one.
+func modeParams(m *Message, from int) []string {
+	var params []string
+	if len(m.Params) > from {
+		params = append(params, m.Params[from:]...)
 	}
-	acc = strings.ToLower(acc) // normalize account
+	if m.Trailing != "" {
+		params = append(params, m.Trailing)
+	}
+	return params
+}
 
-	level = acl.GetLevel(channel, acc)
-	owner, hasOwner := acl.Owner(channel)
-	isOwner = hasOwner && strings.EqualFold(owner, acc) // case-insensitive
-	ok = isOwner || level >= min
-	return
+// modeSource returns the uid behind a message prefix, "" for servers.
+func modeSource(prefix string) string {
+	if getNick(prefix) == "" {
+		return ""
+	}
+	return prefix
 }
 
 // Resolve a nick to a UID that is actually in the channel.
@@ -260,7 +601,7 @@ func handleChannelControl(l *Link, fromUID, channel, cmdline string) {
 	switch cmd {
 
 	case "help":
-		l.ChanMsg(channel, "Commands: !op [nick], !deop [nick], !voice [nick], !devoice [nick], !access, !adduser <account|nick> <level>, !deluser <account|nick>, !join, !part. IRCops: !suspendchan <days> <reason>, !unsuspendchan, !purge. More: /msg Q help")
+		l.ChanMsg(channel, "Commands: !op [nick], !deop [nick], !voice [nick], !devoice [nick], !kick|!kickban <nick|mask> [reason], !chanlev [account|nick] [+flags-flags], !adduser <account|nick> <+flags>, !deluser <account|nick>, !ban <mask|nick> [reason], !tempban <mask|nick> <duration> [reason], !unban <mask|nick>, !banlist, !banclear, !mlock [modes|none], !chanflags [+flags-flags], !set [key [value]], !recover [allbans|aclbans] [kick], !deopall, !devoiceall, !settopic <text>, !cleartopic, !topiclock [on|off], !topicprefix|!topicsuffix [text|none], !join, !part. IRCops: !suspendchan <days> <reason>, !unsuspendchan, !purge. More: /msg Q help")
 
 	case "op", "deop", "voice", "devoice":
 		// must be logged in first
@@ -268,7 +609,7 @@ func handleChannelControl(l *Link, fromUID, channel, cmdline string) {
 			return
 		}
 		// access checks
-		if ok, _, _ := canControlChannel(l, fromUID, channel, 1); !ok {
+		if !canControlChannel(fromUID, channel, modePriv(cmd)) {
 			l.ChanMsg(channel, "You do not have access on "+channel+".")
 			return
 		}
@@ -283,6 +624,10 @@ func handleChannelControl(l *Link, fromUID, channel, cmdline string) {
 				return
 			}
 		}
+		if why := opRefusal(channel, cmd, targetUID); why != "" {
+			l.ChanMsg(channel, why)
+			return
+		}
 		switch cmd {
 		case "op":
 			l.QChanMode(channel, "+o", targetUID)
@@ -294,50 +639,78 @@ func handleChannelControl(l *Link, fromUID, channel, cmdline string) {
 			l.QChanMode(channel, "-v", targetUID)
 		}
 
-	case "adduser":
-		// adduser <account|nick> <level>
-		ok, _, isOwner := canControlChannel(l, fromUID, channel, 400)
-		if !ok && !isOwner {
-			l.ChanMsg(channel, "Only the owner can change access.")
+	case "mlock":
+		for _, line := range doMLock(l, fromUID, channel, parts[1:]) {
+			l.ChanMsg(channel, line)
+		}
+
+	case "kick", "kickban":
+		if _, ok := requireLoginForChannel(l, fromUID, channel); !ok {
 			return
 		}
-		if len(parts) < 3 {
-			l.ChanMsg(channel, "Usage: !adduser <account|nick> <level (1-500)>")
+		for _, line := range doKick(l, fromUID, cmd, channel, parts[1:]) {
+			l.ChanMsg(channel, line)
+		}
+
+	case "recover", "deopall", "devoiceall":
+		if _, ok := requireLoginForChannel(l, fromUID, channel); !ok {
 			return
 		}
-		target := parts[1]
-		lvl, _ := strconv.Atoi(parts[2])
-		if lvl < 1 || lvl > 500 {
-			l.ChanMsg(channel, "Level must be between 1 and 500.")
+		for _, line := range doRecover(l, fromUID, cmd, channel, parts[1:]) {
+			l.ChanMsg(channel, line)
+		}
+
+	case "chanflags", "set":
+		do := doChanFlags
+		if cmd == "set" {
+			do = doChanSet
+		}
+		for _, line := range do(l, fromUID, channel, parts[1:]) {
+			l.ChanMsg(channel, line)
+		}
+
+	case "settopic", "cleartopic", "topiclock", "topicprefix", "topicsuffix":
+		if _, ok := requireLoginForChannel(l, fromUID, channel); !ok {
 			return
 		}
-		tacct := resolveAccountFromToken(target)
-		if tacct == "" {
-			l.ChanMsg(channel, "Unable to resolve target to an account.")
+		for _, line := range doTopic(l, fromUID, cmd, channel, parts[1:]) {
+			l.ChanMsg(channel, line)
+		}
+
+	case "ban", "tempban", "unban", "banlist", "banclear":
+		if _, ok := requireLoginForChannel(l, fromUID, channel); !ok {
 			return
 		}
-		acl.SetLevel(channel, tacct, lvl)
-		_ = acl.Save()
-		l.ChanMsg(channel, "Set access: "+tacct+" = "+strconv.Itoa(lvl))
+		for _, line := range doBan(l, fromUID, cmd, channel, parts[1:]) {
+			l.ChanMsg(channel, line)
+		}
 
-	case "deluser":
-		ok, _, isOwner := canControlChannel(l, fromUID, channel, 400)
-		if !ok && !isOwner {
-			l.ChanMsg(channel, "Only the owner can delete access.")
+	case "chanlev":
+		// chanlev [account|nick] [+flags-flags]
+		for _, line := range doChanlev(l, fromUID, channel, parts[1:]) {
+			l.ChanMsg(channel, line)
+		}
+
+	case "adduser":
+		// adduser <account|nick> <+flags>   (a number maps like the old levels)
+		change, ok := adduserChange(getOr(parts, 2, ""))
+		if len(parts) < 3 || !ok {
+			l.ChanMsg(channel, "Usage: !adduser <account|nick> <+flags>")
 			return
 		}
+		for _, line := range doChanlev(l, fromUID, channel, []string{parts[1], change}) {
+			l.ChanMsg(channel, line)
+		}
+
+	case "deluser":
 		if len(parts) < 2 {
 			l.ChanMsg(channel, "Usage: !deluser <account|nick>")
 			return
 		}
-		tacct := resolveAccountFromToken(parts[1])
-		if tacct == "" {
-			l.ChanMsg(channel, "Unable to resolve target to an account.")
-			return
+		change := "-" + acl.Flags(channel, resolveAccountFromToken(parts[1]))
+		for _, line := range doChanlev(l, fromUID, channel, []string{parts[1], change}) {
+			l.ChanMsg(channel, line)
 		}
-		acl.DelUser(channel, tacct)
-		_ = acl.Save()
-		l.ChanMsg(channel, "Removed access for "+tacct)
 
 	case "access", "flags", "listaccess":
 		entries := acl.List(channel)
@@ -351,20 +724,20 @@ func handleChannelControl(l *Link, fromUID, channel, cmdline string) {
 			if i > 0 {
 				b.WriteString(" | ")
 			}
-			fmt.Fprintf(&b, "%s=%d", e.Account, e.Level)
+			fmt.Fprintf(&b, "%s +%s", e.Account, e.Flags)
 		}
 		l.ChanMsg(channel, b.String())
 
 	case "join":
-		if ok, _, _ := canControlChannel(l, fromUID, channel, 450); !ok {
-			l.ChanMsg(channel, "Need level 450+ to JOIN.")
+		if !canControlChannel(fromUID, channel, 'm') {
+			l.ChanMsg(channel, "Need +m (master) to JOIN.")
 			return
 		}
 		l.ServiceJoinWithTS(channel, cs.TS, true)
 
 	case "part":
-		if ok, _, _ := canControlChannel(l, fromUID, channel, 450); !ok {
-			l.ChanMsg(channel, "Need level 450+ to PART.")
+		if !canControlChannel(fromUID, channel, 'm') {
+			l.ChanMsg(channel, "Need +m (master) to PART.")
 			return
 		}
 		_ = l.SendRaw(":%s PART %s :Requested", l.Cfg.ServiceUID, channel)
@@ -437,12 +810,29 @@ func handlePM(l *Link, fromUID, cmdline string) {
 	case "help":
 		l.NoticeFromService(fromUID, "Q — Help")
 		l.NoticeFromService(fromUID, "General: ping | version")
-		l.NoticeFromService(fromUID, "Accounts: register <account> <password> | login <account> <password> | logout")
+		l.NoticeFromService(fromUID, "Accounts: register <account> <password> [email] | confirm <code> | login <account> <password> [2fa code] | logout")
+		l.NoticeFromService(fromUID, "Passwords: newpass <old> <new> | reset <account> <token> <newpass> | setemail <address>")
+		l.NoticeFromService(fromUID, "Scripts: challenge | challengeauth <account> <response> HMAC-SHA-256 [2fa code] | challenge enable|disable")
+		l.NoticeFromService(fromUID, "Sessions: sessions | logout <nick|all> | maxsessions <n> | authhistory")
+		l.NoticeFromService(fromUID, "Preferences: set | set <key> <value>")
+		l.NoticeFromService(fromUID, "Nicks: nick group | nick ungroup [nick] | nick list | ghost|recover|release <nick> [password]")
+		l.NoticeFromService(fromUID, "Lookup: info <account|nick> | whois <account|nick> | mychans")
+		l.NoticeFromService(fromUID, "Account removal: dropaccount <account> <password>")
+		l.NoticeFromService(fromUID, "Two-factor: totp enable | totp confirm <code> | totp disable <code>")
 		l.NoticeFromService(fromUID, "Channels: regchan <#channel> [owneraccount]")
-		l.NoticeFromService(fromUID, "Channel control: op|deop|voice|devoice <#channel> [nick]")
-		l.NoticeFromService(fromUID, "Access: access <#channel> | adduser <#channel> <account|nick> <level(1-500)> | deluser <#channel> <account|nick>")
+		l.NoticeFromService(fromUID, "Channel control: op|deop|voice|devoice <#channel> [nick] | kick|kickban <#channel> <nick|mask> [reason] | invite <#channel>")
+		l.NoticeFromService(fromUID, "Access: access <#channel> | chanlev <#channel> [account|nick] [+flags-flags] | adduser <#channel> <account|nick> <+flags> | deluser <#channel> <account|nick>")
+		l.NoticeFromService(fromUID, "Chanlev flags: "+flagLegend(chanlevFlags))
+		l.NoticeFromService(fromUID, "Bans: ban <#channel> <mask|nick|account:name> [reason] | tempban <#channel> <mask|nick> <duration> [reason] | unban <#channel> <mask|nick> | banlist <#channel> | banclear <#channel>")
+		l.NoticeFromService(fromUID, "Modes: mlock <#channel> [+modes-modes [params] | none]")
+		l.NoticeFromService(fromUID, "Channel settings: chanflags <#channel> [+flags-flags] | set <#channel> [key [value|default]]")
+		l.NoticeFromService(fromUID, "Topic: settopic <#channel> <text> | cleartopic <#channel> | topiclock <#channel> [on|off] | topicprefix|topicsuffix <#channel> [text|none]")
+		l.NoticeFromService(fromUID, "Recovery: recover <#channel> [allbans|aclbans] [kick] | deopall <#channel> | devoiceall <#channel>")
 		l.NoticeFromService(fromUID, "Presence: join <#channel> | part <#channel>")
-		l.NoticeFromService(fromUID, "IRCop: suspend <account|nick> <days> <reason> | unsuspend <account|nick>")
+		l.NoticeFromService(fromUID, "IRCop: suspend <account|nick> <days> <reason> | unsuspend <account|nick> | resetpass <account> | throttles [clear <key>] | totp reset <account>")
+		l.NoticeFromService(fromUID, "IRCop (accounts): dropaccount <account> | renameaccount <old> <new> | orphans | assignchan <#channel> <account> | authhistory <account>")
+		l.NoticeFromService(fromUID, "IRCop (names): forbid add <account|nick|chan> <pattern|/regex/> [+expiry] <reason> | forbid del <kind> <pattern> | forbid list [kind]")
+		l.NoticeFromService(fromUID, "IRCop (limits): regexempt add|del <host or account mask> | regexempt list")
 		l.NoticeFromService(fromUID, "IRCop (chan): suspendchan <#channel> <days> <reason> | unsuspendchan <#channel> | purge <#channel>")
 	case "version":
 		l.NoticeFromService(fromUID, "qserv-v1.1.a")
@@ -452,121 +842,838 @@ func handlePM(l *Link, fromUID, cmdline string) {
 
 	// account register/login/logout
 	case "register":
-		if len(parts) < 3 {
-			l.NoticeFromService(fromUID, "Usage: register <account> <password>")
+		if len(parts) < 3 || (l.Cfg.EmailRequired && len(parts) < 4) {
+			if l.Cfg.EmailRequired {
+				l.NoticeFromService(fromUID, "Usage: register <account> <password> <email>")
+			} else {
+				l.NoticeFromService(fromUID, "Usage: register <account> <password> [email]")
+			}
+			return
+		}
+		acc, pass, email := parts[1], parts[2], getOr(parts, 3, "")
+		if f, ok := forbids.Match(forbidAccount, acc); ok {
+			l.NoticeFromService(fromUID, "Register failed: "+acc+" is forbidden: "+f.Reason)
+			return
+		}
+		host := userHostKey(fromUID)
+		limited := !opers[fromUID] && !regLimit.Exempt(host, "")
+		if limited {
+			if err := regLimit.CheckAccount(host, time.Now()); err != nil {
+				l.NoticeFromService(fromUID, "Register refused: "+err.Error()+".")
+				return
+			}
+		}
+		if email != "" && !validEmail(email) {
+			l.NoticeFromService(fromUID, "Register failed: invalid email address.")
 			return
 		}
-		acc, pass := parts[1], parts[2]
 		if err := accDB.Create(acc, pass); err != nil {
 			l.NoticeFromService(fromUID, "Register failed: "+err.Error())
 			return
 		}
+		if limited {
+			regLimit.NoteAccount(host, time.Now())
+			_ = regLimit.Save()
+		}
+		if email != "" && mailer.Enabled() {
+			code, err := accDB.RequestEmail(acc, email, true)
+			if err == nil {
+				err = mailer.Queue("register", email, MailData{Account: acc, Code: code})
+			}
+			if err != nil {
+				// nothing could confirm it, so don't leave it pending forever
+				_, _ = accDB.Delete(acc)
+				l.NoticeFromService(fromUID, "Register failed: could not send the confirmation mail: "+err.Error())
+				return
+			}
+			_ = accDB.Save()
+			l.NoticeFromService(fromUID, "Account registered. A confirmation code was mailed to "+email+"; complete with: confirm <code>")
+			return
+		}
+		if email != "" {
+			_ = accDB.SetEmail(acc, email)
+		}
 		_ = accDB.Save()
 		l.NoticeFromService(fromUID, "Account registered. You can now: login "+acc+" <password>")
 
-	case "login":
-		if len(parts) < 3 {
-			l.NoticeFromService(fromUID, "Usage: login <account> <password>")
+	case "confirm":
+		// confirm <code>
+		if len(parts) < 2 {
+			l.NoticeFromService(fromUID, "Usage: confirm <code>")
 			return
 		}
-		acc, pass := parts[1], parts[2]
-		if !accDB.Verify(acc, pass) {
-			l.NoticeFromService(fromUID, "Login failed.")
+		host := userHostKey(fromUID)
+		if wait, _ := throttle.Check(fromUID, host, ""); wait > 0 {
+			l.NoticeFromService(fromUID, "Too many wrong codes. Try again in "+fmtDuration(wait)+".")
 			return
 		}
-		accDB.Bind(fromUID, acc)
-		l.SetAccount(fromUID, acc)
-		l.SetVHost(fromUID, acc+".users.emechnet.org")
-		l.NoticeFromService(fromUID, "You are now logged in as "+acc+".")
-
-	case "logout":
-		accDB.Unbind(fromUID)
-		l.ClearAccount(fromUID)
-		l.NoticeFromService(fromUID, "You are now logged out.")
+		purgePending(l.Logger, time.Duration(l.Cfg.PendingExpireHours)*time.Hour)
+		acc, ok := accDB.Confirm(parts[1])
+		if !ok {
+			throttle.Fail(fromUID, host, "")
+			l.NoticeFromService(fromUID, "Unknown confirmation code.")
+			return
+		}
+		throttle.Success(fromUID, "")
+		_ = accDB.Save()
+		l.NoticeFromService(fromUID, "Email confirmed for "+acc+". You can now: login "+acc+" <password>")
 
-	// channel registration (rename: regchannel -> regchan)
-	case "regchan", "regchannel":
-		if len(parts) < 2 || !strings.HasPrefix(parts[1], "#") {
-			l.NoticeFromService(fromUID, "Usage: regchan <#channel> [owneraccount]")
+	case "setemail":
+		// setemail <address>
+		if len(parts) < 2 {
+			l.NoticeFromService(fromUID, "Usage: setemail <address>")
 			return
 		}
-		channel := parts[1]
-		var owner string
-		if len(parts) >= 3 && opers[fromUID] {
-			owner = parts[2]
-		} else {
-			if !accDB.IsAuthed(fromUID) {
-				l.NoticeFromService(fromUID, "Login required.")
-				return
-			}
-			owner = accDB.SessionAccount(fromUID)
+		acc := accDB.SessionAccount(fromUID)
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Login required.")
+			return
 		}
-		if owner == "" {
-			l.NoticeFromService(fromUID, "Could not determine owner account.")
+		email := parts[1]
+		if !validEmail(email) {
+			l.NoticeFromService(fromUID, "Invalid email address.")
 			return
 		}
-		if _, exists := qStore.GetChan(channel); exists {
-			l.NoticeFromService(fromUID, channel+" is already registered.")
+		if !mailer.Enabled() {
+			_ = accDB.SetEmail(acc, email)
+			_ = accDB.Save()
+			l.NoticeFromService(fromUID, "Email for "+acc+" set to "+email+".")
 			return
 		}
-		if _, created := qStore.PutChan(channel, owner); created {
-			acl.SetOwner(channel, owner)
-			_ = acl.Save()
-			_ = qStore.Save()
-			if cmd == "regchannel" {
-				l.NoticeFromService(fromUID, "Registered "+channel+" — owner: "+owner+" (note: command is now 'regchan')")
-			} else {
-				l.NoticeFromService(fromUID, "Registered "+channel+" — owner: "+owner)
-			}
+		code, err := accDB.RequestEmail(acc, email, false)
+		if err == nil {
+			err = mailer.Queue("setemail", email, MailData{Account: acc, Code: code})
+		}
+		_ = accDB.Save()
+		if err != nil {
+			l.NoticeFromService(fromUID, "Could not send confirmation mail: "+err.Error())
 			return
 		}
-		l.NoticeFromService(fromUID, "Could not register "+channel+".")
+		l.NoticeFromService(fromUID, "A confirmation code was mailed to "+email+"; complete with: confirm <code>")
 
-	// ---- Account suspension (IRCop only) ----
-	case "suspend":
-		// suspend <account|nick> <days> <reason...>    (account suspension)
-		if !opers[fromUID] {
-			l.NoticeFromService(fromUID, "IRCop only.")
+	case "login":
+		// login <account> <password> [2fa code]
+		if len(parts) < 3 {
+			l.NoticeFromService(fromUID, "Usage: login <account> <password> [2fa code]")
 			return
 		}
-		if len(parts) < 4 {
-			l.NoticeFromService(fromUID, "Usage: suspend <account|nick> <days> <reason>")
+		acc, pass := parts[1], parts[2]
+		host := userHostKey(fromUID)
+		if wait, locked := throttle.Check(fromUID, host, acc); locked {
+			l.NoticeFromService(fromUID, "Account "+acc+" is temporarily locked after too many failed logins. Try again in "+fmtDuration(wait)+".")
 			return
-		}
-		acc := resolveAccountFromToken(parts[1])
-		if acc == "" {
-			l.NoticeFromService(fromUID, "Unable to resolve target to an account.")
+		} else if wait > 0 {
+			l.NoticeFromService(fromUID, "Too many failed logins. Try again in "+fmtDuration(wait)+".")
 			return
 		}
-		days, _ := strconv.Atoi(parts[2])
-		if days < 1 {
-			days = 1
+		code := getOr(parts, 3, "")
+		started := verifyAsync(l, fromUID, acc, pass, func(ok bool) {
+			if !ok {
+				loginFailed(l, fromUID, host, acc, "password")
+				return
+			}
+			if accDB.IsPending(acc) {
+				l.NoticeFromService(fromUID, "Account "+acc+" is not confirmed yet. Use: confirm <code>")
+				return
+			}
+			if accDB.HasTOTP(acc) {
+				if code == "" {
+					l.NoticeFromService(fromUID, "Account "+acc+" uses two-factor authentication. Use: login <account> <password> <code>")
+					return
+				}
+				if !checkSecondFactor(acc, code) {
+					loginFailed(l, fromUID, host, acc, "totp")
+					return
+				}
+			}
+			throttle.Success(fromUID, acc)
+			completeLogin(l, fromUID, acc, "password")
+		})
+		if !started {
+			l.NoticeFromService(fromUID, verifyBusy)
 		}
-		reason := strings.TrimSpace(strings.Join(parts[3:], " "))
-		doSuspendAccount(acc, days, reason)
-		_ = suspend.Save()
-		l.NoticeFromService(fromUID, "Suspended account "+acc+" for "+strconv.Itoa(days)+" day(s): "+reason)
 
-	case "unsuspend":
-		// unsuspend <account|nick>
-		if !opers[fromUID] {
-			l.NoticeFromService(fromUID, "IRCop only.")
-			return
-		}
+	case "logout":
+		// logout | logout <nick|all>
+		acc := accDB.SessionAccount(fromUID)
 		if len(parts) < 2 {
-			l.NoticeFromService(fromUID, "Usage: unsuspend <account|nick>")
+			endSession(fromUID, "logout")
+			l.ClearAccount(fromUID)
+			l.NoticeFromService(fromUID, "You are now logged out.")
+			checkNick(l, fromUID)
 			return
 		}
-		acc := resolveAccountFromToken(parts[1])
 		if acc == "" {
-			l.NoticeFromService(fromUID, "Unable to resolve target to an account.")
+			l.NoticeFromService(fromUID, "Login required.")
 			return
 		}
-		doUnsuspendAccount(acc)
-		_ = suspend.Save()
-		l.NoticeFromService(fromUID, "Unsuspended account "+acc)
+		targets, ok := logoutTargets(fromUID, acc, parts[1])
+		if !ok {
+			l.NoticeFromService(fromUID, parts[1]+" is not logged in to your account.")
+			return
+		}
+		for _, uid := range targets {
+			endSession(uid, "logout by "+getNick(fromUID))
+			l.ClearAccount(uid)
+			if uid != fromUID {
+				l.NoticeFromService(uid, "You have been logged out of "+acc+" by "+getNick(fromUID)+".")
+			}
+			checkNick(l, uid)
+		}
+		l.NoticeFromService(fromUID, "Logged out "+strconv.Itoa(len(targets))+" session(s).")
 
-	// ---- Channel control via PM (explicit names) ----
+	case "sessions":
+		acc := accDB.SessionAccount(fromUID)
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Login required.")
+			return
+		}
+		uids := accDB.Sessions(acc)
+		for _, uid := range uids {
+			line := userMask(uid) + " since " + fmtTime(accDB.SessionSince(uid))
+			if uid == fromUID {
+				line += " (this session)"
+			}
+			l.NoticeFromService(fromUID, line)
+		}
+		a, _ := accDB.Get(acc)
+		limit := "unlimited"
+		if n := sessionLimit(l, a); n > 0 {
+			limit = strconv.Itoa(n)
+		}
+		l.NoticeFromService(fromUID, "End of sessions ("+strconv.Itoa(len(uids))+", limit "+limit+").")
+
+	case "authhistory":
+		// authhistory            (own account)
+		// authhistory <account>  (IRCop only)
+		acc := accDB.SessionAccount(fromUID)
+		if len(parts) >= 2 {
+			if !opers[fromUID] && !strings.EqualFold(parts[1], acc) {
+				l.NoticeFromService(fromUID, "IRCop only.")
+				return
+			}
+			acc = parts[1]
+		}
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Login required.")
+			return
+		}
+		a, ok := accDB.Get(acc)
+		if !ok {
+			l.NoticeFromService(fromUID, "No such account: "+acc)
+			return
+		}
+		if len(a.History) == 0 {
+			l.NoticeFromService(fromUID, "No login history for "+a.Name+".")
+			return
+		}
+		for i := len(a.History) - 1; i >= 0; i-- {
+			l.NoticeFromService(fromUID, fmtAuthEvent(a.History[i]))
+		}
+		l.NoticeFromService(fromUID, "End of history for "+a.Name+".")
+
+	case "set":
+		// set | set <key> <value|default> | set <#channel> [key [value|default]]
+		if strings.HasPrefix(getOr(parts, 1, ""), "#") {
+			for _, line := range doChanSet(l, fromUID, parts[1], parts[2:]) {
+				l.NoticeFromService(fromUID, line)
+			}
+			return
+		}
+		acc := accDB.SessionAccount(fromUID)
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Login required.")
+			return
+		}
+		if len(parts) < 2 {
+			a, _ := accDB.Get(acc)
+			for _, d := range accountSettings.Defs() {
+				l.NoticeFromService(fromUID, fmt.Sprintf("%-12s %-8s %s", d.Key, accountSettings.Get(a.Settings, d.Key), d.Help))
+			}
+			l.NoticeFromService(fromUID, "Change with: set <key> <value> (or 'default')")
+			return
+		}
+		if len(parts) < 3 {
+			l.NoticeFromService(fromUID, "Usage: set <key> <value|default>")
+			return
+		}
+		d, ok := accountSettings.Lookup(parts[1])
+		if !ok {
+			l.NoticeFromService(fromUID, "Unknown setting "+parts[1]+". Type 'set' for a list.")
+			return
+		}
+		value := ""
+		if !strings.EqualFold(parts[2], "default") {
+			v, err := accountSettings.Normalize(d.Key, parts[2])
+			if err != nil {
+				l.NoticeFromService(fromUID, err.Error())
+				return
+			}
+			value = v
+		}
+		_ = accDB.SetSetting(acc, d.Key, value)
+		_ = accDB.Save()
+		l.NoticeFromService(fromUID, d.Key+" is now "+accountSetting(acc, d.Key)+".")
+
+	case "regexempt":
+		// regexempt add|del <mask> | regexempt list    (IRCop only)
+		handleRegExemptCommand(l, fromUID, parts)
+
+	case "forbid":
+		// forbid add|del|list ...    (IRCop only)
+		handleForbidCommand(l, fromUID, parts)
+
+	case "nick":
+		// nick group | nick ungroup [nick] | nick list
+		handleNickCommand(l, fromUID, parts)
+
+	case "ghost", "recover", "release":
+		// ghost|recover|release <nick> [password]  |  recover <#channel> [...]
+		if cmd == "recover" && strings.HasPrefix(getOr(parts, 1, ""), "#") {
+			for _, line := range doRecover(l, fromUID, cmd, parts[1], parts[2:]) {
+				l.NoticeFromService(fromUID, line)
+			}
+			return
+		}
+		handleNickRecovery(l, fromUID, cmd, parts)
+
+	case "maxsessions":
+		// maxsessions <n>    (0 = network default)
+		acc := accDB.SessionAccount(fromUID)
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Login required.")
+			return
+		}
+		n, err := strconv.Atoi(getOr(parts, 1, ""))
+		if err != nil || n < 0 {
+			l.NoticeFromService(fromUID, "Usage: maxsessions <n> (0 = network default)")
+			return
+		}
+		_ = accDB.SetMaxSessions(acc, n)
+		_ = accDB.Save()
+		l.NoticeFromService(fromUID, "Maximum sessions for "+acc+" set to "+strconv.Itoa(n)+".")
+
+	case "newpass":
+		// newpass <old> <new>
+		if len(parts) < 3 {
+			l.NoticeFromService(fromUID, "Usage: newpass <oldpassword> <newpassword>")
+			return
+		}
+		acc := accDB.SessionAccount(fromUID)
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Login required.")
+			return
+		}
+		newPass := parts[2]
+		started := verifyAsync(l, fromUID, acc, parts[1], func(ok bool) {
+			if !ok {
+				l.NoticeFromService(fromUID, "Old password is incorrect.")
+				return
+			}
+			if err := accDB.SetPassword(acc, newPass); err != nil {
+				l.NoticeFromService(fromUID, "Password change failed: "+err.Error())
+				return
+			}
+			_ = accDB.Save()
+			invalidateSessions(l, acc)
+			l.NoticeFromService(fromUID, "Password changed. All sessions were logged out; please login again.")
+		})
+		if !started {
+			l.NoticeFromService(fromUID, verifyBusy)
+		}
+
+	case "resetpass":
+		// resetpass <account>    (IRCop only)
+		if !opers[fromUID] {
+			l.NoticeFromService(fromUID, "IRCop only.")
+			return
+		}
+		if len(parts) < 2 {
+			l.NoticeFromService(fromUID, "Usage: resetpass <account>")
+			return
+		}
+		acc := parts[1]
+		ttl := time.Duration(l.Cfg.ResetTokenMins) * time.Minute
+		tok, err := accDB.IssueResetToken(acc, ttl)
+		if err != nil {
+			l.NoticeFromService(fromUID, "Reset failed: "+err.Error())
+			return
+		}
+		_ = accDB.Save()
+		if a, _ := accDB.Get(acc); a.Email != "" && mailer.Enabled() {
+			err := mailer.Queue("resetpass", a.Email, MailData{
+				Account: a.Name,
+				Code:    tok,
+				Expires: time.Now().Add(ttl).UTC().Format(time.RFC1123),
+			})
+			if err == nil {
+				l.NoticeFromService(fromUID, "Reset token for "+acc+" was mailed to the account's address.")
+				return
+			}
+			l.NoticeFromService(fromUID, "Mailing the token failed ("+err.Error()+"); pass it on manually.")
+		}
+		l.NoticeFromService(fromUID, "Reset token for "+acc+" (valid "+strconv.Itoa(l.Cfg.ResetTokenMins)+" min, single use): "+tok)
+		l.NoticeFromService(fromUID, "The user can now: reset "+acc+" "+tok+" <newpassword>")
+
+	case "reset":
+		// reset <account> <token> <newpass>
+		if len(parts) < 4 {
+			l.NoticeFromService(fromUID, "Usage: reset <account> <token> <newpassword>")
+			return
+		}
+		acc, tok, pass := parts[1], parts[2], parts[3]
+		if !accDB.ConsumeResetToken(acc, tok) {
+			_ = accDB.Save()
+			l.NoticeFromService(fromUID, "Invalid or expired reset token.")
+			return
+		}
+		if err := accDB.SetPassword(acc, pass); err != nil {
+			l.NoticeFromService(fromUID, "Password reset failed: "+err.Error())
+			return
+		}
+		_ = accDB.Save()
+		invalidateSessions(l, acc)
+		l.NoticeFromService(fromUID, "Password for "+acc+" has been reset. You can now: login "+acc+" <password>")
+
+	case "challenge":
+		// challenge | challenge enable|disable
+		if sub := strings.ToLower(getOr(parts, 1, "")); sub == "enable" || sub == "disable" {
+			acc := accDB.SessionAccount(fromUID)
+			if acc == "" {
+				l.NoticeFromService(fromUID, "Login required.")
+				return
+			}
+			_ = accDB.SetChallengeAuth(acc, sub == "enable")
+			_ = accDB.Save()
+			if sub == "disable" {
+				l.NoticeFromService(fromUID, "CHALLENGEAUTH disabled for "+acc+"; its key was removed.")
+				return
+			}
+			l.NoticeFromService(fromUID, "CHALLENGEAUTH enabled for "+acc+".")
+			if a, _ := accDB.Get(acc); a.ChallengeKey == "" {
+				l.NoticeFromService(fromUID, "Log in with your password once (or use newpass) to start using it.")
+			}
+			return
+		}
+		nonce, err := newChallenge(fromUID)
+		if err != nil {
+			l.NoticeFromService(fromUID, "CHALLENGE failed: "+err.Error())
+			return
+		}
+		l.NoticeFromService(fromUID, "CHALLENGE "+nonce+" "+challengeAlgo)
+
+	case "challengeauth":
+		// challengeauth <account> <response> <algorithm> [2fa code]
+		if len(parts) < 4 {
+			l.NoticeFromService(fromUID, "Usage: challengeauth <account> <response> "+challengeAlgo+" [2fa code]")
+			return
+		}
+		acc, resp := parts[1], parts[2]
+		if !strings.EqualFold(parts[3], challengeAlgo) {
+			l.NoticeFromService(fromUID, "Unsupported algorithm. Supported: "+challengeAlgo)
+			return
+		}
+		nonce, ok := takeChallenge(fromUID)
+		if !ok {
+			l.NoticeFromService(fromUID, "No valid challenge; request one with: challenge")
+			return
+		}
+		host := userHostKey(fromUID)
+		if wait, locked := throttle.Check(fromUID, host, acc); locked {
+			l.NoticeFromService(fromUID, "Account "+acc+" is temporarily locked after too many failed logins. Try again in "+fmtDuration(wait)+".")
+			return
+		} else if wait > 0 {
+			l.NoticeFromService(fromUID, "Too many failed logins. Try again in "+fmtDuration(wait)+".")
+			return
+		}
+		a, exists := accDB.Get(acc)
+		if !exists || a.ChallengeKey == "" || !challengeResponseOK(a.Name, a.ChallengeKey, nonce, resp) {
+			loginFailed(l, fromUID, host, acc, "challenge")
+			return
+		}
+		if a.Pending {
+			l.NoticeFromService(fromUID, "Account "+acc+" is not confirmed yet. Use: confirm <code>")
+			return
+		}
+		if len(a.TOTPSecret) > 0 {
+			code := getOr(parts, 4, "")
+			if code == "" {
+				l.NoticeFromService(fromUID, "Account "+acc+" uses two-factor authentication; append your code to challengeauth.")
+				return
+			}
+			if !checkSecondFactor(acc, code) {
+				loginFailed(l, fromUID, host, acc, "challenge")
+				return
+			}
+		}
+		throttle.Success(fromUID, acc)
+		completeLogin(l, fromUID, acc, "challenge")
+
+	case "totp":
+		// totp enable | totp confirm <code> | totp disable <code> | totp reset <account> (IRCop)
+		if !totpEnabled() {
+			l.NoticeFromService(fromUID, "Two-factor authentication is not available on this network.")
+			return
+		}
+		sub := strings.ToLower(getOr(parts, 1, ""))
+		if sub == "reset" {
+			if !opers[fromUID] {
+				l.NoticeFromService(fromUID, "IRCop only.")
+				return
+			}
+			if len(parts) < 3 {
+				l.NoticeFromService(fromUID, "Usage: totp reset <account>")
+				return
+			}
+			if err := accDB.DisableTOTP(parts[2]); err != nil {
+				l.NoticeFromService(fromUID, "totp reset failed: "+err.Error())
+				return
+			}
+			_ = accDB.Save()
+			l.Logger.Infof("2FA on %s removed by oper %s", parts[2], userMask(fromUID))
+			l.NoticeFromService(fromUID, "Two-factor authentication removed from "+parts[2]+".")
+			return
+		}
+		acc := accDB.SessionAccount(fromUID)
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Login required.")
+			return
+		}
+		switch sub {
+		case "enable":
+			if accDB.HasTOTP(acc) {
+				l.NoticeFromService(fromUID, "Two-factor authentication is already enabled.")
+				return
+			}
+			secret, err := newTOTPSecret()
+			var sealed []byte
+			if err == nil {
+				sealed, err = sealSecret(secret)
+			}
+			if err == nil {
+				err = accDB.SetTOTPPending(acc, sealed)
+			}
+			if err != nil {
+				l.NoticeFromService(fromUID, "totp enable failed: "+err.Error())
+				return
+			}
+			_ = accDB.Save()
+			l.NoticeFromService(fromUID, "Add this to your authenticator app: "+otpauthURI(l.Cfg.TOTPIssuer, acc, secret))
+			l.NoticeFromService(fromUID, "(manual entry secret: "+base32Secret(secret)+")")
+			l.NoticeFromService(fromUID, "Then activate it with: totp confirm <code>")
+			l.NoticeFromService(fromUID, "Once active, log in with: login <account> <password> <code>. For SASL PLAIN, send \"<password> <code>\" as the password.")
+
+		case "confirm":
+			if len(parts) < 3 {
+				l.NoticeFromService(fromUID, "Usage: totp confirm <code>")
+				return
+			}
+			a, _ := accDB.Get(acc)
+			if len(a.TOTPPending) == 0 {
+				l.NoticeFromService(fromUID, "Nothing to confirm. Start with: totp enable")
+				return
+			}
+			secret, err := openSecret(a.TOTPPending)
+			if err != nil || totpMatch(secret, parts[2], time.Now()) < 0 {
+				l.NoticeFromService(fromUID, "Invalid code.")
+				return
+			}
+			plain, hashed, err := newRecoveryCodes()
+			if err == nil {
+				err = accDB.ConfirmTOTP(acc, hashed)
+			}
+			if err != nil {
+				l.NoticeFromService(fromUID, "totp confirm failed: "+err.Error())
+				return
+			}
+			_ = accDB.Save()
+			l.NoticeFromService(fromUID, "Two-factor authentication is now enabled. Log in with: login <account> <password> <code>")
+			l.NoticeFromService(fromUID, "Recovery codes (each works once in place of a code; store them safely): "+strings.Join(plain, " "))
+
+		case "disable":
+			if len(parts) < 3 {
+				l.NoticeFromService(fromUID, "Usage: totp disable <code|recovery code>")
+				return
+			}
+			if !accDB.HasTOTP(acc) {
+				l.NoticeFromService(fromUID, "Two-factor authentication is not enabled.")
+				return
+			}
+			if !checkSecondFactor(acc, parts[2]) {
+				l.NoticeFromService(fromUID, "Invalid code.")
+				return
+			}
+			_ = accDB.DisableTOTP(acc)
+			_ = accDB.Save()
+			l.NoticeFromService(fromUID, "Two-factor authentication disabled.")
+
+		default:
+			l.NoticeFromService(fromUID, "Usage: totp enable | totp confirm <code> | totp disable <code>")
+		}
+
+	case "throttles":
+		// throttles [clear <uid:|host:|acc:key>]    (IRCop only)
+		if !opers[fromUID] {
+			l.NoticeFromService(fromUID, "IRCop only.")
+			return
+		}
+		if len(parts) >= 3 && strings.EqualFold(parts[1], "clear") {
+			if throttle.Forget(parts[2]) {
+				l.NoticeFromService(fromUID, "Cleared throttle "+parts[2])
+			} else {
+				l.NoticeFromService(fromUID, "No throttle "+parts[2]+" (keys look like uid:<uid>, host:<ip>, acc:<account>)")
+			}
+			return
+		}
+		list := throttle.List()
+		if len(list) == 0 {
+			l.NoticeFromService(fromUID, "No active login throttles.")
+			return
+		}
+		for _, e := range list {
+			line := fmt.Sprintf("%s: %d failure(s)", e.Key, e.Count)
+			if e.Lock > 0 {
+				line += ", locked for " + fmtDuration(e.Lock)
+			} else if e.Wait > 0 {
+				line += ", next attempt in " + fmtDuration(e.Wait)
+			}
+			l.NoticeFromService(fromUID, line)
+		}
+		l.NoticeFromService(fromUID, "End of throttles ("+strconv.Itoa(len(list))+").")
+
+	case "info", "whois":
+		// info <account|nick>
+		if len(parts) < 2 {
+			l.NoticeFromService(fromUID, "Usage: "+cmd+" <account|nick>")
+			return
+		}
+		a, ok := accDB.Get(resolveAccountFromToken(parts[1]))
+		if !ok {
+			l.NoticeFromService(fromUID, "No such account or logged-in nick: "+parts[1])
+			return
+		}
+		showAccountInfo(l, fromUID, a)
+
+	case "mychans":
+		acc := accDB.SessionAccount(fromUID)
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Login required.")
+			return
+		}
+		list := acl.AccessFor(acc)
+		if len(list) == 0 {
+			l.NoticeFromService(fromUID, "You have no channel access.")
+			return
+		}
+		for _, ca := range list {
+			l.NoticeFromService(fromUID, fmtChanAccess(ca))
+		}
+		l.NoticeFromService(fromUID, "End of list ("+strconv.Itoa(len(list))+" channel(s)).")
+
+	case "dropaccount":
+		// dropaccount <account> <password>    |    dropaccount <account>  (IRCop)
+		if len(parts) < 2 || (!opers[fromUID] && len(parts) < 3) {
+			l.NoticeFromService(fromUID, "Usage: dropaccount <account> <password>")
+			return
+		}
+		acc := parts[1]
+		if !accDB.Exists(acc) {
+			l.NoticeFromService(fromUID, "No such account: "+acc)
+			return
+		}
+		drop := func() {
+			name, handed, orphaned, err := doDropAccount(l, acc)
+			if err != nil {
+				l.NoticeFromService(fromUID, "Drop failed: "+err.Error())
+				return
+			}
+			l.Logger.Infof("account %s dropped by %s", name, userMask(fromUID))
+			for _, h := range handed {
+				l.NoticeFromService(fromUID, "Ownership passed on: "+h)
+			}
+			if len(orphaned) > 0 {
+				l.NoticeFromService(fromUID, "Orphaned channels awaiting an oper: "+strings.Join(orphaned, " "))
+			}
+			l.NoticeFromService(fromUID, "Account "+name+" dropped.")
+		}
+		if opers[fromUID] {
+			drop()
+			return
+		}
+		if !strings.EqualFold(accDB.SessionAccount(fromUID), acc) {
+			l.NoticeFromService(fromUID, "You must be logged in as "+acc+" to drop it.")
+			return
+		}
+		started := verifyAsync(l, fromUID, acc, parts[2], func(ok bool) {
+			if !ok {
+				l.NoticeFromService(fromUID, "Password incorrect.")
+				return
+			}
+			drop()
+		})
+		if !started {
+			l.NoticeFromService(fromUID, verifyBusy)
+		}
+
+	case "renameaccount":
+		// renameaccount <old> <new>    (IRCop only)
+		if !opers[fromUID] {
+			l.NoticeFromService(fromUID, "IRCop only.")
+			return
+		}
+		if len(parts) < 3 {
+			l.NoticeFromService(fromUID, "Usage: renameaccount <old> <new>")
+			return
+		}
+		if err := doRenameAccount(l, parts[1], parts[2]); err != nil {
+			l.NoticeFromService(fromUID, "Rename failed: "+err.Error())
+			return
+		}
+		l.Logger.Infof("account %s renamed to %s by %s", parts[1], parts[2], userMask(fromUID))
+		l.NoticeFromService(fromUID, "Account "+parts[1]+" renamed to "+parts[2]+".")
+
+	case "orphans":
+		// orphans    (IRCop only)
+		if !opers[fromUID] {
+			l.NoticeFromService(fromUID, "IRCop only.")
+			return
+		}
+		list := qStore.Orphans()
+		if len(list) == 0 {
+			l.NoticeFromService(fromUID, "No orphaned channels.")
+			return
+		}
+		l.NoticeFromService(fromUID, "Orphaned channels: "+strings.Join(list, " "))
+		l.NoticeFromService(fromUID, "Use: assignchan <#channel> <account> | purge <#channel>")
+
+	case "assignchan":
+		// assignchan <#channel> <account>    (IRCop only)
+		if !opers[fromUID] {
+			l.NoticeFromService(fromUID, "IRCop only.")
+			return
+		}
+		if len(parts) < 3 || !strings.HasPrefix(parts[1], "#") {
+			l.NoticeFromService(fromUID, "Usage: assignchan <#channel> <account>")
+			return
+		}
+		a, ok := accDB.Get(parts[2])
+		if !ok {
+			l.NoticeFromService(fromUID, "No such account: "+parts[2])
+			return
+		}
+		acl.SetOwner(parts[1], a.Name)
+		qStore.SetOwner(parts[1], a.Name)
+		qStore.DelOrphan(parts[1])
+		_ = acl.Save()
+		_ = qStore.Save()
+		l.NoticeFromService(fromUID, parts[1]+" is now owned by "+a.Name+".")
+
+	// channel registration (rename: regchannel -> regchan)
+	case "regchan", "regchannel":
+		if len(parts) < 2 || !strings.HasPrefix(parts[1], "#") {
+			l.NoticeFromService(fromUID, "Usage: regchan <#channel> [owneraccount]")
+			return
+		}
+		channel := parts[1]
+		var owner string
+		if len(parts) >= 3 && opers[fromUID] {
+			owner = parts[2]
+		} else {
+			if !accDB.IsAuthed(fromUID) {
+				l.NoticeFromService(fromUID, "Login required.")
+				return
+			}
+			owner = accDB.SessionAccount(fromUID)
+		}
+		if owner == "" {
+			l.NoticeFromService(fromUID, "Could not determine owner account.")
+			return
+		}
+		if _, exists := qStore.GetChan(channel); exists {
+			l.NoticeFromService(fromUID, channel+" is already registered.")
+			return
+		}
+		if f, ok := forbids.Match(forbidChan, channel); ok {
+			l.NoticeFromService(fromUID, channel+" is forbidden: "+f.Reason)
+			return
+		}
+		if !opers[fromUID] {
+			if err := checkChanRequirements(l, fromUID, channel); err != nil {
+				l.NoticeFromService(fromUID, "Cannot register "+channel+": "+err.Error()+".")
+				return
+			}
+		}
+		limited := !opers[fromUID] && !regLimit.Exempt(userHostKey(fromUID), owner)
+		if limited {
+			a, _ := accDB.Get(owner)
+			if err := regLimit.CheckChannel(owner, a.CreatedTS, len(qStore.OwnedBy(owner)), time.Now()); err != nil {
+				l.NoticeFromService(fromUID, "Registration of "+channel+" refused: "+err.Error()+".")
+				return
+			}
+		}
+		if _, created := qStore.PutChan(channel, owner); created {
+			if limited {
+				regLimit.NoteChannel(owner, time.Now())
+				_ = regLimit.Save()
+			}
+			acl.SetOwner(channel, owner)
+			_ = acl.Save()
+			_ = qStore.Save()
+			settleRegisteredChannel(l, channel)
+			if cmd == "regchannel" {
+				l.NoticeFromService(fromUID, "Registered "+channel+" — owner: "+owner+" (note: command is now 'regchan')")
+			} else {
+				l.NoticeFromService(fromUID, "Registered "+channel+" — owner: "+owner)
+			}
+			return
+		}
+		l.NoticeFromService(fromUID, "Could not register "+channel+".")
+
+	// ---- Account suspension (IRCop only) ----
+	case "suspend":
+		// suspend <account|nick> <days> <reason...>    (account suspension)
+		if !opers[fromUID] {
+			l.NoticeFromService(fromUID, "IRCop only.")
+			return
+		}
+		if len(parts) < 4 {
+			l.NoticeFromService(fromUID, "Usage: suspend <account|nick> <days> <reason>")
+			return
+		}
+		acc := resolveAccountFromToken(parts[1])
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Unable to resolve target to an account.")
+			return
+		}
+		days, _ := strconv.Atoi(parts[2])
+		if days < 1 {
+			days = 1
+		}
+		reason := strings.TrimSpace(strings.Join(parts[3:], " "))
+		doSuspendAccount(acc, days, reason)
+		_ = suspend.Save()
+		l.NoticeFromService(fromUID, "Suspended account "+acc+" for "+strconv.Itoa(days)+" day(s): "+reason)
+
+	case "unsuspend":
+		// unsuspend <account|nick>
+		if !opers[fromUID] {
+			l.NoticeFromService(fromUID, "IRCop only.")
+			return
+		}
+		if len(parts) < 2 {
+			l.NoticeFromService(fromUID, "Usage: unsuspend <account|nick>")
+			return
+		}
+		acc := resolveAccountFromToken(parts[1])
+		if acc == "" {
+			l.NoticeFromService(fromUID, "Unable to resolve target to an account.")
+			return
+		}
+		doUnsuspendAccount(acc)
+		_ = suspend.Save()
+		l.NoticeFromService(fromUID, "Unsuspended account "+acc)
+
+	// ---- Channel control via PM (explicit names) ----
 	case "suspendchan":
 		// suspendchan <#channel> <days> <reason...>
 		if len(parts) < 4 || !strings.HasPrefix(parts[1], "#") {
@@ -599,7 +1706,10 @@ func handlePM(l *Link, fromUID, cmdline string) {
 		l.NoticeFromService(fromUID, "Unsuspended "+channel)
 
 	// PM *versions* of channel controls
-	case "op", "deop", "voice", "devoice", "adduser", "deluser", "access", "join", "part", "purge":
+	case "op", "deop", "voice", "devoice", "chanlev", "adduser", "deluser", "access", "join", "part", "purge",
+		"ban", "tempban", "unban", "banlist", "banclear", "mlock",
+		"settopic", "cleartopic", "topiclock", "topicprefix", "topicsuffix", "chanflags",
+		"deopall", "devoiceall", "kick", "kickban", "invite":
 		if len(parts) < 2 || !strings.HasPrefix(parts[1], "#") {
 			l.NoticeFromService(fromUID, "Usage: "+cmd+" <#channel> [...args]")
 			return
@@ -611,7 +1721,7 @@ func handlePM(l *Link, fromUID, cmdline string) {
 			if _, ok := requireLoginForChannel(l, fromUID, channel); !ok {
 				return
 			}
-			if ok, _, _ := canControlChannel(l, fromUID, channel, 1); !ok {
+			if !canControlChannel(fromUID, channel, modePriv(cmd)) {
 				l.NoticeFromService(fromUID, "No access on "+channel+".")
 				return
 			}
@@ -625,6 +1735,10 @@ func handlePM(l *Link, fromUID, cmdline string) {
 					return
 				}
 			}
+			if why := opRefusal(channel, cmd, targetUID); why != "" {
+				l.NoticeFromService(fromUID, why)
+				return
+			}
 			switch cmd {
 			case "op":
 				l.QChanMode(channel, "+o", targetUID)
@@ -647,50 +1761,73 @@ func handlePM(l *Link, fromUID, cmdline string) {
 				if i > 0 {
 					b.WriteString(" | ")
 				}
-				fmt.Fprintf(&b, "%s=%d", e.Account, e.Level)
+				fmt.Fprintf(&b, "%s +%s", e.Account, e.Flags)
 			}
 			l.NoticeFromService(fromUID, "Access for "+channel+": "+b.String())
 
-		case "adduser":
-			if len(parts) < 4 {
-				l.NoticeFromService(fromUID, "Usage: adduser <#channel> <account|nick> <level (1-500)>")
-				return
+		case "mlock":
+			for _, line := range doMLock(l, fromUID, channel, parts[2:]) {
+				l.NoticeFromService(fromUID, line)
 			}
-			if ok, _, isOwner := canControlChannel(l, fromUID, channel, 400); !ok && !isOwner {
-				l.NoticeFromService(fromUID, "Only the owner can change access.")
-				return
+
+		case "kick", "kickban":
+			for _, line := range doKick(l, fromUID, cmd, channel, parts[2:]) {
+				l.NoticeFromService(fromUID, line)
+			}
+
+		case "recover", "deopall", "devoiceall":
+			for _, line := range doRecover(l, fromUID, cmd, channel, parts[2:]) {
+				l.NoticeFromService(fromUID, line)
+			}
+
+		case "chanflags":
+			for _, line := range doChanFlags(l, fromUID, channel, parts[2:]) {
+				l.NoticeFromService(fromUID, line)
 			}
-			tacct := resolveAccountFromToken(parts[2])
-			lvl, _ := strconv.Atoi(parts[3])
-			if tacct == "" || lvl < 1 || lvl > 500 {
-				l.NoticeFromService(fromUID, "Bad target or level.")
+
+		case "invite":
+			for _, line := range doInvite(l, fromUID, channel) {
+				l.NoticeFromService(fromUID, line)
+			}
+
+		case "settopic", "cleartopic", "topiclock", "topicprefix", "topicsuffix":
+			for _, line := range doTopic(l, fromUID, cmd, channel, parts[2:]) {
+				l.NoticeFromService(fromUID, line)
+			}
+
+		case "ban", "tempban", "unban", "banlist", "banclear":
+			for _, line := range doBan(l, fromUID, cmd, channel, parts[2:]) {
+				l.NoticeFromService(fromUID, line)
+			}
+
+		case "chanlev":
+			for _, line := range doChanlev(l, fromUID, channel, parts[2:]) {
+				l.NoticeFromService(fromUID, line)
+			}
+
+		case "adduser":
+			change, ok := adduserChange(getOr(parts, 3, ""))
+			if len(parts) < 4 || !ok {
+				l.NoticeFromService(fromUID, "Usage: adduser <#channel> <account|nick> <+flags>")
 				return
 			}
-			acl.SetLevel(channel, tacct, lvl)
-			_ = acl.Save()
-			l.NoticeFromService(fromUID, "Set access on "+channel+": "+tacct+" = "+strconv.Itoa(lvl))
+			for _, line := range doChanlev(l, fromUID, channel, []string{parts[2], change}) {
+				l.NoticeFromService(fromUID, line)
+			}
 
 		case "deluser":
 			if len(parts) < 3 {
 				l.NoticeFromService(fromUID, "Usage: deluser <#channel> <account|nick>")
 				return
 			}
-			if ok, _, isOwner := canControlChannel(l, fromUID, channel, 400); !ok && !isOwner {
-				l.NoticeFromService(fromUID, "Only the owner can delete access.")
-				return
-			}
-			tacct := resolveAccountFromToken(parts[2])
-			if tacct == "" {
-				l.NoticeFromService(fromUID, "Bad target.")
-				return
+			change := "-" + acl.Flags(channel, resolveAccountFromToken(parts[2]))
+			for _, line := range doChanlev(l, fromUID, channel, []string{parts[2], change}) {
+				l.NoticeFromService(fromUID, line)
 			}
-			acl.DelUser(channel, tacct)
-			_ = acl.Save()
-			l.NoticeFromService(fromUID, "Removed access on "+channel+" for "+tacct)
 
 		case "join":
-			if ok, _, _ := canControlChannel(l, fromUID, channel, 450); !ok {
-				l.NoticeFromService(fromUID, "Need level 450+ to JOIN.")
+			if !canControlChannel(fromUID, channel, 'm') {
+				l.NoticeFromService(fromUID, "Need +m (master) to JOIN.")
 				return
 			}
 			if cs := chans[toLower(channel)]; cs != nil {
@@ -698,8 +1835,8 @@ func handlePM(l *Link, fromUID, cmdline string) {
 			}
 
 		case "part":
-			if ok, _, _ := canControlChannel(l, fromUID, channel, 450); !ok {
-				l.NoticeFromService(fromUID, "Need level 450+ to PART.")
+			if !canControlChannel(fromUID, channel, 'm') {
+				l.NoticeFromService(fromUID, "Need +m (master) to PART.")
 				return
 			}
 			_ = l.SendRaw(":%s PART %s :Requested", l.Cfg.ServiceUID, channel)
@@ -728,15 +1865,445 @@ func resolveAccountFromToken(tok string) string {
 	return tok
 }
 
+// verifying holds the clients whose password check is running.
+var verifying = make(map[string]bool)
+
+const verifyBusy = "Your last password check is still running; wait for its answer."
+
+// verifyAsync checks acc's password on its own goroutine, since a hash check
+// is slow enough to hold up every message behind it, and runs done with the
+// result back on the bus. A client gets one check at a time: while uid's
+// last one is running it returns false and done is never called. done is
+// also dropped if the client quits meanwhile. Without a bus (tests) the
+// check runs in place.
+func verifyAsync(l *Link, uid, acc, pass string, done func(ok bool)) bool {
+	if verifying[uid] {
+		return false
+	}
+	l.mu.Lock()
+	bus := l.Bus
+	l.mu.Unlock()
+	if bus == nil {
+		done(accDB.Verify(acc, pass))
+		return true
+	}
+	verifying[uid] = true
+	go func() {
+		ok := accDB.Verify(acc, pass)
+		bus.Post(func() {
+			if !verifying[uid] {
+				return // quit
+			}
+			delete(verifying, uid)
+			done(ok)
+		})
+	}()
+	return true
+}
+
+// loginFailed counts a failed login attempt and tells the client.
+func loginFailed(l *Link, uid, host, acc, method string) {
+	recordLoginFailure(l, uid, host, acc, userMask(uid), authEvent(uid, method, false))
+	l.NoticeFromService(uid, "Login failed.")
+}
+
+// recordLoginFailure counts a failed login against the throttle and in the
+// account's history; mask is shown to the owner on their next login. Names
+// that aren't accounts only count against the client and host, so they
+// leave no record of their own.
+func recordLoginFailure(l *Link, uid, host, acc, mask string, ev AuthEvent) {
+	if !accDB.Exists(acc) {
+		throttle.Fail(uid, host, "")
+		return
+	}
+	if throttle.Fail(uid, host, acc) {
+		l.Logger.Warnf("account %s locked after repeated failed logins (last from %s)", acc, mask)
+	}
+	accDB.NoteFailure(acc, mask)
+	accDB.AddAuthEvent(acc, ev, l.Cfg.AuthHistorySize)
+	_ = accDB.Save()
+}
+
+// completeLogin binds a verified account to the client and announces it.
+func completeLogin(l *Link, uid, acc, method string) {
+	a, ok := accDB.Get(acc)
+	if !ok {
+		return
+	}
+	acc = a.Name
+	if n, full := sessionsFull(l, a, uid); full {
+		if method == "sasl" {
+			l.ClearAccount(uid) // SASL already told the ircd
+		}
+		l.NoticeFromService(uid, fmt.Sprintf("Login refused: %s already has %d session(s), the maximum. See: sessions | logout <nick|all>", acc, n))
+		return
+	}
+	endSession(uid, "relogin as "+acc)
+	accDB.Bind(uid, acc)
+	accDB.TouchLogin(acc)
+	accDB.AddAuthEvent(acc, authEvent(uid, method, true), l.Cfg.AuthHistorySize)
+	_ = accDB.Save()
+	l.SetAccount(uid, acc)
+	if accountSettings.Bool(a.Settings, "vhost") {
+		l.SetVHost(uid, acc+".users.emechnet.org")
+	}
+	l.NoticeFromService(uid, "You are now logged in as "+acc+".")
+	checkNick(l, uid)
+	for ch, cs := range chans {
+		if cs.Seen[uid] {
+			giveAutoModes(l, ch, []string{uid})
+		}
+	}
+	if n, from := accDB.TakeFailures(acc); n > 0 {
+		_ = accDB.Save()
+		l.NoticeFromService(uid, fmt.Sprintf("Warning: %d failed login attempt(s) on your account since your last login, most recently from %s.", n, from))
+	}
+}
+
+// endSession unbinds uid and closes its entry in the account's history.
+func endSession(uid, how string) {
+	acc := accDB.SessionAccount(uid)
+	if acc == "" {
+		return
+	}
+	accDB.Unbind(uid)
+	accDB.EndAuthEvent(acc, uid, how)
+	_ = accDB.Save()
+}
+
+func authEvent(uid, method string, ok bool) AuthEvent {
+	u, _ := getUser(uid)
+	return AuthEvent{
+		TS:       time.Now().Unix(),
+		Nick:     getNick(uid),
+		UserHost: u.User + "@" + u.Host,
+		IP:       u.IP,
+		Method:   method,
+		OK:       ok,
+		UID:      uid,
+	}
+}
+
+// sessionLimit is the stricter of the network cap and the account's own limit
+// (0 = unlimited).
+func sessionLimit(l *Link, a Account) int {
+	limit := l.Cfg.MaxSessions
+	if a.MaxSessions > 0 && (limit == 0 || a.MaxSessions < limit) {
+		limit = a.MaxSessions
+	}
+	return limit
+}
+
+// logoutTargets returns the sessions of acc that "logout <nick|all>" by
+// fromUID ends: all of them but fromUID's, or the one of that nick.
+func logoutTargets(fromUID, acc, which string) ([]string, bool) {
+	if strings.EqualFold(which, "all") {
+		var targets []string
+		for _, uid := range accDB.Sessions(acc) {
+			if uid != fromUID {
+				targets = append(targets, uid)
+			}
+		}
+		return targets, true
+	}
+	uid := getUIDByNick(which)
+	if uid == "" || !strings.EqualFold(accDB.SessionAccount(uid), acc) {
+		return nil, false
+	}
+	return []string{uid}, true
+}
+
+// sessionsFull reports whether the account already has as many sessions
+// besides uid as it may, and how many it has.
+func sessionsFull(l *Link, a Account, uid string) (int, bool) {
+	limit := sessionLimit(l, a)
+	if limit <= 0 {
+		return 0, false
+	}
+	n := 0
+	for _, other := range accDB.Sessions(a.Name) {
+		if other != uid {
+			n++
+		}
+	}
+	return n, n >= limit
+}
+
+// userMask renders nick!user@host for a UID, as far as we know it.
+func userMask(uid string) string {
+	nick := getNick(uid)
+	if nick == "" {
+		nick = uid
+	}
+	u, ok := getUser(uid)
+	if !ok {
+		return nick
+	}
+	return nick + "!" + u.User + "@" + u.Host
+}
+
+// fmtDuration renders a duration rounded to whole seconds.
+func fmtDuration(d time.Duration) string {
+	if d < time.Second {
+		d = time.Second
+	}
+	return d.Round(time.Second).String()
+}
+
+func validEmail(s string) bool {
+	a, err := mail.ParseAddress(s)
+	return err == nil && a.Address == s
+}
+
+// invalidateSessions logs out every live session of an account, e.g. after a
+// password change.
+func invalidateSessions(l *Link, account string) {
+	for _, uid := range accDB.UnbindAccount(account) {
+		accDB.EndAuthEvent(account, uid, "password change")
+		l.ClearAccount(uid)
+		l.NoticeFromService(uid, "Your login as "+account+" has ended because the password was changed.")
+	}
+}
+
+// showAccountInfo sends info/whois output. Details beyond the name,
+// registration date and online status are only shown to the account itself
+// and to opers.
+func showAccountInfo(l *Link, toUID string, a Account) {
+	self := strings.EqualFold(accDB.SessionAccount(toUID), a.Name)
+	full := self || opers[toUID]
+	uids := accDB.Sessions(a.Name)
+
+	l.NoticeFromService(toUID, "Account: "+a.Name)
+	if full || !accountSettings.Bool(a.Settings, "hideinfo") {
+		l.NoticeFromService(toUID, "Registered: "+fmtTime(a.CreatedTS))
+	}
+	if sp, ok := suspend.AccSuspension(a.Name); ok {
+		if full {
+			l.NoticeFromService(toUID, "Suspended until "+fmtTime(sp.Until)+": "+sp.Reason)
+		} else {
+			l.NoticeFromService(toUID, "Status: suspended")
+		}
+	}
+	if !full {
+		if len(uids) > 0 && !accountSettings.Bool(a.Settings, "hideinfo") {
+			l.NoticeFromService(toUID, "Status: online")
+		}
+		l.NoticeFromService(toUID, "End of info.")
+		return
+	}
+
+	if a.LastLoginTS > 0 {
+		l.NoticeFromService(toUID, "Last login: "+fmtTime(a.LastLoginTS))
+	}
+	if a.Email != "" {
+		if self || !accountSettings.Bool(a.Settings, "emailprivacy") {
+			l.NoticeFromService(toUID, "Email: "+a.Email)
+		} else {
+			l.NoticeFromService(toUID, "Email: "+maskEmail(a.Email))
+		}
+	}
+	if len(a.Nicks) > 0 {
+		l.NoticeFromService(toUID, "Nicks: "+strings.Join(a.Nicks, ", "))
+	}
+	if len(uids) > 0 {
+		masks := make([]string, 0, len(uids))
+		for _, uid := range uids {
+			masks = append(masks, userMask(uid))
+		}
+		l.NoticeFromService(toUID, "Sessions: "+strings.Join(masks, ", "))
+	}
+	access := acl.AccessFor(a.Name)
+	if len(access) > 0 {
+		items := make([]string, 0, len(access))
+		for _, ca := range access {
+			items = append(items, fmtChanAccess(ca))
+		}
+		l.NoticeFromService(toUID, "Channels: "+strings.Join(items, ", "))
+	}
+	l.NoticeFromService(toUID, "End of info.")
+}
+
+// maskEmail keeps the first letter of the local part and the domain.
+func maskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 1 {
+		return "***"
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+func fmtAuthEvent(ev AuthEvent) string {
+	status := "FAILED"
+	if ev.OK {
+		status = "OK"
+	}
+	line := fmt.Sprintf("%s %s %s %s!%s", fmtTime(ev.TS), status, ev.Method, ev.Nick, ev.UserHost)
+	if ev.IP != "" {
+		line += " [" + ev.IP + "]"
+	}
+	switch {
+	case ev.EndedTS > 0:
+		line += " — ended " + fmtTime(ev.EndedTS) + " (" + ev.End + ")"
+	case ev.OK && accDB.SessionAccount(ev.UID) != "":
+		line += " — active"
+	}
+	return line
+}
+
+func fmtChanAccess(ca ChanAccess) string {
+	if ca.Owner {
+		return fmt.Sprintf("%s (+%s, owner)", ca.Channel, ca.Flags)
+	}
+	return fmt.Sprintf("%s (+%s)", ca.Channel, ca.Flags)
+}
+
+func fmtTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format("2006-01-02 15:04 MST")
+}
+
+// doDropAccount deletes an account and cascades: channel access, channel
+// ownership (to the best-ranked remaining master, or the orphan queue),
+// suspensions and live sessions.
+func doDropAccount(l *Link, account string) (name string, handed, orphaned []string, err error) {
+	name, err = accDB.Delete(account)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	succ := acl.DropAccount(name)
+	for _, ch := range qStore.OwnedBy(name) {
+		if _, ok := succ[toLower(ch)]; !ok {
+			succ[toLower(ch)] = ""
+		}
+	}
+	for ch, next := range succ {
+		qStore.SetOwner(ch, next)
+		if next == "" {
+			qStore.AddOrphan(ch)
+			orphaned = append(orphaned, ch)
+			continue
+		}
+		handed = append(handed, ch+" -> "+next)
+	}
+	suspend.PurgeAcc(name)
+	for _, uid := range accDB.UnbindAccount(name) {
+		l.ClearAccount(uid)
+		l.NoticeFromService(uid, "Account "+name+" was dropped; you have been logged out.")
+	}
+	_ = accDB.Save()
+	_ = acl.Save()
+	_ = qStore.Save()
+	_ = suspend.Save()
+	return name, handed, orphaned, nil
+}
+
+// doRenameAccount renames an account everywhere it is referenced and moves
+// live sessions to the new name.
+func doRenameAccount(l *Link, oldName, newName string) error {
+	uids, err := accDB.Rename(oldName, newName)
+	if err != nil {
+		return err
+	}
+	acl.RenameAccount(oldName, newName)
+	for _, ch := range qStore.OwnedBy(oldName) {
+		qStore.SetOwner(ch, newName)
+	}
+	suspend.RenameAcc(oldName, newName)
+	for _, uid := range uids {
+		l.SetAccount(uid, newName)
+		if accountSetting(newName, "vhost") == "on" {
+			l.SetVHost(uid, newName+".users.emechnet.org")
+		}
+		l.NoticeFromService(uid, "Your account has been renamed to "+newName+".")
+	}
+	_ = accDB.Save()
+	_ = acl.Save()
+	_ = qStore.Save()
+	_ = suspend.Save()
+	return nil
+}
+
+// checkChanRequirements checks the channel registration rules: the
+// requester's presence or op status, the user count and the channel's age.
+func checkChanRequirements(l *Link, uid, channel string) error {
+	cs := chans[toLower(channel)]
+	switch l.Cfg.ChanRegRequire {
+	case "op":
+		if cs == nil || !cs.Ops[uid] {
+			return errors.New("you must be opped in it")
+		}
+	case "present":
+		if cs == nil || !cs.Seen[uid] {
+			return errors.New("you must be in it")
+		}
+	}
+	if n := l.Cfg.ChanRegMinUsers; n > 0 {
+		users := 0
+		if cs != nil {
+			users = len(cs.Seen)
+			if cs.Seen[l.Cfg.ServiceUID] {
+				users--
+			}
+		}
+		if users < n {
+			return fmt.Errorf("it needs at least %d users (it has %d)", n, users)
+		}
+	}
+	if age := time.Duration(l.Cfg.ChanRegChanAgeMins) * time.Minute; age > 0 {
+		if cs == nil || cs.TS == 0 {
+			return errors.New("it must exist first")
+		}
+		if wait := time.Unix(cs.TS, 0).Add(age).Sub(time.Now()); wait > 0 {
+			return fmt.Errorf("it must exist for %s first; try again in %s", age, fmtDuration(wait))
+		}
+	}
+	return nil
+}
+
+// settleRegisteredChannel joins Q to a newly registered channel and sets the
+// registered and permanent modes where the ircd has them.
+func settleRegisteredChannel(l *Link, channel string) {
+	var ts int64
+	if cs := chans[toLower(channel)]; cs != nil {
+		ts = cs.TS
+	}
+	l.ServiceJoinWithTS(channel, ts, true)
+	if modes := regChanModes(); modes != "" {
+		l.FMode(chans[toLower(channel)].TS, channel, "+"+modes)
+	}
+}
+
+// regChanModes are the letters of the registered (m_services calls it
+// c_registered) and permanent channel modes, as far as the ircd has them.
+func regChanModes() string {
+	var modes string
+	if c := chanModeLetter("c_registered"); c != 0 {
+		modes += string(c)
+	} else if c := chanModeLetter("registered"); c != 0 {
+		modes += string(c)
+	}
+	if c := chanModeLetter("permanent"); c != 0 {
+		modes += string(c)
+	}
+	return modes
+}
+
 // Admin ops
 
 func doPurge(l *Link, channel string) {
+	if cs := chans[toLower(channel)]; cs != nil {
+		if modes := regChanModes(); modes != "" {
+			l.FMode(cs.TS, channel, "-"+modes)
+		}
+	}
 	_ = l.SendRaw(":%s PART %s :Purged", l.Cfg.ServiceUID, channel)
 	delete(acl.data, strings.ToLower(channel))
 	_ = acl.Save()
 	suspend.PurgeChan(channel)
 	_ = suspend.Save()
+	bans.Clear(channel)
+	_ = bans.Save()
 	delete(chans, toLower(channel))
+	qStore.DelOrphan(channel)
 	_ = qStore.Save()
 }
 
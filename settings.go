@@ -0,0 +1,143 @@
+// settings.go
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type settingKind int
+
+const (
+	settingBool settingKind = iota
+	settingEnum
+	settingString
+)
+
+// SettingDef describes one typed setting. Values are stored as strings in
+// canonical form ("on"/"off" for booleans).
+type SettingDef struct {
+	Key      string
+	Kind     settingKind
+	Default  string
+	Choices  []string           // settingEnum only
+	Validate func(string) error // settingString only (optional)
+	Flag     byte               // chanflags letter (channel booleans only)
+	Help     string
+}
+
+// SettingRegistry validates and resolves settings against their definitions.
+type SettingRegistry struct {
+	defs map[string]SettingDef
+}
+
+func NewSettingRegistry(defs ...SettingDef) *SettingRegistry {
+	r := &SettingRegistry{defs: make(map[string]SettingDef, len(defs))}
+	for _, d := range defs {
+		r.defs[d.Key] = d
+	}
+	return r
+}
+
+// Defs returns every definition, sorted by key.
+func (r *SettingRegistry) Defs() []SettingDef {
+	out := make([]SettingDef, 0, len(r.defs))
+	for _, d := range r.defs {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// ByFlag returns the definition with the given chanflags letter.
+func (r *SettingRegistry) ByFlag(flag byte) (SettingDef, bool) {
+	for _, d := range r.defs {
+		if d.Flag != 0 && d.Flag == flag {
+			return d, true
+		}
+	}
+	return SettingDef{}, false
+}
+
+func (r *SettingRegistry) Lookup(key string) (SettingDef, bool) {
+	d, ok := r.defs[strings.ToLower(key)]
+	return d, ok
+}
+
+// Normalize validates value for key and returns its canonical form.
+func (r *SettingRegistry) Normalize(key, value string) (string, error) {
+	d, ok := r.Lookup(key)
+	if !ok {
+		return "", fmt.Errorf("unknown setting %q", key)
+	}
+	switch d.Kind {
+	case settingBool:
+		switch strings.ToLower(value) {
+		case "on", "yes", "true", "1":
+			return "on", nil
+		case "off", "no", "false", "0":
+			return "off", nil
+		}
+		return "", fmt.Errorf("%s must be on or off", d.Key)
+	case settingEnum:
+		for _, c := range d.Choices {
+			if strings.EqualFold(c, value) {
+				return c, nil
+			}
+		}
+		return "", fmt.Errorf("%s must be one of: %s", d.Key, strings.Join(d.Choices, ", "))
+	default:
+		if d.Validate != nil {
+			if err := d.Validate(value); err != nil {
+				return "", err
+			}
+		}
+		return value, nil
+	}
+}
+
+// Get returns the stored value for key, or its default.
+func (r *SettingRegistry) Get(values map[string]string, key string) string {
+	if v, ok := values[key]; ok {
+		return v
+	}
+	return r.defs[key].Default
+}
+
+func (r *SettingRegistry) Bool(values map[string]string, key string) bool {
+	return r.Get(values, key) == "on"
+}
+
+var accountSettings = NewSettingRegistry(
+	SettingDef{Key: "msgtype", Kind: settingEnum, Default: "notice", Choices: []string{"notice", "privmsg"},
+		Help: "how Q talks to you"},
+	SettingDef{Key: "language", Kind: settingString, Default: "en", Validate: validLanguage,
+		Help: "preferred language (ISO 639-1 code)"},
+	SettingDef{Key: "vhost", Kind: settingBool, Default: "on",
+		Help: "apply <account>.users vhost on login"},
+	SettingDef{Key: "emailprivacy", Kind: settingBool, Default: "on",
+		Help: "mask your email address from opers in info"},
+	SettingDef{Key: "autoop", Kind: settingBool, Default: "on",
+		Help: "get op/voice automatically on join"},
+	SettingDef{Key: "hideinfo", Kind: settingBool, Default: "off",
+		Help: "hide registration date and online status from info"},
+)
+
+func validLanguage(v string) error {
+	if len(v) != 2 || strings.ToLower(v) != v || strings.Trim(v, "abcdefghijklmnopqrstuvwxyz") != "" {
+		return fmt.Errorf("language must be a two-letter code like en or de")
+	}
+	return nil
+}
+
+// accountSetting resolves a setting for an account (default if unknown).
+func accountSetting(account, key string) string {
+	a, _ := accDB.Get(account)
+	return accountSettings.Get(a.Settings, key)
+}
+
+// uidSetting resolves a setting for whoever is logged in on uid.
+func uidSetting(uid, key string) string {
+	return accountSetting(accDB.SessionAccount(uid), key)
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestSettingNormalize(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       string
+		ok         bool
+	}{
+		{"vhost", "YES", "on", true},
+		{"vhost", "0", "off", true},
+		{"vhost", "maybe", "", false},
+		{"msgtype", "PRIVMSG", "privmsg", true},
+		{"msgtype", "ctcp", "", false},
+		{"language", "de", "de", true},
+		{"language", "DE", "", false},
+		{"language", "deu", "", false},
+		{"Autoop", "off", "off", true},
+		{"nosuchkey", "on", "", false},
+	}
+	for _, tt := range tests {
+		got, err := accountSettings.Normalize(tt.key, tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("Normalize(%q, %q) = %q, %v; want %q, ok %v", tt.key, tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSettingGet(t *testing.T) {
+	values := map[string]string{"vhost": "off", "msgtype": "privmsg"}
+	tests := []struct {
+		key      string
+		want     string
+		wantBool bool
+	}{
+		{"vhost", "off", false},
+		{"msgtype", "privmsg", false},
+		{"autoop", "on", true},     // default
+		{"hideinfo", "off", false}, // default
+		{"language", "en", false},
+		{"nosuchkey", "", false},
+	}
+	for _, tt := range tests {
+		if got := accountSettings.Get(values, tt.key); got != tt.want {
+			t.Errorf("Get(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+		if got := accountSettings.Bool(values, tt.key); got != tt.wantBool {
+			t.Errorf("Bool(%q) = %v, want %v", tt.key, got, tt.wantBool)
+		}
+	}
+	if got := accountSettings.Get(nil, "vhost"); got != "on" {
+		t.Errorf("Get on no values = %q, want the default on", got)
+	}
+}
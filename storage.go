@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,6 +17,9 @@ type ChannelReg struct {
 
 type State struct {
 	Channels map[string]ChannelReg `json:"channels"`
+	// Orphans are registered channels whose owner account was dropped with
+	// no successor; they wait for an oper to assign or purge them.
+	Orphans map[string]int64 `json:"orphans,omitempty"` // lower(#chan) -> since
 }
 
 type Store struct {
@@ -28,6 +33,7 @@ func NewStore(file string) *Store {
 		file: file,
 		state: State{
 			Channels: make(map[string]ChannelReg),
+			Orphans:  make(map[string]int64),
 		},
 	}
 }
@@ -49,6 +55,9 @@ func (s *Store) Load() error {
 	if st.Channels == nil {
 		st.Channels = make(map[string]ChannelReg)
 	}
+	if st.Orphans == nil {
+		st.Orphans = make(map[string]int64)
+	}
 	s.state = st
 	return nil
 }
@@ -86,6 +95,59 @@ func (s *Store) PutChan(name, ownerUID string) (ChannelReg, bool) {
 	return cr, true
 }
 
+// OwnedBy lists the registered channels owned by account.
+func (s *Store) OwnedBy(account string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []string
+	for _, cr := range s.state.Channels {
+		if strings.EqualFold(cr.OwnerUID, account) {
+			out = append(out, cr.Name)
+		}
+	}
+	return out
+}
+
+// SetOwner changes the owner of a registered channel.
+func (s *Store) SetOwner(name, owner string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := toLower(name)
+	cr, ok := s.state.Channels[k]
+	if !ok {
+		return false
+	}
+	cr.OwnerUID = owner
+	s.state.Channels[k] = cr
+	return true
+}
+
+func (s *Store) AddOrphan(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Orphans[toLower(name)] = time.Now().Unix()
+}
+
+func (s *Store) DelOrphan(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.state.Orphans, toLower(name))
+}
+
+// Orphans returns orphaned channels, oldest first.
+func (s *Store) Orphans() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]string, 0, len(s.state.Orphans))
+	for ch := range s.state.Orphans {
+		out = append(out, ch)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return s.state.Orphans[out[i]] < s.state.Orphans[out[j]]
+	})
+	return out
+}
+
 func toLower(s string) string {
 	b := []byte(s)
 	for i := range b {
@@ -95,3 +157,27 @@ func toLower(s string) string {
 	}
 	return string(b)
 }
+
+// writeJSONAtomic writes v as indented JSON to a temporary file next to path
+// and renames it into place, so a crash mid-write never leaves a truncated
+// file. The file is only readable by us.
+func writeJSONAtomic(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp) // a leftover would keep its old mode
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
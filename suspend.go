@@ -63,6 +63,14 @@ func (s *SuspendStore) IsAccSuspended(acc string) bool {
 	return a.Until > time.Now().Unix()
 }
 
+// AccSuspension returns the active suspension of an account, if any.
+func (s *SuspendStore) AccSuspension(acc string) (Suspension, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	a, ok := s.Accs[strings.ToLower(acc)]
+	return a, ok && a.Until > time.Now().Unix()
+}
+
 func (s *SuspendStore) IsChanSuspended(ch string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -93,3 +101,18 @@ func (s *SuspendStore) PurgeChan(ch string) {
 	defer s.mu.Unlock()
 	delete(s.Chans, strings.ToLower(ch))
 }
+
+func (s *SuspendStore) PurgeAcc(acc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Accs, strings.ToLower(acc))
+}
+
+func (s *SuspendStore) RenameAcc(oldAcc, newAcc string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sp, ok := s.Accs[strings.ToLower(oldAcc)]; ok {
+		delete(s.Accs, strings.ToLower(oldAcc))
+		s.Accs[strings.ToLower(newAcc)] = sp
+	}
+}
@@ -0,0 +1,221 @@
+// throttle.go
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	throttleBaseDelay = 2 * time.Second
+	throttleMaxDelay  = 5 * time.Minute
+	throttleForget    = time.Hour // failures older than this are forgotten
+	throttlePurge     = 10 * time.Minute
+)
+
+type failRecord struct {
+	Count int
+	Last  time.Time
+	Until time.Time // account lockout end (accounts only)
+}
+
+// next is when another attempt is allowed: base * 2^(count-1), capped.
+func (r *failRecord) next() time.Time {
+	if r.Count == 0 {
+		return time.Time{}
+	}
+	d := throttleBaseDelay << uint(min(r.Count-1, 16))
+	if d > throttleMaxDelay {
+		d = throttleMaxDelay
+	}
+	return r.Last.Add(d)
+}
+
+// stale reports whether the record no longer holds anything back.
+func (r *failRecord) stale(now time.Time) bool {
+	return now.Sub(r.Last) > throttleForget && now.After(r.Until)
+}
+
+// LoginThrottle counts failed logins per UID, per host/IP and per account.
+// Each failure doubles the wait before the next attempt; enough failures on
+// one account lock it for a while.
+type LoginThrottle struct {
+	mu        sync.Mutex
+	threshold int
+	lockout   time.Duration
+	recs      map[string]*failRecord // "uid:", "host:", "acc:" + key
+}
+
+var throttle = NewLoginThrottle(10, 15*time.Minute)
+
+func NewLoginThrottle(threshold int, lockout time.Duration) *LoginThrottle {
+	return &LoginThrottle{
+		threshold: threshold,
+		lockout:   lockout,
+		recs:      make(map[string]*failRecord),
+	}
+}
+
+// Configure sets the account lockout policy.
+func (t *LoginThrottle) Configure(threshold int, lockout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.threshold = threshold
+	t.lockout = lockout
+}
+
+func throttleKeys(uid, host, acc string) []string {
+	keys := []string{"uid:" + uid}
+	if host != "" {
+		keys = append(keys, "host:"+host)
+	}
+	if acc != "" {
+		keys = append(keys, "acc:"+toLower(acc))
+	}
+	return keys
+}
+
+// Check returns how long the caller must wait before trying again, and
+// whether the account is locked out.
+func (t *LoginThrottle) Check(uid, host, acc string) (wait time.Duration, locked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for _, k := range throttleKeys(uid, host, acc) {
+		r := t.recs[k]
+		if r == nil {
+			continue
+		}
+		if r.stale(now) {
+			delete(t.recs, k)
+			continue
+		}
+		if now.Before(r.Until) {
+			return r.Until.Sub(now), true
+		}
+		if w := r.next().Sub(now); w > wait {
+			wait = w
+		}
+	}
+	return wait, false
+}
+
+// Fail records a failed attempt and reports whether it locked the account.
+// Callers pass an empty acc for accounts that don't exist, so made-up names
+// don't each get a record.
+func (t *LoginThrottle) Fail(uid, host, acc string) (lockedNow bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for _, k := range throttleKeys(uid, host, acc) {
+		r := t.recs[k]
+		if r == nil {
+			r = &failRecord{}
+			t.recs[k] = r
+		}
+		r.Count++
+		r.Last = now
+		if strings.HasPrefix(k, "acc:") && t.threshold > 0 && r.Count >= t.threshold {
+			r.Until = now.Add(t.lockout)
+			r.Count = 0
+			lockedNow = true
+		}
+	}
+	return lockedNow
+}
+
+// Success clears the UID and account counters. The host counter is kept so
+// one good login from a shared host doesn't wipe out someone else's failures.
+func (t *LoginThrottle) Success(uid, acc string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.recs, "uid:"+uid)
+	delete(t.recs, "acc:"+toLower(acc))
+}
+
+// Forget drops every throttle whose key matches (e.g. "host:1.2.3.4").
+func (t *LoginThrottle) Forget(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.recs[key]; !ok {
+		return false
+	}
+	delete(t.recs, key)
+	return true
+}
+
+// ForgetUID drops the per-UID counter when the client goes away.
+func (t *LoginThrottle) ForgetUID(uid string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.recs, "uid:"+uid)
+}
+
+// Purge drops stale records and returns how many it dropped.
+func (t *LoginThrottle) Purge() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	n := 0
+	for k, r := range t.recs {
+		if r.stale(now) {
+			delete(t.recs, k)
+			n++
+		}
+	}
+	return n
+}
+
+// runThrottlePurge purges stale records for the life of the process, so
+// hosts that never come back don't pile up.
+func runThrottlePurge(ctx context.Context) {
+	tk := time.NewTicker(throttlePurge)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			throttle.Purge()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type ThrottleEntry struct {
+	Key   string
+	Count int
+	Wait  time.Duration
+	Lock  time.Duration
+}
+
+// List returns the currently active throttles, longest wait first.
+func (t *LoginThrottle) List() []ThrottleEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	var out []ThrottleEntry
+	for k, r := range t.recs {
+		e := ThrottleEntry{Key: k, Count: r.Count}
+		if w := r.next().Sub(now); w > 0 {
+			e.Wait = w
+		}
+		if now.Before(r.Until) {
+			e.Lock = r.Until.Sub(now)
+		}
+		if e.Wait == 0 && e.Lock == 0 && r.stale(now) {
+			delete(t.recs, k)
+			continue
+		}
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Lock+out[i].Wait == out[j].Lock+out[j].Wait {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Lock+out[i].Wait > out[j].Lock+out[j].Wait
+	})
+	return out
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFailRecordBackoff(t *testing.T) {
+	last := time.Unix(1000, 0)
+	tests := []struct {
+		count int
+		want  time.Duration
+	}{
+		{0, 0},
+		{1, throttleBaseDelay},
+		{2, 2 * throttleBaseDelay},
+		{3, 4 * throttleBaseDelay},
+		{5, 16 * throttleBaseDelay},
+		{8, 128 * throttleBaseDelay},
+		{9, throttleMaxDelay},
+		{100, throttleMaxDelay},
+	}
+	for _, tt := range tests {
+		r := failRecord{Count: tt.count, Last: last}
+		got := r.next()
+		if tt.count == 0 {
+			if !got.IsZero() {
+				t.Errorf("count 0: next = %v, want zero", got)
+			}
+			continue
+		}
+		if d := got.Sub(last); d != tt.want {
+			t.Errorf("count %d: delay = %s, want %s", tt.count, d, tt.want)
+		}
+	}
+}
+
+func TestThrottleCheckAndSuccess(t *testing.T) {
+	th := NewLoginThrottle(3, time.Minute)
+	if wait, locked := th.Check("u1", "1.2.3.4", "alice"); wait != 0 || locked {
+		t.Fatalf("fresh throttle: wait %s, locked %v", wait, locked)
+	}
+	th.Fail("u1", "1.2.3.4", "alice")
+	tests := []struct {
+		name      string
+		uid, host string
+		acc       string
+		throttled bool
+	}{
+		{"same client", "u1", "1.2.3.4", "alice", true},
+		{"other client, same host", "u2", "1.2.3.4", "bob", true},
+		{"other host, same account", "u2", "5.6.7.8", "ALICE", true},
+		{"unrelated", "u2", "5.6.7.8", "bob", false},
+	}
+	for _, tt := range tests {
+		wait, _ := th.Check(tt.uid, tt.host, tt.acc)
+		if (wait > 0) != tt.throttled {
+			t.Errorf("%s: wait = %s, throttled want %v", tt.name, wait, tt.throttled)
+		}
+	}
+
+	// success clears the client and account, but not the shared host
+	th.Success("u1", "alice")
+	if wait, _ := th.Check("u1", "", "alice"); wait != 0 {
+		t.Errorf("after success: wait = %s", wait)
+	}
+	if wait, _ := th.Check("u3", "1.2.3.4", ""); wait == 0 {
+		t.Error("after success: host counter was cleared")
+	}
+}
+
+func TestThrottleLockout(t *testing.T) {
+	th := NewLoginThrottle(3, time.Minute)
+	for i := 1; i <= 3; i++ {
+		locked := th.Fail("u"+strconv.Itoa(i), "", "alice")
+		if locked != (i == 3) {
+			t.Errorf("failure %d: lockedNow = %v", i, locked)
+		}
+	}
+	wait, locked := th.Check("u9", "", "alice")
+	if !locked || wait <= 0 || wait > time.Minute {
+		t.Errorf("after lockout: wait %s, locked %v", wait, locked)
+	}
+	if _, locked := th.Check("u9", "", "bob"); locked {
+		t.Error("lockout spilled onto another account")
+	}
+	if !th.Forget("acc:alice") {
+		t.Fatal("Forget found no account record")
+	}
+	if _, locked := th.Check("u9", "", "alice"); locked {
+		t.Error("account still locked after Forget")
+	}
+}
+
+func TestThrottleLockoutDisabled(t *testing.T) {
+	th := NewLoginThrottle(0, time.Minute)
+	for i := 1; i <= 20; i++ {
+		if th.Fail("u"+strconv.Itoa(i), "", "alice") {
+			t.Fatalf("failure %d locked the account with lockout off", i)
+		}
+	}
+	if _, locked := th.Check("u99", "", "alice"); locked {
+		t.Error("account locked with lockout off")
+	}
+	cfg := Config{}
+	normalize(&cfg)
+	if cfg.LockoutThreshold != 0 {
+		t.Errorf("normalize turned a zero threshold into %d", cfg.LockoutThreshold)
+	}
+}
+
+func TestThrottlePurge(t *testing.T) {
+	th := NewLoginThrottle(3, time.Minute)
+	now := time.Now()
+	th.recs = map[string]*failRecord{
+		"uid:fresh":  {Count: 1, Last: now},
+		"host:old":   {Count: 4, Last: now.Add(-2 * throttleForget)},
+		"acc:locked": {Count: 0, Last: now.Add(-2 * throttleForget), Until: now.Add(time.Minute)},
+		"acc:done":   {Count: 0, Last: now.Add(-2 * throttleForget), Until: now.Add(-time.Minute)},
+	}
+	if n := th.Purge(); n != 2 {
+		t.Errorf("Purge = %d, want 2", n)
+	}
+	for k, want := range map[string]bool{"uid:fresh": true, "host:old": false, "acc:locked": true, "acc:done": false} {
+		if _, ok := th.recs[k]; ok != want {
+			t.Errorf("%s kept = %v, want %v", k, ok, want)
+		}
+	}
+}
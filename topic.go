@@ -0,0 +1,151 @@
+// topic.go
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Topics of registered channels. Q remembers the last topic with its setter
+// and time and puts it back when the channel is recreated after being
+// empty. With the topic lock on, only users with +t may change the topic;
+// other changes are reverted. settopic wraps the text in the channel's
+// optional prefix and suffix.
+
+type ChanTopic struct {
+	Text   string `json:"text,omitempty"`
+	SetBy  string `json:"set_by,omitempty"`
+	SetTS  int64  `json:"set_ts,omitempty"`
+	Lock   bool   `json:"lock,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+}
+
+// compose wraps text in the prefix and suffix.
+func (t ChanTopic) compose(text string) string {
+	var parts []string
+	for _, p := range []string{t.Prefix, text, t.Suffix} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// keep reports whether a topic change to text should stand; authorized says
+// whether whoever made it holds +t. With force set (the forcetopic channel
+// flag) nobody but Q may change it. A lock with no topic stored holds the
+// empty topic.
+func (t ChanTopic) keep(text string, authorized, force bool) bool {
+	if text == t.Text {
+		return true
+	}
+	if force {
+		return false
+	}
+	return !t.Lock || authorized
+}
+
+// onTopic handles a topic change on the network. uid is the user who made
+// it, "" for servers.
+func onTopic(l *Link, channel, uid, setter, text string) {
+	if cs := chans[toLower(channel)]; cs != nil {
+		cs.Topic = text
+	}
+	t, ok := acl.Topic(channel)
+	if !ok || uid == l.Cfg.ServiceUID {
+		return
+	}
+	if !t.keep(text, uid != "" && canControlChannel(uid, channel, 't'), chanFlag(channel, "forcetopic")) {
+		l.Topic(channel, t.Text)
+		if uid != "" {
+			l.NoticeFromService(uid, "The topic of "+channel+" is locked.")
+		}
+		return
+	}
+	t.Text, t.SetBy, t.SetTS = text, setter, time.Now().Unix()
+	acl.SetTopic(channel, t)
+	_ = acl.Save()
+}
+
+// restoreTopic puts a recreated channel's stored topic back.
+func restoreTopic(l *Link, channel string) {
+	if t, ok := acl.Topic(channel); ok && t.Text != "" {
+		l.Topic(channel, t.Text)
+	}
+}
+
+// doTopic runs settopic, cleartopic, topiclock, topicprefix or topicsuffix
+// on channel for fromUID and returns the lines to show. args are the words
+// after the channel name.
+func doTopic(l *Link, fromUID, cmd, channel string, args []string) []string {
+	t, ok := acl.Topic(channel)
+	if !ok {
+		return []string{channel + " is not registered."}
+	}
+	need := byte('t')
+	if cmd != "settopic" && cmd != "cleartopic" {
+		need = 'm'
+	}
+	if !canControlChannel(fromUID, channel, need) {
+		return []string{"You need +" + string(need) + " on " + channel + " to use " + cmd + "."}
+	}
+	arg := strings.Join(args, " ")
+
+	switch cmd {
+	case "settopic", "cleartopic":
+		if cmd == "settopic" && arg == "" {
+			return []string{"Usage: settopic <text>"}
+		}
+		text := ""
+		if cmd == "settopic" {
+			text = t.compose(arg)
+		}
+		l.Topic(channel, text)
+		t.Text, t.SetBy, t.SetTS = text, getNick(fromUID), time.Now().Unix()
+		acl.SetTopic(channel, t)
+		_ = acl.Save()
+		if text == "" {
+			return []string{"Topic of " + channel + " cleared."}
+		}
+		return []string{"Topic of " + channel + " set."}
+
+	case "topiclock":
+		switch strings.ToLower(arg) {
+		case "":
+			return []string{"Topic lock on " + channel + " is " + map[bool]string{true: "on", false: "off"}[t.Lock] + "."}
+		case "on", "off":
+			t.Lock = strings.EqualFold(arg, "on")
+			if t.Lock && t.SetTS == 0 {
+				if cs := chans[toLower(channel)]; cs != nil {
+					t.Text, t.SetBy, t.SetTS = cs.Topic, getNick(fromUID), time.Now().Unix()
+				}
+			}
+			acl.SetTopic(channel, t)
+			_ = acl.Save()
+			return []string{"Topic lock on " + channel + " is now " + strings.ToLower(arg) + "."}
+		}
+		return []string{"Usage: topiclock [on|off]"}
+
+	case "topicprefix", "topicsuffix":
+		field, name := &t.Prefix, "prefix"
+		if cmd == "topicsuffix" {
+			field, name = &t.Suffix, "suffix"
+		}
+		switch {
+		case arg == "":
+			if *field == "" {
+				return []string{channel + " has no topic " + name + "."}
+			}
+			return []string{"Topic " + name + " for " + channel + ": " + *field}
+		case strings.EqualFold(arg, "none"):
+			*field = ""
+		default:
+			*field = arg
+		}
+		acl.SetTopic(channel, t)
+		_ = acl.Save()
+		return []string{"Topic " + name + " for " + channel + " updated; it applies from the next settopic."}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestChanTopicCompose(t *testing.T) {
+	cases := []struct {
+		prefix, suffix, text, want string
+	}{
+		{"", "", "hello", "hello"},
+		{"[Official]", "", "hello", "[Official] hello"},
+		{"", "| rules: example.org", "hello", "hello | rules: example.org"},
+		{"<<", ">>", "", "<< >>"},
+	}
+	for _, c := range cases {
+		if got := (ChanTopic{Prefix: c.prefix, Suffix: c.suffix}).compose(c.text); got != c.want {
+			t.Errorf("compose(%q, %q, %q) = %q, want %q", c.prefix, c.text, c.suffix, got, c.want)
+		}
+	}
+}
+
+func TestChanTopicKeep(t *testing.T) {
+	locked := ChanTopic{Text: "official", SetTS: 1, Lock: true}
+	if locked.keep("graffiti", false, false) {
+		t.Error("locked topic kept an unauthorized change")
+	}
+	if !locked.keep("graffiti", true, false) {
+		t.Error("locked topic reverted a +t change")
+	}
+	if !locked.keep("official", false, false) {
+		t.Error("locked topic reverted itself")
+	}
+	if !(ChanTopic{Text: "x", SetTS: 1}).keep("y", false, false) {
+		t.Error("unlocked topic reverted a change")
+	}
+	if (ChanTopic{Text: "x", SetTS: 1}).keep("y", true, true) {
+		t.Error("forced topic kept a +t change")
+	}
+	if (ChanTopic{Lock: true}).keep("first", false, false) {
+		t.Error("lock with nothing stored kept an unauthorized topic")
+	}
+	if (ChanTopic{}).keep("first", true, true) {
+		t.Error("forced topic with nothing stored kept a change")
+	}
+	if !(ChanTopic{}).keep("first", false, false) {
+		t.Error("unlocked topic with nothing stored reverted a change")
+	}
+}
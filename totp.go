@@ -0,0 +1,168 @@
+// totp.go
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// RFC 6238 parameters, as expected by every common authenticator app.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // accept one step either side for clock drift
+
+	recoveryCodeCount = 8
+)
+
+// totpKey encrypts TOTP secrets at rest. Two-factor is unavailable while nil.
+var totpKey []byte
+
+// LoadTOTPKey derives the secret-encryption key from the contents of path.
+func LoadTOTPKey(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return errors.New("totp key file is empty")
+	}
+	sum := sha256.Sum256(b)
+	totpKey = sum[:]
+	return nil
+}
+
+func totpEnabled() bool { return len(totpKey) > 0 }
+
+func sealSecret(plain []byte) ([]byte, error) {
+	gcm, err := totpCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func openSecret(sealed []byte) ([]byte, error) {
+	gcm, err := totpCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed secret too short")
+	}
+	n := gcm.NonceSize()
+	return gcm.Open(nil, sealed[:n], sealed[n:], nil)
+}
+
+func totpCipher() (cipher.AEAD, error) {
+	if !totpEnabled() {
+		return nil, errors.New("two-factor authentication is not configured")
+	}
+	block, err := aes.NewCipher(totpKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newTOTPSecret() ([]byte, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%1000000)
+}
+
+// totpMatch returns the time step that code is valid for, or -1.
+func totpMatch(secret []byte, code string, now time.Time) int64 {
+	cur := now.Unix() / totpPeriod
+	for d := int64(-totpSkew); d <= totpSkew; d++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, cur+d)), []byte(code)) == 1 {
+			return cur + d
+		}
+	}
+	return -1
+}
+
+func base32Secret(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+func otpauthURI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", base32Secret(secret))
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprint(totpPeriod))
+	v.Set("digits", fmt.Sprint(totpDigits))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + v.Encode()
+}
+
+// newRecoveryCodes returns the plain codes to show once, and their hashes.
+func newRecoveryCodes() (plain, hashed []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		c, err := randomToken(5)
+		if err != nil {
+			return nil, nil, err
+		}
+		plain = append(plain, c)
+		hashed = append(hashed, hashToken(c))
+	}
+	return plain, hashed, nil
+}
+
+// checkSecondFactor verifies a TOTP or recovery code for an account that has
+// two-factor enabled. Accounts without it always pass.
+func checkSecondFactor(acc, code string) bool {
+	a, ok := accDB.Get(acc)
+	if !ok {
+		return false
+	}
+	if len(a.TOTPSecret) == 0 {
+		return true
+	}
+	if code == "" {
+		return false
+	}
+	if secret, err := openSecret(a.TOTPSecret); err == nil {
+		if step := totpMatch(secret, code, time.Now()); step >= 0 {
+			// refuse to accept the same code twice, across restarts too
+			if !accDB.UseTOTPStep(acc, step) {
+				return false
+			}
+			_ = accDB.Save()
+			return true
+		}
+	}
+	if accDB.UseRecoveryCode(acc, code) {
+		_ = accDB.Save()
+		return true
+	}
+	return false
+}
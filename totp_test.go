@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B vectors (SHA-1), truncated to six digits.
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode(t=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPMatchWindow(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1234567890, 0)
+	cur := now.Unix() / totpPeriod
+	tests := []struct {
+		name string
+		step int64
+		want int64
+	}{
+		{"current", cur, cur},
+		{"one behind", cur - 1, cur - 1},
+		{"one ahead", cur + 1, cur + 1},
+		{"two behind", cur - 2, -1},
+		{"two ahead", cur + 2, -1},
+	}
+	for _, tt := range tests {
+		if got := totpMatch(secret, totpCode(secret, tt.step), now); got != tt.want {
+			t.Errorf("%s: totpMatch = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	old := totpKey
+	defer func() { totpKey = old }()
+
+	totpKey = nil
+	if _, err := sealSecret([]byte("x")); err == nil {
+		t.Error("sealSecret worked without a key")
+	}
+	path := filepath.Join(t.TempDir(), "totp.key")
+	writeFile(t, path, "key material\n")
+	if err := LoadTOTPKey(path); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealSecret([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := openSecret(sealed)
+	if err != nil || string(plain) != "secret" {
+		t.Fatalf("openSecret = %q, %v", plain, err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := openSecret(sealed); err == nil {
+		t.Error("openSecret accepted a tampered secret")
+	}
+}
+
+// TestSecondFactorReplay checks that a code works once, and that recovery
+// codes are single-use.
+func TestSecondFactorReplay(t *testing.T) {
+	oldKey, oldDB := totpKey, accDB
+	defer func() { totpKey, accDB = oldKey, oldDB }()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "totp.key"), "key material")
+	if err := LoadTOTPKey(filepath.Join(dir, "totp.key")); err != nil {
+		t.Fatal(err)
+	}
+	accDB = NewAccountDB(filepath.Join(dir, "accounts.json"))
+	if err := accDB.Create("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := newTOTPSecret()
+	sealed, err := sealSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, hashed, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = accDB.SetTOTPPending("alice", sealed)
+	_ = accDB.ConfirmTOTP("alice", hashed)
+
+	code := totpCode(secret, time.Now().Unix()/totpPeriod)
+	if err := accDB.Save(); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name    string
+		code    string
+		restart bool // reload the accounts from disk first
+		want    bool
+	}{
+		{"no code", "", false, false},
+		{"fresh code", code, false, true},
+		{"replayed code", code, false, false},
+		{"replayed after restart", code, true, false},
+		{"recovery code", plain[0], false, true},
+		{"recovery code again", plain[0], true, false},
+		{"other recovery code", plain[1], false, true},
+		{"garbage", "nope", false, false},
+	}
+	for _, s := range steps {
+		if s.restart {
+			accDB = NewAccountDB(filepath.Join(dir, "accounts.json"))
+			if err := accDB.Load(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := checkSecondFactor("alice", s.code); got != s.want {
+			t.Errorf("%s: checkSecondFactor = %v, want %v", s.name, got, s.want)
+		}
+	}
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	db := NewAccountDB(filepath.Join(t.TempDir(), "accounts.json"))
+	if err := db.Create("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		step int64
+		want bool
+	}{
+		{100, true},
+		{100, false},
+		{99, false},
+		{101, true},
+	}
+	for _, s := range steps {
+		if got := db.UseTOTPStep("alice", s.step); got != s.want {
+			t.Errorf("UseTOTPStep(%d) = %v, want %v", s.step, got, s.want)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}